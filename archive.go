@@ -0,0 +1,613 @@
+package wpk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PAX records names to keep tags which have no fields in tar header.
+// They are stored as extended attributes with freedesktop.org names,
+// so the tools which support extended attributes can restore them.
+const (
+	PAXmime    = "SCHILY.xattr.user.mime_type"
+	PAXcomment = "SCHILY.xattr.user.xdg.comment"
+)
+
+var (
+	ErrArcDir     = errors.New("empty directory can not be kept at package")
+	ErrArcLinkOut = errors.New("link refers out of archive")
+	ErrArcLinkDir = errors.New("link to directory can not be kept at package")
+	ErrArcSpecial = errors.New("special file can not be kept at package")
+)
+
+// ArcHook is called for each file packed from archive,
+// and returns adjusted tagset of it.
+type ArcHook func(fkey string, ts TagsetRaw) TagsetRaw
+
+// ArcSkipFunc is called for each archive entry that was not packed,
+// with the reason of it.
+type ArcSkipFunc func(name string, err error)
+
+// ArcOpts is the set of options for archives import.
+type ArcOpts struct {
+	Prefix string      // path prefix for files keys at package
+	Policy MergePolicy // policy for keys which are already present at package
+	Suffix string      // suffix for renamed duplicates, MergeSuffix if empty
+	Hook   ArcHook     // adjusts tagset of each packed file
+	Skip   ArcSkipFunc // called for each skipped archive entry
+
+	// Mime returns MIME type of file with given name and first bytes
+	// of content, result is put to TIDmime if it's not empty.
+	Mime func(fkey string, data []byte) string
+}
+
+// ArcKey brings archive entry name to package key with given prefix.
+// Parent references can not lead out of archive root.
+// Returns false if name points to archive root itself.
+func ArcKey(prefix, name string) (string, bool) {
+	name = path.Clean("/" + ToSlash(name))[1:]
+	if name == "" {
+		return "", false
+	}
+	return JoinPath(ToSlash(prefix), name), true
+}
+
+// arcimport is the state of single archive import.
+type arcimport struct {
+	pkg     *Package
+	opts    *ArcOpts
+	links   []arclink         // links to resolve after all files are packed
+	dirs    []arclink         // directories to check up that they are not empty
+	renamed map[string]string // keys of renamed duplicates
+}
+
+// arclink is symbolic link or directory met in archive.
+type arclink struct {
+	name   string // name of archive entry
+	fkey   string // key of entry at package
+	target string // key of file referred by link
+}
+
+func newarcimport(pkg *Package, opts *ArcOpts) *arcimport {
+	return &arcimport{
+		pkg:     pkg,
+		opts:    opts,
+		renamed: map[string]string{},
+	}
+}
+
+// skip reports about archive entry that was not packed.
+func (ai *arcimport) skip(name string, err error) {
+	if ai.opts.Skip != nil {
+		ai.opts.Skip(name, err)
+	}
+}
+
+// dupkey returns the key to put archive entry into package by duplicates policy.
+// Returns false if entry should be skipped.
+func (ai *arcimport) dupkey(name, fkey string) (string, bool, error) {
+	if !ai.pkg.HasTagset(fkey) {
+		return fkey, true, nil
+	}
+	switch ai.opts.Policy {
+	case MergeLast:
+		ai.pkg.DelTagset(fkey)
+	case MergeError:
+		return "", false, &fs.PathError{Op: "packarchive", Path: fkey, Err: fs.ErrExist}
+	case MergeRename:
+		var suffix = ai.opts.Suffix
+		if suffix == "" {
+			suffix = MergeSuffix
+		}
+		var newkey string
+		for n := 1; ; n++ {
+			if newkey = DupName(fkey, suffix, n); !ai.pkg.HasTagset(newkey) {
+				break
+			}
+		}
+		ai.renamed[fkey] = newkey
+		return newkey, true, nil
+	default: // MergeFirst
+		ai.skip(name, fs.ErrExist)
+		return "", false, nil
+	}
+	return fkey, true, nil
+}
+
+// link remembers symbolic link with given target to resolve it later.
+// Absolute targets are always refer out of archive.
+func (ai *arcimport) link(name, fkey, target string) {
+	target = ToSlash(target)
+	if path.IsAbs(target) {
+		ai.skip(name, ErrArcLinkOut)
+		return
+	}
+	ai.hardlink(name, fkey, path.Join(path.Dir(ToSlash(name)), target))
+}
+
+// hardlink remembers link with target given relative to archive root.
+func (ai *arcimport) hardlink(name, fkey, target string) {
+	if tkey, ok := ArcKey(ai.opts.Prefix, target); ok {
+		ai.links = append(ai.links, arclink{name, fkey, tkey})
+	} else {
+		ai.skip(name, ErrArcLinkDir) // refers to archive root
+	}
+}
+
+// pack puts content of file into package, and puts its MIME type
+// detected by options Mime function.
+func (ai *arcimport) pack(w io.WriteSeeker, r io.Reader, fkey string) (ts TagsetRaw, err error) {
+	if ai.opts.Mime == nil {
+		return ai.pkg.PackData(w, r, fkey)
+	}
+	var sr = sniffreader{Reader: r}
+	if ts, err = ai.pkg.PackData(w, &sr, fkey); err != nil {
+		return
+	}
+	if ctype := ai.opts.Mime(fkey, sr.sniff); ctype != "" {
+		ts = ts.Put(TIDmime, StrTag(ctype))
+	}
+	return
+}
+
+// sniffLen is the size of content prefix passed to MIME detection.
+const sniffLen = 512
+
+// sniffreader keeps first bytes of read content for MIME detection.
+type sniffreader struct {
+	io.Reader
+	sniff []byte
+}
+
+func (sr *sniffreader) Read(p []byte) (n int, err error) {
+	n, err = sr.Reader.Read(p)
+	if l := sniffLen - len(sr.sniff); l > 0 {
+		if l > n {
+			l = n
+		}
+		sr.sniff = append(sr.sniff, p[:l]...)
+	}
+	return
+}
+
+// put sets tagset of packed file adjusted by the hook.
+func (ai *arcimport) put(fkey string, ts TagsetRaw) {
+	if ai.opts.Hook != nil {
+		ts = ai.opts.Hook(fkey, ts)
+	}
+	ai.pkg.SetTagset(fkey, ts)
+}
+
+// finish makes aliases for symbolic links which targets are present in package,
+// and reports about links and directories that can not be kept at package.
+// Links can refer to other links, so it repeats while any link was resolved.
+func (ai *arcimport) finish() (n int, err error) {
+	var isdir = func(fkey string) bool {
+		var _, err = ai.pkg.Sub(fkey)
+		return err == nil
+	}
+	var links = ai.links
+	for len(links) > 0 {
+		var rest = links[:0]
+		for _, l := range links {
+			if newkey, ok := ai.renamed[l.target]; ok {
+				l.target = newkey
+			}
+			if !ai.pkg.HasTagset(l.target) {
+				rest = append(rest, l)
+				continue
+			}
+			var fkey string
+			var ok bool
+			if fkey, ok, err = ai.dupkey(l.name, l.fkey); err != nil {
+				return
+			} else if !ok {
+				continue
+			}
+			if err = ai.pkg.PutAlias(l.target, fkey); err != nil {
+				return
+			}
+			n++
+		}
+		if len(rest) == len(links) {
+			break // links are refer out of package
+		}
+		links = rest
+	}
+	for _, l := range links {
+		if isdir(l.target) {
+			ai.skip(l.name, ErrArcLinkDir)
+		} else {
+			ai.skip(l.name, ErrArcLinkOut)
+		}
+	}
+	for _, d := range ai.dirs {
+		if !isdir(d.fkey) {
+			ai.skip(d.name, ErrArcDir)
+		}
+	}
+	return
+}
+
+// arctags puts archive entry modification time and mode bits to tagset.
+func arctags(ts TagsetRaw, mtime time.Time, mode fs.FileMode) TagsetRaw {
+	if !mtime.IsZero() {
+		ts = ts.Put(TIDmtime, TimeTag(mtime))
+	}
+	return ts.Put(TIDattr, Uint32Tag(uint32(mode)))
+}
+
+// PackZip puts files of given zip archive into package.
+// Modification time of each entry puts to TIDmtime, mode bits to TIDattr.
+// Symbolic links are turned to aliases if they refer to files inside of archive.
+// Directories are implicit at package, so they are present if they have files.
+// Links to directories, links out of archive, empty directories and special
+// files can not be kept at package, they are reported by options Skip function.
+// Keys already present at package are resolved by options policy.
+// MIME type of each file is detected by options Mime function.
+// Returns number of packed files and aliases.
+func (pkg *Package) PackZip(w io.WriteSeeker, zr *zip.Reader, opts ArcOpts) (n int, err error) {
+	var ai = newarcimport(pkg, &opts)
+	for _, zf := range zr.File {
+		var fkey, ok = ArcKey(opts.Prefix, zf.Name)
+		if !ok {
+			continue
+		}
+		var mode = zf.Mode()
+		switch {
+		case mode.IsDir():
+			ai.dirs = append(ai.dirs, arclink{name: zf.Name, fkey: fkey})
+			continue
+		case mode&fs.ModeSymlink != 0:
+			var r io.ReadCloser
+			if r, err = zf.Open(); err != nil {
+				return
+			}
+			var b []byte
+			b, err = io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return
+			}
+			ai.link(zf.Name, fkey, B2S(b))
+			continue
+		case !mode.IsRegular():
+			ai.skip(zf.Name, ErrArcSpecial)
+			continue
+		}
+		if fkey, ok, err = ai.dupkey(zf.Name, fkey); err != nil {
+			return
+		} else if !ok {
+			continue
+		}
+
+		var r io.ReadCloser
+		if r, err = zf.Open(); err != nil {
+			return
+		}
+		var ts TagsetRaw
+		ts, err = ai.pack(w, r, fkey)
+		r.Close()
+		if err != nil {
+			return
+		}
+		ts = arctags(ts, zf.Modified, mode)
+		if zf.Comment != "" {
+			ts = ts.Put(TIDcomment, StrTag(zf.Comment))
+		}
+		ai.put(fkey, ts)
+		n++
+	}
+	var na int
+	na, err = ai.finish()
+	n += na
+	return
+}
+
+// PackTar puts files of tar archive streamed by given reader into package.
+// Modification time of each entry puts to TIDmtime, mode bits to TIDattr.
+// Symbolic and hard links are turned to aliases if they refer to files
+// inside of archive. Directories are implicit at package, so they are present
+// if they have files. Links to directories, links out of archive, empty
+// directories and special files can not be kept at package, they are reported
+// by options Skip function. Keys already present at package are resolved
+// by options policy. MIME type of each file is taken from PAX record if it
+// present, or detected by options Mime function.
+// Returns number of packed files and aliases.
+func (pkg *Package) PackTar(w io.WriteSeeker, r io.Reader, opts ArcOpts) (n int, err error) {
+	var ai = newarcimport(pkg, &opts)
+	var tr = tar.NewReader(r)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			return
+		}
+		var fkey, ok = ArcKey(opts.Prefix, hdr.Name)
+		if !ok {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+		case tar.TypeDir:
+			ai.dirs = append(ai.dirs, arclink{name: hdr.Name, fkey: fkey})
+			continue
+		case tar.TypeSymlink:
+			ai.link(hdr.Name, fkey, hdr.Linkname)
+			continue
+		case tar.TypeLink:
+			ai.hardlink(hdr.Name, fkey, hdr.Linkname)
+			continue
+		default:
+			ai.skip(hdr.Name, ErrArcSpecial)
+			continue
+		}
+		if fkey, ok, err = ai.dupkey(hdr.Name, fkey); err != nil {
+			return
+		} else if !ok {
+			continue
+		}
+
+		var ts TagsetRaw
+		if ts, err = ai.pack(w, tr, fkey); err != nil {
+			return
+		}
+		ts = arctags(ts, hdr.ModTime, hdr.FileInfo().Mode())
+		if str, ok := hdr.PAXRecords[PAXmime]; ok {
+			ts = ts.Set(TIDmime, StrTag(str))
+		}
+		if str, ok := hdr.PAXRecords[PAXcomment]; ok {
+			ts = ts.Put(TIDcomment, StrTag(str))
+		}
+		ai.put(fkey, ts)
+		n++
+	}
+	var na int
+	na, err = ai.finish()
+	n += na
+	return
+}
+
+// IsArchive checks up that given file name has extension
+// of archive format supported by PackArchive.
+func IsArchive(fpath string) bool {
+	var name = ToLower(fpath)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// PackArchive puts files of zip or tar archive with given file name into package.
+// Archive format is detected by file extension, tar archives can be gzip-compressed
+// with ".tar.gz" or ".tgz" extension.
+func (pkg *Package) PackArchive(w io.WriteSeeker, fpath string, opts ArcOpts) (n int, err error) {
+	var name = ToLower(fpath)
+	if strings.HasSuffix(name, ".zip") {
+		var zr *zip.ReadCloser
+		if zr, err = zip.OpenReader(fpath); err != nil {
+			return
+		}
+		defer zr.Close()
+		return pkg.PackZip(w, &zr.Reader, opts)
+	}
+	if !IsArchive(name) {
+		err = &fs.PathError{Op: "packarchive", Path: fpath, Err: fs.ErrInvalid}
+		return
+	}
+
+	var f *os.File
+	if f, err = os.Open(fpath); err != nil {
+		return
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(f); err != nil {
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return pkg.PackTar(w, r, opts)
+}
+
+// ArcFormat is archive format for export.
+type ArcFormat int
+
+const (
+	ArcZip ArcFormat = iota // zip archive with deflate compression
+	ArcTar                  // uncompressed tar archive
+	ArcTgz                  // gzip-compressed tar archive
+)
+
+// ArcFiles returns sorted list of all files keys in given file system,
+// that can be a package, its subdirectory, or a union.
+func ArcFiles(fsys fs.FS) (list []string, err error) {
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		var des, err = fs.ReadDir(fsys, dir)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		sort.Slice(des, func(i, j int) bool {
+			return des[i].Name() < des[j].Name()
+		})
+		for _, de := range des {
+			var fkey = JoinPath(dir, de.Name())
+			if de.IsDir() {
+				if err = walk(fkey); err != nil {
+					return err
+				}
+			} else {
+				list = append(list, fkey)
+			}
+		}
+		return nil
+	}
+	err = walk(".")
+	return
+}
+
+// arcinfo returns modification time and mode of nested file
+// with given file info and tagset, with defaults for absent tags.
+func arcinfo(fi fs.FileInfo, ts TagsetRaw) (mtime time.Time, mode fs.FileMode) {
+	if mtime = fi.ModTime(); mtime.IsZero() {
+		mtime = time.Unix(0, 0)
+	}
+	if attr, ok := ts.TagUint32(TIDattr); ok {
+		mode = fs.FileMode(attr).Perm()
+	} else {
+		mode = 0644
+	}
+	return
+}
+
+// arcopen opens nested file and returns it with its file info and tagset.
+// File info gives the size of content, that can differ from stored size
+// if tagger decodes the content, tagset gives optional metadata, and it's
+// empty if file system is not a package.
+func arcopen(fsys fs.FS, fkey string) (f fs.File, fi fs.FileInfo, ts TagsetRaw, err error) {
+	if f, err = fsys.Open(fkey); err != nil {
+		return
+	}
+	if fi, err = f.Stat(); err != nil {
+		f.Close()
+		return
+	}
+	ts, _ = fi.Sys().(TagsetRaw)
+	return
+}
+
+// ExportZip writes all files of given file system to zip archive streamed
+// to given writer. File system can be a package, its subdirectory, or a union.
+// Zip header fields are filled by file info and TIDattr and TIDcomment tags.
+func ExportZip(w io.Writer, fsys fs.FS) (err error) {
+	var list []string
+	if list, err = ArcFiles(fsys); err != nil {
+		return
+	}
+
+	var zw = zip.NewWriter(w)
+	for _, fkey := range list {
+		if err = func() (err error) {
+			var f fs.File
+			var fi fs.FileInfo
+			var ts TagsetRaw
+			if f, fi, ts, err = arcopen(fsys, fkey); err != nil {
+				return
+			}
+			defer f.Close()
+
+			var mtime, mode = arcinfo(fi, ts)
+			var fh = zip.FileHeader{
+				Name:     fkey,
+				Method:   zip.Deflate,
+				Modified: mtime,
+			}
+			fh.SetMode(mode)
+			fh.Comment, _ = ts.TagStr(TIDcomment)
+			var zf io.Writer
+			if zf, err = zw.CreateHeader(&fh); err != nil {
+				return
+			}
+			_, err = io.Copy(zf, f)
+			return
+		}(); err != nil {
+			return
+		}
+	}
+	return zw.Close()
+}
+
+// ExportTar writes all files of given file system to tar archive streamed
+// to given writer. File system can be a package, its subdirectory, or a union.
+// Tar header fields are filled by file info and TIDattr tag, TIDmime and
+// TIDcomment tags are written to PAX records.
+func ExportTar(w io.Writer, fsys fs.FS) (err error) {
+	var list []string
+	if list, err = ArcFiles(fsys); err != nil {
+		return
+	}
+
+	var tw = tar.NewWriter(w)
+	for _, fkey := range list {
+		if err = func() (err error) {
+			var f fs.File
+			var fi fs.FileInfo
+			var ts TagsetRaw
+			if f, fi, ts, err = arcopen(fsys, fkey); err != nil {
+				return
+			}
+			defer f.Close()
+
+			var mtime, mode = arcinfo(fi, ts)
+			var hdr = tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     fkey,
+				Size:     fi.Size(),
+				Mode:     int64(mode),
+				ModTime:  mtime,
+			}
+			if str, ok := ts.TagStr(TIDmime); ok {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = map[string]string{}
+				}
+				hdr.PAXRecords[PAXmime] = str
+			}
+			if str, ok := ts.TagStr(TIDcomment); ok {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = map[string]string{}
+				}
+				hdr.PAXRecords[PAXcomment] = str
+			}
+			if hdr.PAXRecords != nil {
+				hdr.Format = tar.FormatPAX
+			}
+			if err = tw.WriteHeader(&hdr); err != nil {
+				return
+			}
+			_, err = io.Copy(tw, f)
+			return
+		}(); err != nil {
+			return
+		}
+	}
+	return tw.Close()
+}
+
+// ExportArchive writes all files of given file system
+// to archive of given format streamed to given writer.
+func ExportArchive(w io.Writer, fsys fs.FS, format ArcFormat) (err error) {
+	switch format {
+	case ArcZip:
+		return ExportZip(w, fsys)
+	case ArcTar:
+		return ExportTar(w, fsys)
+	case ArcTgz:
+		var gw = gzip.NewWriter(w)
+		if err = ExportTar(gw, fsys); err != nil {
+			return
+		}
+		return gw.Close()
+	}
+	return fs.ErrInvalid
+}
+
+// The End.
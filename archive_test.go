@@ -0,0 +1,491 @@
+package wpk_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+var arcmtime = time.Date(2023, 5, 17, 12, 30, 0, 0, time.UTC)
+
+// CheckArchived checks up package content made from archive with memdata files.
+func CheckArchived(t *testing.T, fwpk *os.File, aliases []string) {
+	var err error
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenStream(fwpk); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(fwpk.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	if n := pkg.TagsetNum(); n != len(memdata)+len(aliases) {
+		t.Fatalf("expected %d entries in package, got %d", len(memdata)+len(aliases), n)
+	}
+	for name, orig := range memdata {
+		var fkey = "arc/" + name
+		var ts, ok = pkg.GetTagset(fkey)
+		if !ok {
+			t.Fatalf("file '%s' not found", fkey)
+		}
+		if mtime, _ := ts.TagTime(wpk.TIDmtime); !mtime.Equal(arcmtime) {
+			t.Errorf("file '%s' has modification time %s, expected %s", fkey, mtime, arcmtime)
+		}
+		if attr, _ := ts.TagUint32(wpk.TIDattr); fs.FileMode(attr).Perm() != 0640 {
+			t.Errorf("file '%s' has mode %s, expected 0640", fkey, fs.FileMode(attr))
+		}
+		var data []byte
+		if data, err = pkg.ReadFile(fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(orig, data) {
+			t.Errorf("content of '%s' is not equal to original", fkey)
+		}
+	}
+	for _, fkey := range aliases {
+		var ts1, _ = pkg.GetTagset("arc/sample.txt")
+		var ts2, ok = pkg.GetTagset(fkey)
+		if !ok {
+			t.Fatalf("alias '%s' not found", fkey)
+		}
+		var off1, _ = ts1.Pos()
+		var off2, _ = ts2.Pos()
+		if off1 != off2 {
+			t.Errorf("alias '%s' does not refers to 'sample.txt'", fkey)
+		}
+	}
+}
+
+// CheckSkipped checks up that archive entries were skipped with expected reasons.
+func CheckSkipped(t *testing.T, skipped, expected map[string]error) {
+	t.Helper()
+	if len(skipped) != len(expected) {
+		t.Errorf("expected %d skipped entries, got %d: %v", len(expected), len(skipped), skipped)
+	}
+	for name, reason := range expected {
+		if err := skipped[name]; !errors.Is(err, reason) {
+			t.Errorf("entry '%s' skipped with reason '%v', expected '%v'", name, err, reason)
+		}
+	}
+}
+
+// Test import from zip archive.
+func TestPackZip(t *testing.T) {
+	var err error
+	var buf bytes.Buffer
+	var zw = zip.NewWriter(&buf)
+	var put = func(name string, mode fs.FileMode, data []byte) {
+		var fh = zip.FileHeader{
+			Name:     name,
+			Modified: arcmtime,
+		}
+		fh.SetMode(mode)
+		var w, err = zw.CreateHeader(&fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("dir/", fs.ModeDir|0750, nil)
+	for name, data := range memdata {
+		put(name, 0640, data)
+	}
+	put("dir/link.txt", fs.ModeSymlink|0777, []byte("../sample.txt"))
+	put("dir/up", fs.ModeSymlink|0777, []byte(".."))
+	put("outer.txt", fs.ModeSymlink|0777, []byte("/etc/hosts"))
+	put("empty/", fs.ModeDir|0750, nil)
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var zr *zip.Reader
+	if zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(testpack)
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpk.Close()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var skipped = map[string]error{}
+	if n, err = pkg.PackZip(fwpk, zr, wpk.ArcOpts{
+		Prefix: "arc",
+		Skip: func(name string, err error) {
+			skipped[name] = err
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(memdata)+1 {
+		t.Fatalf("expected %d packed entries, got %d", len(memdata)+1, n)
+	}
+	CheckSkipped(t, skipped, map[string]error{
+		"dir/up":    wpk.ErrArcLinkDir,
+		"outer.txt": wpk.ErrArcLinkOut,
+		"empty/":    wpk.ErrArcDir,
+	})
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	CheckArchived(t, fwpk, []string{"arc/dir/link.txt"})
+}
+
+// Test import from gzip-compressed tar archive.
+func TestPackTar(t *testing.T) {
+	var err error
+	var buf bytes.Buffer
+	var gw = gzip.NewWriter(&buf)
+	var tw = tar.NewWriter(gw)
+	var put = func(hdr *tar.Header, data []byte) {
+		hdr.ModTime = arcmtime
+		hdr.Size = int64(len(data))
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(&tar.Header{Typeflag: tar.TypeDir, Name: "./dir/", Mode: 0750}, nil)
+	for name, data := range memdata {
+		put(&tar.Header{Typeflag: tar.TypeReg, Name: "./" + name, Mode: 0640}, data)
+	}
+	put(&tar.Header{Typeflag: tar.TypeSymlink, Name: "./dir/link.txt", Linkname: "../sample.txt", Mode: 0777}, nil)
+	put(&tar.Header{Typeflag: tar.TypeLink, Name: "./hard.txt", Linkname: "./sample.txt", Mode: 0640}, nil)
+	put(&tar.Header{Typeflag: tar.TypeSymlink, Name: "./lost.txt", Linkname: "dir/none.txt", Mode: 0777}, nil)
+	put(&tar.Header{Typeflag: tar.TypeFifo, Name: "./pipe", Mode: 0640}, nil)
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var arcpath = wpk.TempPath("testpack.tar.gz")
+	defer os.Remove(arcpath)
+	if err = os.WriteFile(arcpath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(testpack)
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpk.Close()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	var skipped = map[string]error{}
+	if _, err = pkg.PackArchive(fwpk, arcpath, wpk.ArcOpts{
+		Prefix: "arc",
+		Skip: func(name string, err error) {
+			skipped[name] = err
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	CheckSkipped(t, skipped, map[string]error{
+		"./lost.txt": wpk.ErrArcLinkOut,
+		"./pipe":     wpk.ErrArcSpecial,
+	})
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	CheckArchived(t, fwpk, []string{"arc/dir/link.txt", "arc/hard.txt"})
+}
+
+// Test MIME types of files packed from archive.
+func TestPackTarMime(t *testing.T) {
+	var err error
+	var buf bytes.Buffer
+	var tw = tar.NewWriter(&buf)
+	var content = bytes.Repeat([]byte("0123456789"), 100)
+	for _, hdr := range []*tar.Header{
+		{Typeflag: tar.TypeReg, Name: "plain.dat", Mode: 0640},
+		{Typeflag: tar.TypeReg, Name: "typed.dat", Mode: 0640, PAXRecords: map[string]string{
+			wpk.PAXmime: "application/x-typed",
+		}},
+	} {
+		hdr.ModTime = arcmtime
+		hdr.Size = int64(len(content))
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(testpack)
+	defer fwpk.Close()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pkg.PackTar(fwpk, &buf, wpk.ArcOpts{
+		Mime: func(fkey string, data []byte) string {
+			if !bytes.HasPrefix(content, data) || len(data) == 0 || len(data) > 512 {
+				t.Errorf("unexpected content given to detect MIME type of '%s'", fkey)
+			}
+			return "application/x-detected"
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for fkey, expect := range map[string]string{
+		"plain.dat": "application/x-detected",
+		"typed.dat": "application/x-typed",
+	} {
+		var ts, _ = pkg.GetTagset(fkey)
+		if ctype, _ := ts.TagStr(wpk.TIDmime); ctype != expect {
+			t.Errorf("MIME type of '%s' is '%s', expected '%s'", fkey, ctype, expect)
+		}
+	}
+}
+
+// Test policies for duplicate keys on import from archive.
+func TestPackZipDup(t *testing.T) {
+	var err error
+	var buf bytes.Buffer
+	var zw = zip.NewWriter(&buf)
+	var put = func(name string, mode fs.FileMode, data []byte) {
+		var fh = zip.FileHeader{Name: name, Method: zip.Deflate}
+		fh.SetMode(mode)
+		var w, err = zw.CreateHeader(&fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var data1, data2 = []byte("first content"), []byte("last content")
+	put("sample.txt", 0640, data1)
+	put("sample.txt", 0640, data2)
+	put("copy.txt", fs.ModeSymlink|0777, []byte("sample.txt"))
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var zr *zip.Reader
+	if zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+
+	var pack = func(policy wpk.MergePolicy) (pkg *wpk.Package, skipped map[string]error, err error) {
+		t.Helper()
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+		pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		skipped = map[string]error{}
+		if _, err = pkg.PackZip(fwpk, zr, wpk.ArcOpts{
+			Policy: policy,
+			Skip: func(name string, err error) {
+				skipped[name] = err
+			},
+		}); err != nil {
+			return
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		if pkg.Tagger, err = bulk.MakeTagger(testpack); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	var check = func(pkg *wpk.Package, fkey string, orig []byte) {
+		t.Helper()
+		var data, err = pkg.ReadFile(fkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, orig) {
+			t.Errorf("file '%s' has content '%s', expected '%s'", fkey, data, orig)
+		}
+	}
+	defer os.Remove(testpack)
+
+	var pkg *wpk.Package
+	var skipped map[string]error
+	if pkg, skipped, err = pack(wpk.MergeFirst); err != nil {
+		t.Fatal(err)
+	}
+	check(pkg, "sample.txt", data1)
+	check(pkg, "copy.txt", data1)
+	CheckSkipped(t, skipped, map[string]error{"sample.txt": fs.ErrExist})
+	pkg.Close()
+
+	if pkg, skipped, err = pack(wpk.MergeLast); err != nil {
+		t.Fatal(err)
+	}
+	check(pkg, "sample.txt", data2)
+	check(pkg, "copy.txt", data2)
+	CheckSkipped(t, skipped, map[string]error{})
+	pkg.Close()
+
+	if pkg, skipped, err = pack(wpk.MergeRename); err != nil {
+		t.Fatal(err)
+	}
+	check(pkg, "sample.txt", data1)
+	check(pkg, "sample-dup.txt", data2)
+	check(pkg, "copy.txt", data2)
+	CheckSkipped(t, skipped, map[string]error{})
+	pkg.Close()
+
+	if _, _, err = pack(wpk.MergeError); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("duplicate file was packed with error policy, error: %v", err)
+	}
+}
+
+// Test export of package, its subdirectory and union to archives.
+func TestExport(t *testing.T) {
+	PackFiles(t, testpack1, []string{
+		"bounty.jpg",
+		"img1/claustral.jpg",
+		"img2/marble.jpg",
+	})
+	PackFiles(t, testpack2, []string{
+		"img1/Qarataşlar.jpg",
+		"img2/Uzuncı.jpg",
+	})
+	defer os.Remove(testpack1)
+	defer os.Remove(testpack2)
+
+	var err error
+	var pack1 = wpk.NewPackage()
+	if err = pack1.OpenFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	if pack1.Tagger, err = bulk.MakeTagger(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	var pack2 = wpk.NewPackage()
+	if err = pack2.OpenFile(testpack2); err != nil {
+		t.Fatal(err)
+	}
+	if pack2.Tagger, err = bulk.MakeTagger(testpack2); err != nil {
+		t.Fatal(err)
+	}
+	var u = wpk.Union{List: []*wpk.Package{pack1, pack2}}
+	defer u.Close()
+
+	var ts, _ = pack1.GetTagset("img1/claustral.jpg")
+	pack1.SetTagset("img1/claustral.jpg", wpk.CopyTagset(ts).
+		Put(wpk.TIDmime, wpk.StrTag("image/jpeg")).
+		Put(wpk.TIDcomment, wpk.StrTag("basalt bay")))
+	var sub, _ = pack1.Sub("img1")
+
+	var checkzip = func(fsys fs.FS, names ...string) {
+		var buf bytes.Buffer
+		if err = wpk.ExportArchive(&buf, fsys, wpk.ArcZip); err != nil {
+			t.Fatal(err)
+		}
+		var zr *zip.Reader
+		if zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != len(names) {
+			t.Fatalf("expected %d files in zip, got %d", len(names), len(zr.File))
+		}
+		for i, zf := range zr.File {
+			if zf.Name != names[i] {
+				t.Fatalf("expected file '%s' in zip, got '%s'", names[i], zf.Name)
+			}
+			if wpk.PathName(zf.Name) == "claustral" && zf.Comment != "basalt bay" {
+				t.Fatalf("expected comment for '%s' in zip", zf.Name)
+			}
+		}
+	}
+	checkzip(pack1, "bounty.jpg", "img1/claustral.jpg", "img2/marble.jpg")
+	checkzip(sub, "claustral.jpg")
+	checkzip(&u, "bounty.jpg", "img1/Qarataşlar.jpg", "img1/claustral.jpg",
+		"img2/Uzuncı.jpg", "img2/marble.jpg")
+
+	var buf bytes.Buffer
+	if err = wpk.ExportArchive(&buf, sub, wpk.ArcTgz); err != nil {
+		t.Fatal(err)
+	}
+	var gr *gzip.Reader
+	if gr, err = gzip.NewReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var tr = tar.NewReader(gr)
+	var hdr *tar.Header
+	if hdr, err = tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "claustral.jpg" {
+		t.Fatalf("expected file 'claustral.jpg' in tar, got '%s'", hdr.Name)
+	}
+	if hdr.PAXRecords[wpk.PAXmime] != "image/jpeg" || hdr.PAXRecords[wpk.PAXcomment] != "basalt bay" {
+		t.Fatal("tags does not passed to PAX records")
+	}
+	var orig, data []byte
+	if orig, err = os.ReadFile(mediadir + "img1/claustral.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = io.ReadAll(tr); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(orig, data) {
+		t.Fatal("content of 'claustral.jpg' in tar is not equal to original")
+	}
+
+	// size of content is taken from file info, not from the tagset
+	pack1.Tagger = doubletagger{pack1.Tagger}
+	sub, _ = pack1.Sub("img1")
+	buf.Reset()
+	if err = wpk.ExportArchive(&buf, sub, wpk.ArcTar); err != nil {
+		t.Fatal(err)
+	}
+	tr = tar.NewReader(&buf)
+	if hdr, err = tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Size != 2*int64(len(orig)) {
+		t.Fatalf("file in tar has size %d, expected %d", hdr.Size, 2*len(orig))
+	}
+}
+
+// The End.
@@ -0,0 +1,132 @@
+package auto
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+	"github.com/schwarzlichtbezirk/wpk/fsys"
+	"github.com/schwarzlichtbezirk/wpk/mmap"
+)
+
+// Mode is the way to get access to package nested files.
+type Mode string
+
+// Modes of access to package nested files.
+const (
+	ModeAuto  Mode = "auto"  // bulk for small packages, mmap for large
+	ModeBulk  Mode = "bulk"  // whole data is read into memory
+	ModeMmap  Mode = "mmap"  // each file is memory mapped on opening
+	ModeShare Mode = "share" // data section is memory mapped once
+	ModeFsys  Mode = "fsys"  // files are read from shared file handle
+)
+
+// DefThreshold is default data size limit for bulk access at auto mode.
+const DefThreshold = 16 * 1024 * 1024
+
+var (
+	ErrMode = errors.New("package access mode is not supported")
+)
+
+// ParseMode returns access mode with given name, empty name means auto mode.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case "":
+		return ModeAuto, nil
+	case ModeAuto, ModeBulk, ModeMmap, ModeShare, ModeFsys:
+		return m, nil
+	}
+	return "", ErrMode
+}
+
+// Options is the set of options for package opening.
+type Options struct {
+	Mode      Mode  // access mode, auto mode if it's empty
+	Threshold int64 // data size limit for bulk access at auto mode, DefThreshold if it's zero
+	Window    int   // files larger than window are mapped by sliding window at mmap and auto modes, zero disables
+}
+
+// PkgPath returns path to file with package tags table for given path.
+// It can be the path to single package file, to tags table file, or to
+// data file of splitted package. If given ".wpk" file does not exist,
+// but there is ".wpt" file with the same name, it's returned.
+func PkgPath(fpath string) string {
+	switch wpk.ToLower(path.Ext(fpath)) {
+	case ".wpf":
+		return wpk.MakeTagsPath(fpath)
+	case ".wpt":
+		return fpath
+	}
+	if ok, _ := wpk.FileExists(fpath); !ok {
+		if tpath := wpk.MakeTagsPath(fpath); tpath != fpath {
+			if ok, _ := wpk.FileExists(tpath); ok {
+				return tpath
+			}
+		}
+	}
+	return fpath
+}
+
+// OpenPackage opens package placed at given path, detects whether it's
+// splitted, and sets the tagger for given access mode. Path can point
+// to single package file, or to any file of splitted package. Closing
+// of returned package releases all opened handles and mappings.
+func OpenPackage(fpath string, opts Options) (pkg *wpk.Package, err error) {
+	var mode = opts.Mode
+	if mode == "" {
+		mode = ModeAuto
+	}
+	if _, err = ParseMode(string(mode)); err != nil {
+		return
+	}
+
+	var pkgpath = PkgPath(fpath)
+	pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		return nil, err
+	}
+	var datpath = pkgpath
+	if pkg.IsSplitted() {
+		datpath = wpk.MakeDataPath(pkgpath)
+	}
+
+	if mode == ModeAuto {
+		var threshold = opts.Threshold
+		if threshold <= 0 {
+			threshold = DefThreshold
+		}
+		var fi fs.FileInfo
+		if fi, err = os.Stat(datpath); err != nil {
+			return nil, err
+		}
+		if fi.Size() <= threshold {
+			mode = ModeBulk
+		} else {
+			mode = ModeMmap
+		}
+	}
+
+	switch mode {
+	case ModeBulk:
+		pkg.Tagger, err = bulk.MakeTagger(datpath)
+	case ModeMmap:
+		if opts.Window > 0 {
+			pkg.Tagger, err = mmap.MakeWindowTagger(datpath, opts.Window)
+		} else {
+			pkg.Tagger, err = mmap.MakeTagger(datpath)
+		}
+	case ModeShare:
+		pkg.Tagger, err = mmap.MakeSharedTagger(datpath)
+	case ModeFsys:
+		pkg.Tagger, err = fsys.MakeTagger(datpath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// The End.
@@ -0,0 +1,305 @@
+package wpk
+
+import (
+	"container/list"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// Default values of cache options.
+const (
+	DefCacheBudget    = 64 * 1024 * 1024
+	DefCacheBlockSize = 64 * 1024
+)
+
+// CacheOpts is the set of options for caching tagger.
+type CacheOpts struct {
+	Budget    int64 // maximum size of cached data in bytes, DefCacheBudget if it's zero
+	BlockSize int   // size of cached blocks, DefCacheBlockSize if it's zero
+}
+
+// CacheStats is statistics of caching tagger.
+type CacheStats struct {
+	Hits    int64 // number of blocks taken from cache
+	Misses  int64 // number of blocks read from underlying tagger
+	Evicted int64 // number of blocks removed from cache to fit the budget
+	Blocks  int   // number of blocks in cache
+	Used    int64 // size of cached data in bytes
+}
+
+// cachekey identifies block of nested file by its place in package.
+type cachekey struct {
+	offset, size uint // place of file in package
+	idx          int64
+}
+
+// cacheblock is cached block of nested file.
+type cacheblock struct {
+	key  cachekey
+	data []byte
+}
+
+// CacheTagger is decorator for any tagger that caches blocks of nested files
+// content in memory. Blocks are identified by place of file in package, so
+// aliases share cached blocks. Content is cached as it given by underlying
+// tagger, so if it decompresses or decrypts files, decoded content is cached.
+// Size of decoded content is taken from underlying file at first opening and
+// remembered. Least recently used blocks are evicted to fit memory budget.
+type CacheTagger struct {
+	Tagger
+	bsize  int64
+	budget int64
+
+	cache map[cachekey]*list.Element
+	lru   *list.List        // cached blocks, most recently used first
+	sizes map[[2]uint]int64 // content sizes of files by their places in package
+	stat  CacheStats
+	mux   sync.Mutex
+}
+
+// NewCacheTagger creates caching decorator for given tagger.
+func NewCacheTagger(tgr Tagger, opts CacheOpts) *CacheTagger {
+	var ct = &CacheTagger{
+		Tagger: tgr,
+		bsize:  int64(opts.BlockSize),
+		budget: opts.Budget,
+		cache:  map[cachekey]*list.Element{},
+		lru:    list.New(),
+		sizes:  map[[2]uint]int64{},
+	}
+	if ct.bsize <= 0 {
+		ct.bsize = DefCacheBlockSize
+	}
+	if ct.budget <= 0 {
+		ct.budget = DefCacheBudget
+	}
+	return ct
+}
+
+// Stats returns statistics of cache usage.
+func (ct *CacheTagger) Stats() CacheStats {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	var stat = ct.stat
+	stat.Blocks = ct.lru.Len()
+	return stat
+}
+
+// Purge removes all cached blocks and remembered sizes.
+func (ct *CacheTagger) Purge() {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	ct.cache = map[cachekey]*list.Element{}
+	ct.lru.Init()
+	ct.sizes = map[[2]uint]int64{}
+	ct.stat.Used = 0
+}
+
+// getsize returns remembered content size of file at given place.
+func (ct *CacheTagger) getsize(offset, size uint) (fsize int64, ok bool) {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	fsize, ok = ct.sizes[[2]uint{offset, size}]
+	return
+}
+
+// putsize remembers content size of file at given place.
+func (ct *CacheTagger) putsize(offset, size uint, fsize int64) {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	ct.sizes[[2]uint{offset, size}] = fsize
+}
+
+// get returns cached block with given key.
+func (ct *CacheTagger) get(key cachekey) ([]byte, bool) {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	if e, ok := ct.cache[key]; ok {
+		ct.lru.MoveToFront(e)
+		ct.stat.Hits++
+		return e.Value.(*cacheblock).data, true
+	}
+	ct.stat.Misses++
+	return nil, false
+}
+
+// put places block to cache, and evicts least
+// recently used blocks to fit the budget.
+func (ct *CacheTagger) put(key cachekey, data []byte) {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	if int64(len(data)) > ct.budget {
+		return
+	}
+	if e, ok := ct.cache[key]; ok {
+		ct.lru.MoveToFront(e)
+		return
+	}
+	ct.cache[key] = ct.lru.PushFront(&cacheblock{key: key, data: data})
+	ct.stat.Used += int64(len(data))
+	for ct.stat.Used > ct.budget {
+		var e = ct.lru.Back()
+		var b = e.Value.(*cacheblock)
+		delete(ct.cache, b.key)
+		ct.lru.Remove(e)
+		ct.stat.Used -= int64(len(b.data))
+		ct.stat.Evicted++
+	}
+}
+
+// OpenTagset creates file object to give access to nested into package file
+// by given tagset. Underlying file is opened on cache miss, and at first
+// opening of the file to get the size of its content, which can differ
+// from stored size if underlying tagger decodes the content.
+func (ct *CacheTagger) OpenTagset(ts TagsetRaw) (RFile, error) {
+	var offset, size = ts.Pos()
+	var cr = &cachereader{
+		ct:     ct,
+		ts:     ts,
+		offset: offset,
+		size:   size,
+	}
+	var ok bool
+	if cr.fsize, ok = ct.getsize(offset, size); !ok {
+		var f, err = ct.Tagger.OpenTagset(ts)
+		if err != nil {
+			return nil, err
+		}
+		var fi fs.FileInfo
+		if fi, err = f.Stat(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cr.f, cr.fsize = f, fi.Size()
+		ct.putsize(offset, size, cr.fsize)
+	}
+	return &CacheFile{
+		SectionReader: io.NewSectionReader(cr, 0, cr.fsize),
+		cr:            cr,
+	}, nil
+}
+
+// Close removes all cached blocks and closes underlying tagger.
+// io.Closer implementation.
+func (ct *CacheTagger) Close() error {
+	ct.Purge()
+	return ct.Tagger.Close()
+}
+
+// cachereader reads nested file content by blocks through the cache.
+type cachereader struct {
+	ct           *CacheTagger
+	ts           TagsetRaw
+	offset, size uint  // place of file in package
+	fsize        int64 // size of file content given by underlying tagger
+	f            RFile // underlying file, opened on first cache miss
+	mux          sync.Mutex
+}
+
+// block returns block of file content with given index.
+func (cr *cachereader) block(idx int64) (data []byte, err error) {
+	var key = cachekey{cr.offset, cr.size, idx}
+	var ok bool
+	if data, ok = cr.ct.get(key); ok {
+		return
+	}
+
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	if cr.f == nil {
+		if cr.f, err = cr.ct.Tagger.OpenTagset(cr.ts); err != nil {
+			return
+		}
+	}
+	var bsize = cr.ct.bsize
+	if rest := cr.fsize - idx*bsize; rest < bsize {
+		bsize = rest
+	}
+	data = make([]byte, bsize)
+	var n int
+	if n, err = cr.f.ReadAt(data, idx*cr.ct.bsize); n < len(data) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	err = nil
+	cr.ct.put(key, data)
+	return
+}
+
+// ReadAt reads file content from cached blocks.
+// io.ReaderAt implementation.
+func (cr *cachereader) ReadAt(p []byte, off int64) (n int, err error) {
+	var end = off + int64(len(p))
+	if end > cr.fsize {
+		end = cr.fsize
+	}
+	var bsize = cr.ct.bsize
+	for pos := off; pos < end; {
+		var idx = pos / bsize
+		var data []byte
+		if data, err = cr.block(idx); err != nil {
+			return
+		}
+		var hi = end - idx*bsize
+		if hi > int64(len(data)) {
+			hi = int64(len(data))
+		}
+		var k = copy(p[n:], data[pos-idx*bsize:hi])
+		n += k
+		pos += int64(k)
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// close closes underlying file if it was opened.
+func (cr *cachereader) close() (err error) {
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	if cr.f != nil {
+		err = cr.f.Close()
+		cr.f = nil
+	}
+	return
+}
+
+// CacheFile structure gives access to nested into package file
+// through the cache of caching tagger. RFile interface implementation.
+type CacheFile struct {
+	*io.SectionReader
+	cr *cachereader
+}
+
+// Stat is for fs.File interface compatibility.
+// Returns size of content given by underlying tagger.
+func (f *CacheFile) Stat() (fs.FileInfo, error) {
+	if f.cr.fsize != int64(f.cr.size) {
+		return cacheinfo{f.cr.ts, f.cr.fsize}, nil
+	}
+	return f.cr.ts, nil
+}
+
+// cacheinfo is file info of nested file with size
+// of its content decoded by underlying tagger.
+type cacheinfo struct {
+	TagsetRaw
+	size int64
+}
+
+// Size returns size of decoded content.
+// fs.FileInfo implementation.
+func (fi cacheinfo) Size() int64 {
+	return fi.size
+}
+
+// Close closes underlying file if it was opened on cache miss.
+func (f *CacheFile) Close() error {
+	return f.cr.close()
+}
+
+// The End.
@@ -0,0 +1,216 @@
+package wpk
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// CompactOldExt is the extension added to original data file of splitted
+// package while it's replaced by compacted one.
+const CompactOldExt = ".old"
+
+// Compact writes copy of package to given writer as single package file
+// without unused data, such as data of deleted files, or previous tags
+// tables left after appending. Aliases remain aliases. Package should
+// have a tagger to read the files. Returns tags table of written copy.
+func (pkg *Package) Compact(w io.WriteSeeker, progress ProgressFunc) (*Package, error) {
+	return pkg.CompactCtx(context.Background(), w, progress)
+}
+
+// CompactCtx is Compact with context. On cancellation it stops and returns
+// context error. Source package remains untouched, and written copy is left
+// in building state, so it can not be opened by mistake.
+func (pkg *Package) CompactCtx(ctx context.Context, w io.WriteSeeker, progress ProgressFunc) (dst *Package, err error) {
+	return pkg.compact(ctx, w, nil, progress)
+}
+
+// blockkey identifies data block of package.
+type blockkey struct {
+	offset, size uint
+}
+
+// Waste returns number of bytes at data section that are not used by
+// any file, such as data of deleted or replaced files, or previous tags
+// tables left after appending, and the whole data section size.
+func (pkg *Package) Waste() (waste, total uint) {
+	var used uint
+	var seen = map[blockkey]Void{}
+	pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+		var offset, size = ts.Pos()
+		if _, ok := seen[blockkey{offset, size}]; !ok {
+			seen[blockkey{offset, size}] = Void{}
+			used += size
+		}
+		return true
+	})
+	total = pkg.DataSize()
+	if used < total {
+		waste = total - used
+	}
+	return
+}
+
+// compact writes copy of package to given tags and data writers,
+// data writer can be nil for single package file.
+func (pkg *Package) compact(ctx context.Context, wpt, wpf io.WriteSeeker, progress ProgressFunc) (dst *Package, err error) {
+	var w = wpt
+	if wpf != nil {
+		w = wpf
+	}
+	var prg Progress
+	var seen = map[blockkey]Void{}
+	pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+		var offset, size = ts.Pos()
+		prg.FilesTotal++
+		if _, ok := seen[blockkey{offset, size}]; !ok {
+			seen[blockkey{offset, size}] = Void{}
+			prg.BytesTotal += int64(size)
+		}
+		return true
+	})
+	progress.report(&prg)
+
+	var blocks = map[blockkey]uint{} // source blocks to offsets in copy
+	dst = NewPackage()
+	if err = dst.Begin(wpt, wpf); err != nil {
+		return
+	}
+	dst.SetInfo(CopyTagset(pkg.GetInfo()))
+	pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var offset, size = ts.Pos()
+		var bk = blockkey{offset, size}
+		var newts TagsetRaw
+		if dstoff, ok := blocks[bk]; ok {
+			newts = dst.BaseTagset(dstoff, size, fkey)
+		} else {
+			var f RFile
+			if f, err = pkg.OpenTagset(ts); err != nil {
+				return false
+			}
+			newts, err = dst.PackData(w, ctxreader{ctx, f}, fkey)
+			f.Close()
+			if err != nil {
+				return false
+			}
+			blocks[bk], _ = newts.Pos()
+			prg.Bytes += int64(size)
+		}
+
+		var tsi = ts.Iterator()
+		for tsi.Next() {
+			switch tsi.TID() {
+			case TIDoffset, TIDsize, TIDpath:
+				continue
+			}
+			newts = newts.Put(tsi.TID(), tsi.Tag())
+		}
+		dst.SetTagset(fkey, newts)
+
+		prg.FKey = fkey
+		prg.Files++
+		progress.report(&prg)
+		return true
+	})
+	if err != nil {
+		return
+	}
+	err = dst.Sync(wpt, wpf)
+	return
+}
+
+// CompactFile rewrites package placed at given path without unused data.
+// Data path is the path to data file of splitted package, it's ignored
+// for single package file. New package is written to temporary files at the
+// same directory, and replaces original files only after successful writing.
+// Original data file of splitted package is renamed aside with CompactOldExt
+// extension until the new tags table is placed, and it's rolled back if
+// placing fails, so original package is untouched on any error. If process
+// dies between the renames, original package can be restored from this file.
+func CompactFile(ctx context.Context, pkgpath, datpath string, progress ProgressFunc) (err error) {
+	var pkg = NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		return
+	}
+	if !pkg.IsSplitted() {
+		datpath = "" // data is placed at package file
+	} else if datpath == "" {
+		return ErrNoDatFile
+	}
+
+	var tmppath, tmpdat = pkgpath + ".tmp", ""
+	if datpath != "" {
+		tmpdat = datpath + ".tmp"
+	}
+	if err = func() (err error) {
+		var src *os.File
+		if src, err = os.Open(pkgpath); err != nil {
+			return
+		}
+		defer src.Close()
+		if datpath != "" {
+			if src, err = os.Open(datpath); err != nil {
+				return
+			}
+			defer src.Close()
+		}
+		var fi os.FileInfo
+		if fi, err = src.Stat(); err != nil {
+			return
+		}
+		pkg.Tagger = NewReaderTagger(src, fi.Size())
+
+		var wpt, wpf *os.File
+		if wpt, err = os.OpenFile(tmppath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			return
+		}
+		defer wpt.Close()
+		if tmpdat != "" {
+			if wpf, err = os.OpenFile(tmpdat, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+				return
+			}
+			defer wpf.Close()
+			_, err = pkg.compact(ctx, wpt, wpf, progress)
+		} else {
+			_, err = pkg.compact(ctx, wpt, nil, progress)
+		}
+		return
+	}(); err != nil {
+		os.Remove(tmppath)
+		if tmpdat != "" {
+			os.Remove(tmpdat)
+		}
+		return
+	}
+
+	if tmpdat == "" {
+		if err = os.Rename(tmppath, pkgpath); err != nil {
+			os.Remove(tmppath)
+		}
+		return
+	}
+
+	var olddat = datpath + CompactOldExt
+	if err = os.Rename(datpath, olddat); err != nil {
+		os.Remove(tmppath)
+		os.Remove(tmpdat)
+		return
+	}
+	if err = os.Rename(tmpdat, datpath); err != nil {
+		os.Rename(olddat, datpath)
+		os.Remove(tmppath)
+		os.Remove(tmpdat)
+		return
+	}
+	if err = os.Rename(tmppath, pkgpath); err != nil {
+		os.Rename(olddat, datpath) // replaces new data file
+		os.Remove(tmppath)
+		return
+	}
+	return os.Remove(olddat)
+}
+
+// The End.
@@ -0,0 +1,136 @@
+package wpk
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+)
+
+var (
+	ErrFileOpen = errors.New("previous file created at package is not closed")
+)
+
+// FileWriter puts content of new file created by Create call
+// directly into package data.
+type FileWriter struct {
+	pkg    *Package
+	w      io.WriteSeeker
+	fkey   string
+	opts   PackOpts
+	offset int64
+	size   int64
+	hs     []hash.Hash
+	sniff  []byte
+	err    error // the first writing error
+	closed bool
+}
+
+// Create starts new file with given name at package, and returns writer
+// to put its content into package data. File is added to package with
+// its tagset on writer closing. There can be only one created file opened
+// at once, and PackData or Sync calls can not be made until it's closed.
+func (pkg *Package) Create(w io.WriteSeeker, fkey string) (*FileWriter, error) {
+	return pkg.CreateWith(w, fkey, PackOpts{})
+}
+
+// CreateWith is Create with packing options. Hashes given at options
+// are computed during writing. Mime function gets first bytes of content
+// up to 512 bytes, and Hook is called on closing. Other options are unused.
+func (pkg *Package) CreateWith(w io.WriteSeeker, fkey string, opts PackOpts) (fw *FileWriter, err error) {
+	if pkg.HasTagset(fkey) {
+		return nil, &fs.PathError{Op: "create", Path: fkey, Err: fs.ErrExist}
+	}
+
+	pkg.mux.Lock()
+	defer pkg.mux.Unlock()
+
+	if pkg.creating {
+		return nil, &fs.PathError{Op: "create", Path: fkey, Err: ErrFileOpen}
+	}
+	var offset int64
+	if offset, err = w.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+	fw = &FileWriter{
+		pkg:    pkg,
+		w:      w,
+		fkey:   fkey,
+		opts:   opts,
+		offset: offset,
+		hs:     make([]hash.Hash, len(opts.Hashes)),
+	}
+	for i, tid := range opts.Hashes {
+		fw.hs[i] = NewHash(tid, opts.Secret)
+	}
+	pkg.creating = true
+	return
+}
+
+// Write puts given content to package data, and updates hashes.
+func (fw *FileWriter) Write(p []byte) (n int, err error) {
+	if fw.closed {
+		return 0, fs.ErrClosed
+	}
+	if fw.err != nil {
+		return 0, fw.err
+	}
+	n, err = fw.w.Write(p)
+	for _, h := range fw.hs {
+		h.Write(p[:n])
+	}
+	if fw.opts.Mime != nil && len(fw.sniff) < sniffLen {
+		var l = sniffLen - len(fw.sniff)
+		if l > n {
+			l = n
+		}
+		fw.sniff = append(fw.sniff, p[:l]...)
+	}
+	fw.size += int64(n)
+	fw.err = err
+	return
+}
+
+// Close finalizes the file, and puts its tagset with size, hashes and
+// MIME type into package. If writing was failed, file is not added to
+// package, and the writing error is returned.
+func (fw *FileWriter) Close() (err error) {
+	if fw.closed {
+		return fs.ErrClosed
+	}
+	fw.closed = true
+
+	var pkg = fw.pkg
+	func() {
+		pkg.mux.Lock()
+		defer pkg.mux.Unlock()
+
+		pkg.creating = false
+		if fw.err == nil {
+			// update actual package data size
+			pkg.datsize += uint64(fw.size)
+		}
+	}()
+	if err = fw.err; err != nil {
+		return
+	}
+
+	var ts = pkg.BaseTagset(uint(fw.offset), uint(fw.size), fw.fkey)
+	for i, tid := range fw.opts.Hashes {
+		ts = ts.Put(tid, fw.hs[i].Sum(nil))
+	}
+	if fw.opts.Mime != nil {
+		if ctype := fw.opts.Mime(fw.fkey, fw.sniff); ctype != "" {
+			ts = ts.Put(TIDmime, StrTag(ctype))
+		}
+	}
+	if fw.opts.Hook != nil {
+		if ts, err = fw.opts.Hook(fw.fkey, ts); err != nil {
+			return
+		}
+	}
+	pkg.SetTagset(fw.fkey, ts)
+	return
+}
+
+// The End.
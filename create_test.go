@@ -0,0 +1,135 @@
+package wpk_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+// Test incremental writing of files content to package.
+func TestCreate(t *testing.T) {
+	var err error
+	var pkgpath = path.Join(t.TempDir(), "create.wpk")
+	var doc = map[string]any{
+		"name":  "sample",
+		"items": []int{1, 2, 3},
+	}
+	var jsondata, _ = json.Marshal(doc)
+	jsondata = append(jsondata, '\n') // encoder puts new line at the end
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		var opts = wpk.PackOpts{
+			Hashes: []wpk.TID{wpk.TIDsha256},
+			Mime: func(fkey string, data []byte) string {
+				return http.DetectContentType(data)
+			},
+		}
+
+		var fw *wpk.FileWriter
+		if fw, err = pkg.CreateWith(fwpk, "doc.json", opts); err != nil {
+			t.Fatal(err)
+		}
+		if err = json.NewEncoder(fw).Encode(doc); err != nil {
+			t.Fatal(err)
+		}
+		// writings to package are disabled until the file is closed
+		if _, err = pkg.Create(fwpk, "other.txt"); !errors.Is(err, wpk.ErrFileOpen) {
+			t.Fatalf("second file was created while the first is open, error: %v", err)
+		}
+		if _, err = pkg.PackData(fwpk, bytes.NewReader(memdata["sample.txt"]), "sample.txt"); !errors.Is(err, wpk.ErrFileOpen) {
+			t.Fatalf("data was packed while the file is open, error: %v", err)
+		}
+		if err = pkg.Sync(fwpk, nil); !errors.Is(err, wpk.ErrFileOpen) {
+			t.Fatalf("package was synchronized while the file is open, error: %v", err)
+		}
+		if pkg.HasTagset("doc.json") {
+			t.Fatal("file is present at package before closing")
+		}
+		if err = fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = fw.Close(); !errors.Is(err, fs.ErrClosed) {
+			t.Fatalf("file was closed twice, error: %v", err)
+		}
+		if _, err = fw.Write([]byte("x")); !errors.Is(err, fs.ErrClosed) {
+			t.Fatalf("content was written to closed file, error: %v", err)
+		}
+		if _, err = pkg.Create(fwpk, "doc.json"); !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("file with existing name was created, error: %v", err)
+		}
+
+		// write some files after the closed one
+		if fw, err = pkg.Create(fwpk, "sample.txt"); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range [][]byte{memdata["sample.txt"][:10], memdata["sample.txt"][10:]} {
+			if _, err = fw.Write(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pkg.PackData(fwpk, bytes.NewReader(memdata["array.dat"]), "array.dat"); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	for fkey, orig := range map[string][]byte{
+		"doc.json":   jsondata,
+		"sample.txt": memdata["sample.txt"],
+		"array.dat":  memdata["array.dat"],
+	} {
+		var b []byte
+		if b, err = pkg.ReadFile(fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, orig) {
+			t.Fatalf("content of file '%s' is not equal to original", fkey)
+		}
+	}
+	var ts, _ = pkg.GetTagset("doc.json")
+	if ts.Size() != int64(len(jsondata)) {
+		t.Fatalf("file size is %d, expected %d", ts.Size(), len(jsondata))
+	}
+	var h = wpk.NewHash(wpk.TIDsha256, nil)
+	h.Write(jsondata)
+	if tag, ok := ts.Get(wpk.TIDsha256); !ok || !bytes.Equal(tag, h.Sum(nil)) {
+		t.Fatal("hash of file content is not valid")
+	}
+	if ctype, _ := ts.TagStr(wpk.TIDmime); ctype != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected MIME type '%s'", ctype)
+	}
+}
+
+// The End.
@@ -106,6 +106,20 @@ func ExamplePackage_Glob() {
 	}
 }
 
+func ExamplePackage_KeysSum() {
+	var err error
+
+	// Open package files tags table
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile("example.wpk"); err != nil {
+		log.Fatal(err)
+	}
+
+	// Print checksum of files set, util/gen places it to generated
+	// code to check up the package opened at run time
+	log.Printf("checksum of %d files names: %s", pkg.TagsetNum(), pkg.KeysSum())
+}
+
 func ExampleGetPackageInfo() {
 	// list of packages to get info
 	var list = []string{
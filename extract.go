@@ -0,0 +1,428 @@
+package wpk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// PathPolicy defines what to do with file names that can not
+// be safely placed into destination directory, such as names
+// with ".." elements, or absolute paths.
+type PathPolicy int
+
+const (
+	PathReject  PathPolicy = iota // stop extraction with error
+	PathSkip                      // skip such files
+	PathRewrite                   // remove unsafe elements from names
+)
+
+// OverwritePolicy defines what to do with existing destination files.
+type OverwritePolicy int
+
+const (
+	OverwriteAlways OverwritePolicy = iota // always replace existing files
+	OverwriteSkip                          // keep existing files
+	OverwriteNewer                         // replace files that are older than files in package
+)
+
+// PathPolicies is the set of policies for unsafe file names
+// by their names used at command line tools.
+var PathPolicies = map[string]PathPolicy{
+	"reject":  PathReject,
+	"skip":    PathSkip,
+	"rewrite": PathRewrite,
+}
+
+// OverwritePolicies is the set of policies for existing files
+// by their names used at command line tools.
+var OverwritePolicies = map[string]OverwritePolicy{
+	"always": OverwriteAlways,
+	"skip":   OverwriteSkip,
+	"newer":  OverwriteNewer,
+}
+
+var (
+	ErrUnsafePath = errors.New("file name refers outside of destination directory")
+	ErrDupPath    = errors.New("different file names refer to the same destination file")
+)
+
+// ExtractOpts is the set of options for files extraction from package.
+type ExtractOpts struct {
+	OrgTime   bool            // set original access and modification times to extracted files
+	Include   []string        // glob patterns of files to extract, all files if it's empty
+	Exclude   []string        // glob patterns of files to skip
+	Path      PathPolicy      // what to do with unsafe file names
+	Overwrite OverwritePolicy // what to do with existing files
+	Workers   int             // number of parallel workers, number of CPUs if it's zero
+	HardLinks bool            // make hard links for files with TIDhardlink tag instead of data copy
+	Xattrs    bool            // restore extended attributes stored at TIDxattr tag
+	XattrSkip XattrSkipFunc   // called for file with extended attributes that can not be restored
+	Progress  ProgressFunc    // called after each processed file
+}
+
+// XattrSkipFunc is called for extracted file with names of extended attributes
+// that can not be set because of permissions or file system support. It can be
+// called from several workers at once.
+type XattrSkipFunc func(fkey string, names []string)
+
+// MatchGlob checks up that file name matches to any of given glob patterns.
+// Pattern with slash is matched to file name or any of its parent
+// directories. Pattern without slash is matched to any element of path,
+// so "*.txt" matches all text files, and "docs" matches all files in
+// any "docs" directory.
+func MatchGlob(fkey string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			for name := fkey; name != "." && name != "/" && name != ""; name = path.Dir(name) {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		} else {
+			for _, name := range strings.Split(fkey, "/") {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// SafePath returns file name that can be safely joined to destination
+// directory, and false if given name is unsafe and was rewritten.
+// Elements "..", absolute paths, drive letters and backslashes are
+// removed. Returns empty string if there is nothing left in name.
+func SafePath(fkey string) (string, bool) {
+	var parts = strings.FieldsFunc(fkey, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+	var safe = !strings.HasPrefix(fkey, "/") && !strings.Contains(fkey, "\\")
+	var list = make([]string, 0, len(parts))
+	for i, part := range parts {
+		if part == "." {
+			continue
+		}
+		if part == ".." || (i == 0 && len(part) == 2 && part[1] == ':') {
+			safe = false
+			continue
+		}
+		list = append(list, part)
+	}
+	return strings.Join(list, "/"), safe
+}
+
+// Extract writes all files of package to given destination directory.
+func (pkg *Package) Extract(dstdir string, opts ExtractOpts) error {
+	return pkg.ExtractCtx(context.Background(), dstdir, opts)
+}
+
+// extractjob is file to extract with its destination path.
+type extractjob struct {
+	fkey  string
+	fpath string
+	ts    TagsetRaw
+
+	link *extractjob // file to make hard link to
+	done chan Void   // closed after extraction of file that is hard link target
+	put  bool        // file was written, not skipped
+}
+
+// extractlist returns list of files to extract filtered by options.
+// Different file names that refer to the same destination file are
+// rejected with PathReject policy, otherwise only one of them is kept:
+// file with name that is equal to destination path if it present,
+// or with least name in other case.
+func (pkg *Package) extractlist(dstdir string, opts *ExtractOpts) (list []extractjob, err error) {
+	var index = map[string]int{} // destination paths of jobs
+	pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+		if len(opts.Include) > 0 && !MatchGlob(fkey, opts.Include) {
+			return true
+		}
+		if MatchGlob(fkey, opts.Exclude) {
+			return true
+		}
+		var name, safe = SafePath(fkey)
+		if !safe || name == "" {
+			switch {
+			case opts.Path == PathSkip:
+				return true
+			case opts.Path == PathRewrite && name != "":
+			default:
+				err = fmt.Errorf("%w: %s", ErrUnsafePath, fkey)
+				return false
+			}
+		}
+		var job = extractjob{
+			fkey:  fkey,
+			fpath: JoinPath(dstdir, name),
+			ts:    ts,
+		}
+		if i, ok := index[job.fpath]; ok {
+			if opts.Path == PathReject {
+				err = fmt.Errorf("%w: %s, %s", ErrDupPath, list[i].fkey, fkey)
+				return false
+			}
+			var prev = list[i].fkey
+			if prev != name && (fkey == name || fkey < prev) {
+				list[i] = job
+			}
+			return true
+		}
+		index[job.fpath] = len(list)
+		list = append(list, job)
+		return true
+	})
+	return
+}
+
+// linkjobs binds files with TIDhardlink tag to extracted files with
+// the same data, and moves them to the end of the list, so hard links
+// are made after their targets.
+func (pkg *Package) linkjobs(list []extractjob) []extractjob {
+	var index = make(map[string]int, len(list))
+	for i, job := range list {
+		index[job.fkey] = i
+	}
+	var target = make([]int, len(list))
+	var res = make([]extractjob, 0, len(list))
+	var pos = make([]int, len(list))
+	for i, job := range list {
+		target[i] = -1
+		if orig, ok := job.ts.TagStr(TIDhardlink); ok {
+			if j, ok := index[pkg.TrimPath(orig)]; ok && !list[j].ts.Has(TIDhardlink) {
+				var offset1, size1 = job.ts.Pos()
+				var offset2, size2 = list[j].ts.Pos()
+				if offset1 == offset2 && size1 == size2 {
+					target[i] = j
+					continue
+				}
+			}
+		}
+		pos[i] = len(res)
+		res = append(res, job)
+	}
+	for i, job := range list {
+		if target[i] >= 0 {
+			pos[i] = len(res)
+			res = append(res, job)
+		}
+	}
+	for i, j := range target {
+		if j >= 0 {
+			var orig = &res[pos[j]]
+			if orig.done == nil {
+				orig.done = make(chan Void)
+			}
+			res[pos[i]].link = orig
+		}
+	}
+	return res
+}
+
+// ExtractCtx is Extract with context. On cancellation it stops, removes
+// partially written files, and returns context error. Files names are
+// checked up before any writing, so package with unsafe names, or with
+// different names of the same destination file, is not extracted at all
+// with PathReject policy. With HardLinks option files that were packed as
+// hard links are restored as hard links to their targets, if target is
+// extracted too.
+func (pkg *Package) ExtractCtx(ctx context.Context, dstdir string, opts ExtractOpts) (err error) {
+	var list []extractjob
+	if list, err = pkg.extractlist(dstdir, &opts); err != nil {
+		return
+	}
+	if opts.HardLinks {
+		list = pkg.linkjobs(list)
+	}
+	return runjobs(ctx, list, opts.Workers, opts.Progress, func(ctx context.Context, job *extractjob) (n int64, err error) {
+		if job.link != nil {
+			return pkg.extractlink(ctx, job, &opts)
+		}
+		if job.done != nil {
+			defer close(job.done)
+		}
+		var skip bool
+		if skip, err = skipfile(job.ts, job.fpath, opts.Overwrite); err != nil || skip {
+			return
+		}
+		n, err = pkg.extractfile(ctx, job.fkey, job.ts, job.fpath, &opts)
+		job.put = err == nil
+		return
+	})
+}
+
+// runjobs processes given files list by number of parallel workers,
+// and reports progress after each file with number of processed bytes
+// returned by given function. The first error cancels remaining jobs.
+func runjobs(ctx context.Context, list []extractjob, workers int, progress ProgressFunc, f func(context.Context, *extractjob) (int64, error)) error {
+	var prg Progress
+	prg.FilesTotal = len(list)
+	for _, job := range list {
+		prg.BytesTotal += job.ts.Size()
+	}
+	progress.report(&prg)
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var jobs = make(chan *extractjob)
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	var errs []error
+	wg.Add(workers)
+	for k := 0; k < workers; k++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				var n, err = f(ctx, job)
+				mux.Lock()
+				if err != nil {
+					errs = append(errs, err)
+					cancel()
+				} else {
+					prg.FKey = job.fkey
+					prg.Files++
+					prg.Bytes += n
+					progress.report(&prg)
+				}
+				mux.Unlock()
+			}
+		}()
+	}
+
+loop:
+	for i := range list {
+		select {
+		case jobs <- &list[i]:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		// prefer the error that caused the cancellation
+		for _, err := range errs {
+			if !errors.Is(err, context.Canceled) {
+				return err
+			}
+		}
+		return errs[0]
+	}
+	return ctx.Err()
+}
+
+// skipfile checks up overwrite policy for existing destination file.
+func skipfile(ts TagsetRaw, fpath string, policy OverwritePolicy) (bool, error) {
+	if policy == OverwriteAlways {
+		return false, nil
+	}
+	var fi, err = os.Stat(fpath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if policy == OverwriteSkip {
+		return true, nil
+	}
+	// OverwriteNewer
+	var mtime, ok = ts.TagTime(TIDmtime)
+	return !ok || !mtime.After(fi.ModTime()), nil
+}
+
+// extractlink makes hard link to extracted file after its extraction.
+// If target was skipped, or file system does not support hard links,
+// the file is written as usual.
+func (pkg *Package) extractlink(ctx context.Context, job *extractjob, opts *ExtractOpts) (n int64, err error) {
+	select {
+	case <-job.link.done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	var skip bool
+	if skip, err = skipfile(job.ts, job.fpath, opts.Overwrite); err != nil || skip {
+		return
+	}
+	if job.link.put {
+		if err = os.MkdirAll(path.Dir(job.fpath), os.ModePerm); err != nil {
+			return
+		}
+		if err = os.Remove(job.fpath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err = os.Link(job.link.fpath, job.fpath); err == nil {
+			return job.ts.Size(), nil
+		}
+	}
+	return pkg.extractfile(ctx, job.fkey, job.ts, job.fpath, opts)
+}
+
+// extractfile writes file with given tagset to destination path.
+// Returns number of written bytes.
+func (pkg *Package) extractfile(ctx context.Context, fkey string, ts TagsetRaw, fpath string, opts *ExtractOpts) (n int64, err error) {
+	if err = os.MkdirAll(path.Dir(fpath), os.ModePerm); err != nil {
+		return
+	}
+
+	var src RFile
+	if src, err = pkg.OpenTagset(ts); err != nil {
+		return
+	}
+	defer src.Close()
+
+	var dst *os.File
+	if dst, err = os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		return
+	}
+	if n, err = io.Copy(dst, ctxreader{ctx, src}); err != nil {
+		dst.Close()
+		os.Remove(fpath) // remove partially written file
+		return
+	}
+	if err = dst.Close(); err != nil {
+		return
+	}
+
+	if opts.Xattrs {
+		if list, ok := ts.Xattrs(); ok {
+			var skipped []string
+			if skipped, err = WriteXattrs(fpath, list); err != nil {
+				return
+			}
+			if len(skipped) > 0 && opts.XattrSkip != nil {
+				opts.XattrSkip(fkey, skipped)
+			}
+		}
+	}
+
+	if opts.OrgTime {
+		var mtime, mok = ts.TagTime(TIDmtime)
+		var atime, aok = ts.TagTime(TIDatime)
+		if !aok {
+			atime = mtime
+		}
+		if mok {
+			if err = os.Chtimes(fpath, atime, mtime); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// The End.
@@ -0,0 +1,299 @@
+package wpk_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+func TestSafePath(t *testing.T) {
+	var list = []struct {
+		fkey string
+		name string
+		safe bool
+	}{
+		{"a/b.txt", "a/b.txt", true},
+		{"./a/./b.txt", "a/b.txt", true},
+		{"../../etc/passwd", "etc/passwd", false},
+		{"a/../../b.txt", "a/b.txt", false},
+		{"/etc/passwd", "etc/passwd", false},
+		{"c:/windows/win.ini", "windows/win.ini", false},
+		{"a\\..\\b.txt", "a/b.txt", false},
+		{"..", "", false},
+	}
+	for _, v := range list {
+		var name, safe = wpk.SafePath(v.fkey)
+		if name != v.name || safe != v.safe {
+			t.Errorf("SafePath(%q) = %q, %t; expected %q, %t", v.fkey, name, safe, v.name, v.safe)
+		}
+	}
+}
+
+// Test files extraction with filters, unsafe names, overwrite policies and workers.
+func TestExtractOpts(t *testing.T) {
+	var err error
+	var pkgpath = path.Join(t.TempDir(), "extract.wpk")
+	var mtime = time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	var files = map[string][]byte{
+		"sample.txt":     memdata["sample.txt"],
+		"docs/array.dat": memdata["array.dat"],
+		"docs/note.txt":  memdata["sample.txt"],
+		"../evil.txt":    []byte("evil"),
+	}
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		for fkey, data := range files {
+			var ts wpk.TagsetRaw
+			if ts, err = pkg.PackData(fwpk, bytes.NewReader(data), fkey); err != nil {
+				t.Fatal(err)
+			}
+			pkg.SetTagset(fkey, ts.Put(wpk.TIDmtime, wpk.TimeTag(mtime)))
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var check = func(t *testing.T, dir string, expect map[string][]byte) {
+		t.Helper()
+		for name, data := range expect {
+			var b []byte
+			if b, err = os.ReadFile(path.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, data) {
+				t.Fatalf("content of extracted file '%s' is not equal to original", name)
+			}
+		}
+		var n int
+		var walk func(dir string)
+		walk = func(dir string) {
+			var list, _ = os.ReadDir(dir)
+			for _, de := range list {
+				if de.IsDir() {
+					walk(path.Join(dir, de.Name()))
+				} else {
+					n++
+				}
+			}
+		}
+		walk(dir)
+		if n != len(expect) {
+			t.Fatalf("expected %d extracted files, got %d", len(expect), n)
+		}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		var dir = path.Join(t.TempDir(), "dst")
+		if err = pkg.Extract(dir, wpk.ExtractOpts{}); !errors.Is(err, wpk.ErrUnsafePath) {
+			t.Fatalf("expected unsafe path error, got %v", err)
+		}
+		check(t, dir, map[string][]byte{})
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		var dir = path.Join(t.TempDir(), "dst")
+		if err = pkg.Extract(dir, wpk.ExtractOpts{Path: wpk.PathSkip, Workers: 3}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{
+			"sample.txt":     files["sample.txt"],
+			"docs/array.dat": files["docs/array.dat"],
+			"docs/note.txt":  files["docs/note.txt"],
+		})
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		var dir = path.Join(t.TempDir(), "dst")
+		if err = pkg.Extract(dir, wpk.ExtractOpts{Path: wpk.PathRewrite, Workers: 1}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{
+			"sample.txt":     files["sample.txt"],
+			"docs/array.dat": files["docs/array.dat"],
+			"docs/note.txt":  files["docs/note.txt"],
+			"evil.txt":       files["../evil.txt"],
+		})
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		var dir = t.TempDir()
+		if err = pkg.Extract(dir, wpk.ExtractOpts{
+			Include: []string{"docs"},
+			Exclude: []string{"*.dat"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{
+			"docs/note.txt": files["docs/note.txt"],
+		})
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		var dir = t.TempDir()
+		var opts = wpk.ExtractOpts{
+			Include: []string{"sample.txt"},
+		}
+		var old = []byte("old content")
+		var put = func(mt time.Time) {
+			var fpath = path.Join(dir, "sample.txt")
+			if err = os.WriteFile(fpath, old, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Chtimes(fpath, mt, mt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		put(time.Now())
+		opts.Overwrite = wpk.OverwriteSkip
+		if err = pkg.Extract(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{"sample.txt": old})
+
+		opts.Overwrite = wpk.OverwriteNewer
+		if err = pkg.Extract(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{"sample.txt": old})
+
+		put(mtime.Add(-time.Hour))
+		if err = pkg.Extract(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{"sample.txt": files["sample.txt"]})
+
+		put(time.Now())
+		opts.Overwrite = wpk.OverwriteAlways
+		if err = pkg.Extract(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir, map[string][]byte{"sample.txt": files["sample.txt"]})
+	})
+}
+
+// Test extraction of different files with the same destination path.
+func TestExtractDup(t *testing.T) {
+	var err error
+	var pkgpath = path.Join(t.TempDir(), "dup.wpk")
+	var files = map[string][]byte{
+		"docs/note.txt":  []byte("note"),
+		"docs//note.txt": []byte("double slash note"),
+		"x.txt":          []byte("x"),
+		"../x.txt":       []byte("evil x"),
+		"./y.txt":        []byte("y"),
+		"../y.txt":       []byte("evil y"),
+	}
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		for fkey, data := range files {
+			if _, err = pkg.PackData(fwpk, bytes.NewReader(data), fkey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var check = func(t *testing.T, dir string, expect map[string]string) {
+		t.Helper()
+		for name, fkey := range expect {
+			var b []byte
+			if b, err = os.ReadFile(path.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, files[fkey]) {
+				t.Fatalf("extracted file '%s' has content '%s', expected content of '%s'", name, b, fkey)
+			}
+		}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		var dir = path.Join(t.TempDir(), "dst")
+		if err = pkg.Extract(dir, wpk.ExtractOpts{
+			Include: []string{"note.txt"},
+			Workers: 4,
+		}); !errors.Is(err, wpk.ErrDupPath) {
+			t.Fatalf("expected duplicate path error, got %v", err)
+		}
+		if _, err = os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+			t.Fatal("files are extracted on duplicate path error")
+		}
+	})
+
+	// files enumeration order is not determined, so repeat
+	// extraction to check up that result does not depend on it
+	for i := 0; i < 8; i++ {
+		t.Run("skip", func(t *testing.T) {
+			var dir = t.TempDir()
+			if err = pkg.Extract(dir, wpk.ExtractOpts{Path: wpk.PathSkip, Workers: 4}); err != nil {
+				t.Fatal(err)
+			}
+			check(t, dir, map[string]string{
+				"docs/note.txt": "docs/note.txt",
+				"x.txt":         "x.txt",
+				"y.txt":         "./y.txt",
+			})
+		})
+
+		t.Run("rewrite", func(t *testing.T) {
+			var dir = t.TempDir()
+			if err = pkg.Extract(dir, wpk.ExtractOpts{Path: wpk.PathRewrite, Workers: 4}); err != nil {
+				t.Fatal(err)
+			}
+			check(t, dir, map[string]string{
+				"docs/note.txt": "docs/note.txt",
+				"x.txt":         "x.txt",
+				"y.txt":         "../y.txt",
+			})
+		})
+	}
+}
+
+// The End.
@@ -0,0 +1,637 @@
+package wpk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Severity is the importance level of problem found by package check.
+type Severity int
+
+const (
+	SevInfo  Severity = iota // remark, package is correct
+	SevWarn                  // package is usable, but something is suboptimal or suspicious
+	SevError                 // package can not be opened, or some files are broken
+	SevFatal                 // package structure is broken, check can not be continued
+)
+
+var sevname = [...]string{"info", "warning", "error", "fatal"}
+
+func (s Severity) String() string {
+	if s >= 0 && int(s) < len(sevname) {
+		return sevname[s]
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Errors found by package check.
+var (
+	ErrHdrSize   = errors.New("header is truncated")
+	ErrBuildSign = errors.New("package is left in building state")
+	ErrNoDatFile = errors.New("data file of splitted package is not given")
+	ErrFttRange  = errors.New("file tags table is out of file bounds")
+	ErrDatRange  = errors.New("data section is out of file bounds")
+	ErrLayout    = errors.New("file tags table overlaps header or data section")
+	ErrFttCount  = errors.New("number of records differs from header")
+	ErrFttSize   = errors.New("file tags table size differs from header")
+	ErrTagsetBad = errors.New("tagset is broken")
+	ErrPathNorm  = errors.New("file name is not normalized")
+	ErrPathDup   = errors.New("file name is not unique")
+	ErrOverlap   = errors.New("file data overlaps other data")
+	ErrHashBad   = errors.New("hash does not match file content")
+	ErrTrailing  = errors.New("trailing data after package end")
+	ErrUnused    = errors.New("data section contains unused bytes")
+	ErrNoRepair  = errors.New("package can not be repaired")
+)
+
+// Finding is the problem found by package check.
+type Finding struct {
+	Sev  Severity // importance level
+	Key  string   // file name, empty for whole package findings
+	TID  TID      // tag ID, if finding is related to some tag
+	What error    // found problem
+}
+
+func (f *Finding) String() string {
+	var sb strings.Builder
+	sb.WriteString(f.Sev.String())
+	sb.WriteString(": ")
+	if f.Key != "" {
+		fmt.Fprintf(&sb, "key '%s', ", f.Key)
+	}
+	if f.TID != TIDnone {
+		fmt.Fprintf(&sb, "tag ID %d, ", f.TID)
+	}
+	sb.WriteString(f.What.Error())
+	return sb.String()
+}
+
+// FsckOpts is the set of options for package check.
+type FsckOpts struct {
+	Hashes   bool         // verify hashes of files content
+	Secret   []byte       // private key for MD5 and SHA hashes
+	Progress ProgressFunc // called after each file with verified hashes
+}
+
+// FsckReport contains results of package check.
+type FsckReport struct {
+	Findings []Finding // found problems in order of check
+	Files    int       // number of checked file tagsets
+	Blocks   int       // number of unique data blocks
+	Hashed   int       // number of verified hashes
+}
+
+// Worst returns the highest severity of all findings,
+// or SevInfo if there is no any findings.
+func (rep *FsckReport) Worst() (sev Severity) {
+	for _, f := range rep.Findings {
+		if f.Sev > sev {
+			sev = f.Sev
+		}
+	}
+	return
+}
+
+func (rep *FsckReport) add(sev Severity, fkey string, tid TID, what error) {
+	rep.Findings = append(rep.Findings, Finding{sev, fkey, tid, what})
+}
+
+// fsckitem is the file record found at checked package.
+type fsckitem struct {
+	key          string    // normalized file name
+	ts           TagsetRaw // file tagset
+	offset, size uint      // data position
+	bad          bool      // file data can not be restored
+	dupfid       bool      // file ID is used by other file
+}
+
+// fsck keeps the state of package check.
+type fsck struct {
+	FsckOpts
+	ctx          context.Context
+	rep          FsckReport
+	wpt, wpf     io.ReadSeeker
+	tsize, dsize int64 // sizes of tags table file and data file
+	split        bool
+	hdr          Header
+	info         TagsetRaw
+	items        []*fsckitem
+	fatal        bool
+}
+
+func (c *fsck) fail(what error) {
+	c.rep.add(SevFatal, "", TIDnone, what)
+	c.fatal = true
+}
+
+// checkheader checks up header and package layout.
+func (c *fsck) checkheader() (err error) {
+	if c.tsize, err = c.wpt.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+	c.dsize = c.tsize
+	if c.split {
+		if c.dsize, err = c.wpf.Seek(0, io.SeekEnd); err != nil {
+			return
+		}
+	}
+	if c.tsize < HeaderSize {
+		c.fail(ErrHdrSize)
+		return
+	}
+	var buf [HeaderSize]byte
+	if _, err = c.wpt.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(c.wpt, buf[:]); err != nil {
+		return
+	}
+	c.hdr.Parse(buf[:])
+	var hdr = &c.hdr
+
+	switch B2S(hdr.signature[:]) {
+	case SignReady:
+	case SignBuild:
+		if hdr.datoffset == 0 {
+			c.rep.add(SevWarn, "", TIDnone, ErrBuildSign)
+		} else {
+			c.rep.add(SevError, "", TIDnone, ErrBuildSign)
+		}
+	default:
+		c.fail(ErrSignBad)
+		return
+	}
+
+	if hdr.datoffset == 0 && !c.split {
+		c.fail(ErrNoDatFile)
+		return
+	}
+	if hdr.datoffset != 0 && hdr.datoffset < HeaderSize {
+		c.fail(ErrLayout)
+		return
+	}
+	var fttend = hdr.fttoffset + hdr.fttsize
+	if hdr.fttsize > 0 && (hdr.fttoffset < HeaderSize || fttend < hdr.fttoffset || fttend > uint64(c.tsize)) {
+		c.fail(ErrFttRange)
+		return
+	}
+	var datend = hdr.datoffset + hdr.datsize
+	if datend < hdr.datoffset || datend > uint64(c.dsize) {
+		c.rep.add(SevError, "", TIDnone, ErrDatRange)
+	}
+	if !c.split && hdr.fttsize > 0 && hdr.fttoffset < datend && fttend > hdr.datoffset {
+		c.rep.add(SevError, "", TIDnone, ErrLayout)
+	}
+	return
+}
+
+// checktable parses file tags table and checks up each tagset.
+func (c *fsck) checktable() (err error) {
+	var hdr = &c.hdr
+	if hdr.fttsize == 0 {
+		if hdr.fttcount != 0 {
+			c.rep.add(SevWarn, "", TIDnone, ErrFttCount)
+		}
+		return
+	}
+	var buf = make([]byte, hdr.fttsize)
+	if _, err = c.wpt.Seek(int64(hdr.fttoffset), io.SeekStart); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(c.wpt, buf); err != nil {
+		return
+	}
+
+	var n int
+	var next = func() (ts TagsetRaw, ok bool) {
+		if n+PTStssize > len(buf) {
+			return
+		}
+		var tsl = int(GetU16(buf[n:]))
+		n += PTStssize
+		if n+tsl > len(buf) {
+			return
+		}
+		ts, ok = TagsetRaw(buf[n:n+tsl]), true
+		n += tsl
+		return
+	}
+	var valid = func(ts TagsetRaw) bool {
+		var tsi = ts.Iterator()
+		for tsi.Next() {
+		}
+		return !tsi.Failed()
+	}
+
+	// package info
+	var ts, ok = next()
+	if !ok {
+		c.fail(ErrTagsetBad)
+		return
+	}
+	if valid(ts) {
+		c.info = ts
+	} else {
+		c.rep.add(SevError, "", TIDnone, fmt.Errorf("package info: %w", ErrTagsetBad))
+	}
+
+	var keys = map[string]string{} // normalized keys to original
+	var fids = map[uint]uint{}     // file IDs to offsets
+	var num int
+	for {
+		if ts, ok = next(); !ok {
+			c.rep.add(SevError, "", TIDnone, fmt.Errorf("%w, end marker is not reached", ErrFttSize))
+			break
+		}
+		if len(ts) == 0 {
+			break // end marker was reached
+		}
+		num++
+		if !valid(ts) {
+			c.rep.add(SevError, "", TIDnone, fmt.Errorf("tagset #%d: %w", num, ErrTagsetBad))
+			continue
+		}
+		c.rep.Files++
+
+		var fkey, ispath = ts.TagStr(TIDpath)
+		if !ispath {
+			c.rep.add(SevError, fmt.Sprintf("#%d", num), TIDpath, ErrNoPath)
+			continue
+		}
+		var item = &fsckitem{ts: ts}
+		var isoffset, issize bool
+		item.offset, isoffset = ts.TagUint(TIDoffset)
+		item.size, issize = ts.TagUint(TIDsize)
+
+		// check up file name
+		item.key = path.Clean("/" + ToSlash(fkey))[1:]
+		if item.key == "" {
+			c.rep.add(SevError, fkey, TIDpath, ErrPathNorm)
+			continue
+		}
+		if item.key != fkey {
+			c.rep.add(SevWarn, fkey, TIDpath, ErrPathNorm)
+		}
+		if orig, ok := keys[item.key]; ok {
+			if orig == fkey {
+				c.rep.add(SevError, fkey, TIDpath, ErrPathDup)
+			} else {
+				c.rep.add(SevWarn, fkey, TIDpath, fmt.Errorf("%w, normalized name is the same as for '%s'", ErrPathDup, orig))
+			}
+			continue
+		}
+		keys[item.key] = fkey
+
+		// check up data position
+		if !isoffset {
+			c.rep.add(SevError, fkey, TIDoffset, ErrNoOffset)
+			continue
+		}
+		if !issize {
+			c.rep.add(SevError, fkey, TIDsize, ErrNoSize)
+			continue
+		}
+		var datend = hdr.datoffset + hdr.datsize
+		if uint64(item.offset) < hdr.datoffset || uint64(item.offset) > datend {
+			c.rep.add(SevError, fkey, TIDoffset, ErrOutOff)
+		} else if uint64(item.offset+item.size) > datend {
+			c.rep.add(SevError, fkey, TIDsize, ErrOutSize)
+		}
+		if item.offset+item.size < item.offset || int64(item.offset+item.size) > c.dsize {
+			c.rep.add(SevError, fkey, TIDsize, fmt.Errorf("%w, data is beyond the end of file", ErrOutSize))
+			item.bad = true
+		}
+
+		// check up file ID uniqueness
+		if fid, ok := ts.TagUint(TIDfid); ok {
+			if offset, ok := fids[fid]; ok && offset != item.offset {
+				c.rep.add(SevWarn, fkey, TIDfid, ErrFidDup)
+				item.dupfid = true
+			} else {
+				fids[fid] = item.offset
+			}
+		}
+		c.items = append(c.items, item)
+	}
+	if uint64(n) != hdr.fttsize {
+		c.rep.add(SevWarn, "", TIDnone, ErrFttSize)
+	}
+	if uint64(num) != hdr.fttcount {
+		c.rep.add(SevWarn, "", TIDnone, ErrFttCount)
+	}
+	return
+}
+
+// checkdata checks up data blocks overlapping, unused space and trailing data.
+func (c *fsck) checkdata() {
+	var hdr = &c.hdr
+	var list = make([]*fsckitem, 0, len(c.items))
+	for _, item := range c.items {
+		if !item.bad {
+			list = append(list, item)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].offset != list[j].offset {
+			return list[i].offset < list[j].offset
+		}
+		return list[i].size < list[j].size
+	})
+
+	var fttoff, fttend = uint(hdr.fttoffset), uint(hdr.fttoffset + hdr.fttsize)
+	var prev *fsckitem
+	var maxend, used uint
+	for _, item := range list {
+		var end = item.offset + item.size
+		if prev != nil && prev.offset == item.offset && prev.size == item.size {
+			continue // alias
+		}
+		c.rep.Blocks++
+		if item.size == 0 {
+			continue
+		}
+		if prev != nil && item.offset < maxend {
+			c.rep.add(SevError, item.key, TIDoffset, fmt.Errorf("%w of '%s'", ErrOverlap, prev.key))
+		}
+		if !c.split && hdr.fttsize > 0 && item.offset < fttend && end > fttoff {
+			c.rep.add(SevError, item.key, TIDoffset, fmt.Errorf("%w of file tags table", ErrOverlap))
+		}
+		if end > maxend {
+			if item.offset > maxend {
+				used += item.size
+			} else {
+				used += end - maxend
+			}
+			maxend = end
+		}
+		prev = item
+	}
+	if used < uint(hdr.datsize) {
+		c.rep.add(SevInfo, "", TIDnone, fmt.Errorf("%w, %d of %d", ErrUnused, uint(hdr.datsize)-used, hdr.datsize))
+	}
+
+	var datend = hdr.datoffset + hdr.datsize
+	if c.split {
+		if uint64(c.tsize) > hdr.fttoffset+hdr.fttsize && uint64(c.tsize) > HeaderSize {
+			c.rep.add(SevWarn, "", TIDnone, fmt.Errorf("%w in tags table file", ErrTrailing))
+		}
+		if uint64(c.dsize) > datend {
+			c.rep.add(SevWarn, "", TIDnone, fmt.Errorf("%w in data file", ErrTrailing))
+		}
+	} else {
+		var end = datend
+		if fttend := hdr.fttoffset + hdr.fttsize; fttend > end {
+			end = fttend
+		}
+		if uint64(c.tsize) > end {
+			c.rep.add(SevWarn, "", TIDnone, fmt.Errorf("%w, %d bytes", ErrTrailing, uint64(c.tsize)-end))
+		}
+	}
+}
+
+// checkhashes verifies hashes of files content.
+func (c *fsck) checkhashes() (err error) {
+	type hashkey struct {
+		offset, size uint
+		tid          TID
+	}
+	var sums = map[hashkey][]byte{}
+	var prg Progress
+	for _, item := range c.items {
+		if !item.bad {
+			prg.FilesTotal++
+			prg.BytesTotal += int64(item.size)
+		}
+	}
+	c.Progress.report(&prg)
+	for _, item := range c.items {
+		if item.bad {
+			continue
+		}
+		if err = c.ctx.Err(); err != nil {
+			return
+		}
+		var tids []TID
+		var hs []hash.Hash
+		var ws []io.Writer
+		for _, tid := range HashTIDs {
+			if !item.ts.Has(tid) {
+				continue
+			}
+			if _, ok := sums[hashkey{item.offset, item.size, tid}]; ok {
+				continue
+			}
+			var h = NewHash(tid, c.Secret)
+			tids, hs, ws = append(tids, tid), append(hs, h), append(ws, h)
+		}
+		if len(hs) > 0 {
+			if _, err = c.wpf.Seek(int64(item.offset), io.SeekStart); err != nil {
+				return
+			}
+			if _, err = io.CopyN(io.MultiWriter(ws...), ctxreader{c.ctx, c.wpf}, int64(item.size)); err != nil {
+				return
+			}
+			for i, tid := range tids {
+				sums[hashkey{item.offset, item.size, tid}] = hs[i].Sum(nil)
+			}
+		}
+		for _, tid := range HashTIDs {
+			var tag, ok = item.ts.Get(tid)
+			if !ok {
+				continue
+			}
+			c.rep.Hashed++
+			if !bytes.Equal(tag, sums[hashkey{item.offset, item.size, tid}]) {
+				c.rep.add(SevError, item.key, tid, ErrHashBad)
+				item.bad = true
+			}
+		}
+		prg.FKey = item.key
+		prg.Files++
+		prg.Bytes += int64(item.size)
+		c.Progress.report(&prg)
+	}
+	return
+}
+
+// run performs all checks.
+func (c *fsck) run() (err error) {
+	if err = c.checkheader(); err != nil || c.fatal {
+		return
+	}
+	if err = c.checktable(); err != nil || c.fatal {
+		return
+	}
+	c.checkdata()
+	if c.Hashes {
+		if err = c.checkhashes(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func newfsck(ctx context.Context, wpt, wpf io.ReadSeeker, opts FsckOpts) *fsck {
+	var c = &fsck{FsckOpts: opts, ctx: ctx, wpt: wpt, wpf: wpf}
+	c.split = wpf != nil && wpf != wpt
+	if !c.split {
+		c.wpf = wpt
+	}
+	return c
+}
+
+// Fsck deeply checks up package given by tags table stream and data stream.
+// Data stream can be nil or the same as tags table stream for single package file.
+// It checks header consistency, file tags table parsing, offset and size bounds
+// of each file, data ranges overlapping except aliases, file names uniqueness
+// and normalization, hashes if it's needed, and trailing data. All found
+// problems are returned at report, error is returned only on I/O failure.
+func Fsck(wpt, wpf io.ReadSeeker, opts FsckOpts) (rep *FsckReport, err error) {
+	return FsckCtx(context.Background(), wpt, wpf, opts)
+}
+
+// FsckCtx is Fsck with context. On cancellation it stops
+// and returns context error with findings of passed checks.
+func FsckCtx(ctx context.Context, wpt, wpf io.ReadSeeker, opts FsckOpts) (rep *FsckReport, err error) {
+	var c = newfsck(ctx, wpt, wpf, opts)
+	err = c.run()
+	rep = &c.rep
+	return
+}
+
+// Repair checks up package as Fsck does, and writes corrected copy of it
+// to given writer as single package file. Copy contains all files with
+// readable data, and without hashes mismatch if hashes verification is
+// turned on. File names are normalized, duplicates are skipped, aliases
+// remain aliases, duplicated file IDs are removed. Returns ErrNoRepair if
+// package structure is broken so that it can not be read.
+func Repair(w io.WriteSeeker, wpt, wpf io.ReadSeeker, opts FsckOpts) (rep *FsckReport, err error) {
+	return RepairCtx(context.Background(), w, wpt, wpf, opts)
+}
+
+// RepairCtx is Repair with context. On cancellation it stops and returns
+// context error, written copy is left in building state in this case.
+func RepairCtx(ctx context.Context, w io.WriteSeeker, wpt, wpf io.ReadSeeker, opts FsckOpts) (rep *FsckReport, err error) {
+	var c = newfsck(ctx, wpt, wpf, opts)
+	err = c.run()
+	rep = &c.rep
+	if err != nil {
+		return
+	}
+	if c.fatal {
+		err = ErrNoRepair
+		return
+	}
+
+	var pkg = NewPackage()
+	if err = pkg.Begin(w, nil); err != nil {
+		return
+	}
+	if c.info != nil {
+		pkg.SetInfo(CopyTagset(c.info))
+	}
+	type blockkey struct {
+		offset, size uint
+	}
+	var blocks = map[blockkey]uint{}
+	for _, item := range c.items {
+		if item.bad || pkg.HasTagset(item.key) {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var bk = blockkey{item.offset, item.size}
+		var ts TagsetRaw
+		if offset, ok := blocks[bk]; ok {
+			ts = pkg.BaseTagset(offset, item.size, item.key)
+			pkg.SetTagset(item.key, ts)
+		} else {
+			if _, err = c.wpf.Seek(int64(item.offset), io.SeekStart); err != nil {
+				return
+			}
+			if ts, err = pkg.PackData(w, io.LimitReader(ctxreader{ctx, c.wpf}, int64(item.size)), item.key); err != nil {
+				return
+			}
+			blocks[bk], _ = ts.Pos()
+		}
+		var tsi = item.ts.Iterator()
+		for tsi.Next() {
+			switch tsi.TID() {
+			case TIDoffset, TIDsize, TIDpath:
+				continue
+			case TIDfid:
+				if item.dupfid {
+					continue
+				}
+			}
+			ts = ts.Put(tsi.TID(), tsi.Tag())
+		}
+		pkg.SetTagset(item.key, ts)
+	}
+	err = pkg.Sync(w, nil)
+	return
+}
+
+// openfsck opens files of package with given path for check up.
+// Data file is opened if header points to splitted package.
+func openfsck(pkgpath string) (wpt, wpf *os.File, err error) {
+	if wpt, err = os.Open(pkgpath); err != nil {
+		return
+	}
+	var hdr Header
+	if _, err := hdr.ReadFrom(wpt); err == nil && hdr.datoffset == 0 && hdr.IsReady() == nil {
+		if f, err := os.Open(MakeDataPath(pkgpath)); err == nil {
+			wpf = f
+		}
+	}
+	return
+}
+
+// FsckFile checks up package with given file name, it calls `Fsck`
+// method with file streams. For splitted package it should be
+// the file with tags table, data file is opened by its header.
+func FsckFile(pkgpath string, opts FsckOpts) (rep *FsckReport, err error) {
+	var wpt, wpf *os.File
+	if wpt, wpf, err = openfsck(pkgpath); err != nil {
+		return
+	}
+	defer wpt.Close()
+	if wpf != nil {
+		defer wpf.Close()
+		return Fsck(wpt, wpf, opts)
+	}
+	return Fsck(wpt, nil, opts)
+}
+
+// RepairFile checks up package with given file name, and writes
+// corrected copy of it to single package file with destination name.
+func RepairFile(dstpath, pkgpath string, opts FsckOpts) (rep *FsckReport, err error) {
+	var wpt, wpf *os.File
+	if wpt, wpf, err = openfsck(pkgpath); err != nil {
+		return
+	}
+	defer wpt.Close()
+	var rs io.ReadSeeker
+	if wpf != nil {
+		defer wpf.Close()
+		rs = wpf
+	}
+
+	var dst *os.File
+	if dst, err = os.OpenFile(dstpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		return
+	}
+	defer dst.Close()
+	return Repair(dst, wpt, rs, opts)
+}
+
+// The End.
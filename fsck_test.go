@@ -0,0 +1,183 @@
+package wpk_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+var testrepair = wpk.TempPath("testrepair.wpk")
+
+// HasFinding checks up that report contains finding with given severity and error.
+func HasFinding(rep *wpk.FsckReport, sev wpk.Severity, what error) bool {
+	for _, f := range rep.Findings {
+		if f.Sev == sev && errors.Is(f.What, what) {
+			return true
+		}
+	}
+	return false
+}
+
+// Test package check up and repair.
+func TestFsck(t *testing.T) {
+	var err error
+	var secret = []byte("secret")
+	var opts = wpk.FsckOpts{Hashes: true, Secret: secret}
+
+	defer os.Remove(testpack)
+	defer os.Remove(testrepair)
+
+	// make package with hashes
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, fkey := range []string{"sample.txt", "array.dat"} {
+		var ts wpk.TagsetRaw
+		if ts, err = pkg.PackData(fwpk, bytes.NewReader(memdata[fkey]), fkey); err != nil {
+			t.Fatal(err)
+		}
+		for _, tid := range []wpk.TID{wpk.TIDcrc32c, wpk.TIDsha256} {
+			var h = wpk.NewHash(tid, secret)
+			h.Write(memdata[fkey])
+			ts = ts.Put(tid, h.Sum(nil))
+		}
+		pkg.SetTagset(fkey, ts)
+	}
+	if err = pkg.PutAlias("sample.txt", "dir/alias.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	fwpk.Close()
+
+	var rep *wpk.FsckReport
+	t.Run("clean", func(t *testing.T) {
+		if rep, err = wpk.FsckFile(testpack, opts); err != nil {
+			t.Fatal(err)
+		}
+		if sev := rep.Worst(); sev != wpk.SevInfo {
+			t.Fatalf("expected clean package, got %s: %s", sev, rep.Findings[0].String())
+		}
+		if rep.Files != 3 || rep.Blocks != 2 || rep.Hashed != 6 {
+			t.Fatalf("unexpected statistics: %d files, %d blocks, %d hashes", rep.Files, rep.Blocks, rep.Hashed)
+		}
+	})
+
+	t.Run("damaged", func(t *testing.T) {
+		var f *os.File
+		if f, err = os.OpenFile(testpack, os.O_RDWR, 0644); err != nil {
+			t.Fatal(err)
+		}
+		// damage content of 'sample.txt'
+		if _, err = f.WriteAt([]byte("Q"), wpk.HeaderSize+4); err != nil {
+			t.Fatal(err)
+		}
+		// put trailing garbage
+		if _, err = f.Seek(0, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte("garbage")); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		if rep, err = wpk.FsckFile(testpack, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !HasFinding(rep, wpk.SevError, wpk.ErrHashBad) {
+			t.Fatal("hash mismatch is not found")
+		}
+		if !HasFinding(rep, wpk.SevWarn, wpk.ErrTrailing) {
+			t.Fatal("trailing data is not found")
+		}
+		if rep, err = wpk.FsckFile(testpack, wpk.FsckOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		if sev := rep.Worst(); sev != wpk.SevWarn {
+			t.Fatalf("expected warning without hashes verification, got %s", sev)
+		}
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		if rep, err = wpk.RepairFile(testrepair, testpack, opts); err != nil {
+			t.Fatal(err)
+		}
+		if rep, err = wpk.FsckFile(testrepair, opts); err != nil {
+			t.Fatal(err)
+		}
+		if sev := rep.Worst(); sev != wpk.SevInfo {
+			t.Fatalf("expected clean repaired package, got %s: %s", sev, rep.Findings[0].String())
+		}
+		var fixed = wpk.NewPackage()
+		if err = fixed.OpenFile(testrepair); err != nil {
+			t.Fatal(err)
+		}
+		if fixed.HasTagset("sample.txt") || fixed.HasTagset("dir/alias.txt") {
+			t.Fatal("damaged files are present in repaired package")
+		}
+		if !fixed.HasTagset("array.dat") {
+			t.Fatal("undamaged file is absent in repaired package")
+		}
+	})
+
+	t.Run("overlap", func(t *testing.T) {
+		if fwpk, err = os.OpenFile(testpack, os.O_RDWR, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+		pkg = wpk.NewPackage()
+		if err = pkg.OpenStream(fwpk); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Append(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		var ts, _ = pkg.GetTagset("array.dat")
+		var offset, size = ts.Pos()
+		pkg.SetTagset("over.dat", pkg.BaseTagset(offset-2, size, "over.dat"))
+		pkg.SetTagset("../up.dat", pkg.BaseTagset(offset, size, "../up.dat"))
+		if _, err = pkg.PackData(fwpk, bytes.NewReader(memdata["array.dat"]), "unused.dat"); err != nil {
+			t.Fatal(err)
+		}
+		pkg.DelTagset("unused.dat")
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if rep, err = wpk.Fsck(fwpk, nil, wpk.FsckOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		if !HasFinding(rep, wpk.SevError, wpk.ErrOverlap) {
+			t.Fatal("data overlapping is not found")
+		}
+		if !HasFinding(rep, wpk.SevWarn, wpk.ErrPathNorm) {
+			t.Fatal("not normalized path is not found")
+		}
+		if !HasFinding(rep, wpk.SevInfo, wpk.ErrUnused) {
+			t.Fatal("unused data is not found")
+		}
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		if rep, err = wpk.Fsck(bytes.NewReader(make([]byte, wpk.HeaderSize)), nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !HasFinding(rep, wpk.SevFatal, wpk.ErrSignBad) {
+			t.Fatal("bad signature is not found")
+		}
+		if _, err = wpk.Repair(nil, bytes.NewReader(make([]byte, 10)), nil, opts); !errors.Is(err, wpk.ErrNoRepair) {
+			t.Fatalf("expected repair error, got %v", err)
+		}
+	})
+}
+
+// The End.
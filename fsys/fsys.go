@@ -1,9 +1,11 @@
 package fsys
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"os"
+	"sync"
 
 	"github.com/schwarzlichtbezirk/wpk"
 )
@@ -13,24 +15,29 @@ type ReaserAtCloser interface {
 	io.Closer
 }
 
+var (
+	ErrBusy = errors.New("tagger has opened nested files")
+)
+
 // ChunkFile structure gives access to nested into package file.
 // wpk.RFile interface implementation.
 type ChunkFile struct {
 	wpk.PkgReader
-	wpkf io.Closer
-	tags wpk.TagsetRaw // has fs.FileInfo interface
+	tgr    *Tagger
+	tags   wpk.TagsetRaw // has fs.FileInfo interface
+	closed bool
 }
 
 // NewChunkFile creates ChunkFile file structure based on given tags slice.
-func NewChunkFile(fpath string, ts wpk.TagsetRaw) (f *ChunkFile, err error) {
-	var wpkf wpk.RFile
-	if wpkf, err = os.Open(fpath); err != nil {
+// File reads sections of shared package file handle of given tagger.
+func NewChunkFile(tgr *Tagger, ts wpk.TagsetRaw) (f *ChunkFile, err error) {
+	if err = tgr.acquire(); err != nil {
 		return
 	}
 	var offset, size = ts.Pos()
 	f = &ChunkFile{
-		PkgReader: io.NewSectionReader(wpkf, int64(offset), int64(size)),
-		wpkf:      wpkf,
+		PkgReader: io.NewSectionReader(tgr.wpkf, int64(offset), int64(size)),
+		tgr:       tgr,
 		tags:      ts,
 	}
 	return
@@ -41,34 +48,105 @@ func (f *ChunkFile) Stat() (fs.FileInfo, error) {
 	return f.tags, nil
 }
 
-// Close closes associated wpk-file handle.
+// Close releases the file at tagger, shared package
+// file handle remains opened.
 func (f *ChunkFile) Close() error {
-	return f.wpkf.Close()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.tgr.release()
+	return nil
 }
 
 // Tagger is object to get access to package nested files
-// by sections of wpk-file reading.
+// by sections of wpk-file reading. All nested files share
+// single package file handle opened at tagger creation,
+// reading is performed by ReadAt calls that can be run
+// concurrently. Tagger counts opened nested files.
 type Tagger struct {
-	dpath string // package filename
+	wpkf   *os.File // package file handle
+	refs   int      // number of opened nested files
+	closed bool
+	mux    sync.Mutex
+	cond   *sync.Cond // signals when all nested files are closed
 }
 
 // MakeTagger creates Tagger object to get access to package nested files.
 func MakeTagger(fpath string) (wpk.Tagger, error) {
+	var err error
 	var tgr Tagger
-	tgr.dpath = fpath
+	if tgr.wpkf, err = os.Open(fpath); err != nil {
+		return nil, err
+	}
+	tgr.cond = sync.NewCond(&tgr.mux)
 	return &tgr, nil
 }
 
+// acquire increments the counter of opened nested files.
+func (tgr *Tagger) acquire() error {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	if tgr.closed {
+		return fs.ErrClosed
+	}
+	tgr.refs++
+	return nil
+}
+
+// release decrements the counter of opened nested files.
+func (tgr *Tagger) release() {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	tgr.refs--
+	if tgr.refs == 0 {
+		tgr.cond.Broadcast()
+	}
+}
+
+// Refs returns number of opened nested files.
+func (tgr *Tagger) Refs() int {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	return tgr.refs
+}
+
 // OpenTagset creates file object to give access to nested into package file by given tagset.
 func (tgr *Tagger) OpenTagset(ts wpk.TagsetRaw) (wpk.RFile, error) {
-	return NewChunkFile(tgr.dpath, ts)
+	return NewChunkFile(tgr, ts)
 }
 
-// Close file handle. This function must be called only for root object,
-// not subdirectories.
+// Close closes package file handle. It fails with ErrBusy if there
+// are opened nested files, use CloseWait to wait until they are closed.
+// This function must be called only for root object, not subdirectories.
 // io.Closer implementation.
 func (tgr *Tagger) Close() error {
-	return nil
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	if tgr.closed {
+		return fs.ErrClosed
+	}
+	if tgr.refs > 0 {
+		return ErrBusy
+	}
+	tgr.closed = true
+	return tgr.wpkf.Close()
+}
+
+// CloseWait prevents opening of new nested files, waits
+// until all opened nested files are closed, and then
+// closes package file handle.
+func (tgr *Tagger) CloseWait() error {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	if tgr.closed {
+		return fs.ErrClosed
+	}
+	tgr.closed = true
+	for tgr.refs > 0 {
+		tgr.cond.Wait()
+	}
+	return tgr.wpkf.Close()
 }
 
 // The End.
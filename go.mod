@@ -6,7 +6,6 @@ require (
 	github.com/edsrzf/mmap-go v1.1.0
 	github.com/h2non/filetype v1.1.3
 	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/sys v0.15.0
 	gopkg.in/djherbis/times.v1 v1.3.0
 )
-
-require golang.org/x/sys v0.15.0 // indirect
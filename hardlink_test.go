@@ -0,0 +1,115 @@
+package wpk_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+// Test hard links detection on packing and their restoring on extraction.
+func TestHardLinks(t *testing.T) {
+	var err error
+	var dir = t.TempDir()
+	var srcdir = path.Join(dir, "src")
+	var pkgpath = path.Join(dir, "hardlink.wpk")
+	if err = os.MkdirAll(path.Join(srcdir, "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path.Join(srcdir, "a.dat"), memdata["array.dat"], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Link(path.Join(srcdir, "a.dat"), path.Join(srcdir, "sub/b.dat")); err != nil {
+		t.Skip("hard links are not supported:", err)
+	}
+
+	var list []wpk.PackSource
+	if list, err = wpk.ListDir(context.Background(), srcdir, "", wpk.DirOpts{HardLinks: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].HardLink != "" || list[1].HardLink != "a.dat" {
+		t.Skip("hard links are not detected on this system")
+	}
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pkg.PackPipeline(fwpk, list, wpk.PackOpts{
+			Hashes: []wpk.TID{wpk.TIDsha256},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var ts1, _ = pkg.GetTagset("a.dat")
+	var ts2, _ = pkg.GetTagset("sub/b.dat")
+	var offset1, size1 = ts1.Pos()
+	var offset2, size2 = ts2.Pos()
+	if offset1 != offset2 || size1 != size2 {
+		t.Fatal("hard link has its own copy of data")
+	}
+	if pkg.DataSize() >= 2*uint(len(memdata["array.dat"])) {
+		t.Fatalf("package data size %d has extra data", pkg.DataSize())
+	}
+	if orig, ok := ts2.TagStr(wpk.TIDhardlink); !ok || orig != "a.dat" {
+		t.Fatalf("hard link tag is '%s', expected 'a.dat'", orig)
+	}
+	if ts1.Has(wpk.TIDhardlink) {
+		t.Fatal("original file has hard link tag")
+	}
+	if fkey, _ := ts2.TagStr(wpk.TIDpath); fkey != "sub/b.dat" {
+		t.Fatalf("hard link has name '%s'", fkey)
+	}
+	if !ts2.Has(wpk.TIDsha256) {
+		t.Fatal("hard link has no hash of original file")
+	}
+
+	var extract = func(hardlinks bool) bool {
+		t.Helper()
+		var dstdir = t.TempDir()
+		if err = pkg.Extract(dstdir, wpk.ExtractOpts{HardLinks: hardlinks}); err != nil {
+			t.Fatal(err)
+		}
+		var fi1, fi2 os.FileInfo
+		if fi1, err = os.Stat(path.Join(dstdir, "a.dat")); err != nil {
+			t.Fatal(err)
+		}
+		if fi2, err = os.Stat(path.Join(dstdir, "sub/b.dat")); err != nil {
+			t.Fatal(err)
+		}
+		if fi2.Size() != int64(len(memdata["array.dat"])) {
+			t.Fatalf("extracted hard link has size %d", fi2.Size())
+		}
+		return os.SameFile(fi1, fi2)
+	}
+	if extract(false) {
+		t.Fatal("files are linked without hard links option")
+	}
+	if !extract(true) {
+		t.Fatal("hard link was not restored")
+	}
+}
+
+// The End.
@@ -0,0 +1,49 @@
+package wpk
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+)
+
+// HashTIDs is the list of tags IDs with hashes of file content.
+var HashTIDs = []TID{
+	TIDcrc32ieee, TIDcrc32c, TIDcrc32k, TIDcrc64iso,
+	TIDmd5, TIDsha1, TIDsha224, TIDsha256, TIDsha384, TIDsha512,
+}
+
+// NewHash returns new hash for the tag with given ID, or nil if
+// the tag is not a hash. Checksums are computed as is, MD5 and SHA
+// hashes are computed as HMAC signed by given secret key.
+func NewHash(tid TID, secret []byte) hash.Hash {
+	switch tid {
+	case TIDcrc32ieee:
+		return crc32.NewIEEE()
+	case TIDcrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case TIDcrc32k:
+		return crc32.New(crc32.MakeTable(crc32.Koopman))
+	case TIDcrc64iso:
+		return crc64.New(crc64.MakeTable(crc64.ISO))
+	case TIDmd5:
+		return hmac.New(md5.New, secret)
+	case TIDsha1:
+		return hmac.New(sha1.New, secret)
+	case TIDsha224:
+		return hmac.New(sha256.New224, secret)
+	case TIDsha256:
+		return hmac.New(sha256.New, secret)
+	case TIDsha384:
+		return hmac.New(sha512.New384, secret)
+	case TIDsha512:
+		return hmac.New(sha512.New, secret)
+	}
+	return nil
+}
+
+// The End.
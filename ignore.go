@@ -0,0 +1,109 @@
+package wpk
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the name of files with ignore rules in directories to pack.
+const IgnoreFileName = ".wpkignore"
+
+// ignorerule is single rule of ignore file.
+type ignorerule struct {
+	base    string   // directory of ignore file, relative to packing root
+	parts   []string // slash-separated pattern elements, "**" matches any number of elements
+	negate  bool     // rule starts with "!" and includes previously excluded file
+	dironly bool     // rule ends with "/" and matches only directories
+}
+
+// Ignore is the list of rules with gitignore-like syntax. Blank lines and
+// lines started with "#" are skipped. Pattern with slash at the beginning
+// or in the middle is relative to directory of ignore file, otherwise it
+// matches file or directory name at any level below. Pattern ended with
+// slash matches only directories. "*", "?" and "[...]" matches as by
+// path.Match, "**" matches any number of directories. Prefix "!" negates
+// the pattern, and the last matched rule wins. Files at excluded
+// directories can not be included back.
+type Ignore []ignorerule
+
+// Load reads rules from given reader, and appends them to the list.
+// Rules are relative to given directory, it's slash-separated path from
+// packing root, empty or "." for root itself.
+func (ig Ignore) Load(r io.Reader, dir string) (Ignore, error) {
+	if dir == "." {
+		dir = ""
+	}
+	var scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		var line = strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rule = ignorerule{base: dir}
+		if line[0] == '!' {
+			rule.negate, line = true, line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:] // escaped "#" or "!"
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dironly, line = true, strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			rule.parts = []string{"**", line}
+		} else {
+			rule.parts = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		}
+		ig = append(ig, rule)
+	}
+	return ig, scanner.Err()
+}
+
+// Match returns true if file or directory with given slash-separated path
+// relative to packing root should be ignored.
+func (ig Ignore) Match(fpath string, isdir bool) (ignored bool) {
+	for _, rule := range ig {
+		if rule.dironly && !isdir {
+			continue
+		}
+		var rel = fpath
+		if rule.base != "" {
+			if !strings.HasPrefix(fpath, rule.base+"/") {
+				continue
+			}
+			rel = fpath[len(rule.base)+1:]
+		}
+		if matchparts(rule.parts, strings.Split(rel, "/")) {
+			ignored = !rule.negate
+		}
+	}
+	return
+}
+
+// matchparts matches path elements to pattern elements with "**" support.
+func matchparts(parts, names []string) bool {
+	for len(parts) > 0 {
+		if parts[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchparts(parts[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(parts[0], names[0]); !ok {
+			return false
+		}
+		parts, names = parts[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// The End.
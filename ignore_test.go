@@ -0,0 +1,133 @@
+package wpk_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+func TestIgnore(t *testing.T) {
+	var err error
+	var ig wpk.Ignore
+	if ig, err = ig.Load(strings.NewReader(`
+# comment line
+*.log
+!keep.log
+build/
+/root.txt
+docs/**/draft.md
+\#hash
+`), ""); err != nil {
+		t.Fatal(err)
+	}
+	if ig, err = ig.Load(strings.NewReader("*.tmp\n"), "sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	var list = []struct {
+		fpath   string
+		isdir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"x/y/a.log", false, true},
+		{"x/keep.log", false, false},
+		{"build", true, true},
+		{"x/build", true, true},
+		{"build", false, false},
+		{"root.txt", false, true},
+		{"x/root.txt", false, false},
+		{"docs/draft.md", false, true},
+		{"docs/a/b/draft.md", false, true},
+		{"x/docs/draft.md", false, false},
+		{"#hash", false, true},
+		{"sub/a.tmp", false, true},
+		{"sub/x/a.tmp", false, true},
+		{"a.tmp", false, false},
+		{"main.go", false, false},
+	}
+	for _, v := range list {
+		if ig.Match(v.fpath, v.isdir) != v.ignored {
+			t.Errorf("Match(%q, %t) should be %t", v.fpath, v.isdir, v.ignored)
+		}
+	}
+}
+
+// Test files selection from directory with ignore files and filters.
+func TestListDir(t *testing.T) {
+	var err error
+	var dir = t.TempDir()
+	for fpath, data := range map[string]string{
+		".wpkignore":       "*.log\nbuild/\n",
+		"a.txt":            "a",
+		"a.log":            "log",
+		"build/out.bin":    "out",
+		"sub/.wpkignore":   "!*.log\n",
+		"sub/b.txt":        "b",
+		"sub/b.log":        "log",
+		"sub/large.dat":    strings.Repeat("x", 100),
+		"skip/c.txt":       "c",
+		"sub/deep/d.txt":   "d",
+		"sub/deep/e.proto": "e",
+	} {
+		var fpath = path.Join(dir, fpath)
+		if err = os.MkdirAll(path.Dir(fpath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink("../sub", path.Join(dir, "skip/link")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("a.txt", path.Join(dir, "a-link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys = func(opts wpk.DirOpts) (res []string) {
+		t.Helper()
+		var list []wpk.PackSource
+		if list, err = wpk.ListDir(context.Background(), dir, "pre", opts); err != nil {
+			t.Fatal(err)
+		}
+		for _, src := range list {
+			res = append(res, src.FKey)
+		}
+		return
+	}
+
+	if res, expect := keys(wpk.DirOpts{}), []string{
+		"pre/.wpkignore", "pre/a-link.txt", "pre/a.log", "pre/a.txt", "pre/build/out.bin", "pre/skip/c.txt",
+		"pre/sub/.wpkignore", "pre/sub/b.log", "pre/sub/b.txt", "pre/sub/deep/d.txt",
+		"pre/sub/deep/e.proto", "pre/sub/large.dat",
+	}; !reflect.DeepEqual(res, expect) {
+		t.Fatalf("unexpected list without options: %v", res)
+	}
+	if res, expect := keys(wpk.DirOpts{
+		IgnoreFile: wpk.IgnoreFileName,
+		Exclude:    []string{"skip"},
+		MaxSize:    50,
+	}), []string{
+		"pre/a-link.txt", "pre/a.txt", "pre/sub/b.log", "pre/sub/b.txt", "pre/sub/deep/d.txt", "pre/sub/deep/e.proto",
+	}; !reflect.DeepEqual(res, expect) {
+		t.Fatalf("unexpected list with ignore rules: %v", res)
+	}
+	if res, expect := keys(wpk.DirOpts{
+		IgnoreFile:  wpk.IgnoreFileName,
+		Include:     []string{"*.txt"},
+		FollowLinks: true,
+	}), []string{
+		"pre/a-link.txt", "pre/a.txt", "pre/skip/c.txt", "pre/skip/link/b.txt", "pre/skip/link/deep/d.txt",
+		"pre/sub/b.txt", "pre/sub/deep/d.txt",
+	}; !reflect.DeepEqual(res, expect) {
+		t.Fatalf("unexpected list with followed links: %v", res)
+	}
+}
+
+// The End.
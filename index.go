@@ -0,0 +1,285 @@
+package wpk
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fidrec is the record of unique file ID index. Aliases have the same
+// file ID and refers to the same data, so they are share the record.
+type fidrec struct {
+	offset uint     // offset of data in package
+	keys   []string // full paths of files with this file ID
+}
+
+// Index contains in-memory secondary indexes on tags of files tags table.
+// There is unique index on TIDfid, and inverted indexes on items of
+// delimiter-separated values of TIDkeywords and TIDcategory tags.
+// Keywords and categories are indexed case-insensitive.
+type Index struct {
+	fid map[uint]*fidrec
+	kw  map[string]map[string]Void
+	cat map[string]map[string]Void
+	mux sync.RWMutex
+}
+
+// NewIndex returns new empty index.
+func NewIndex() *Index {
+	return &Index{
+		fid: map[uint]*fidrec{},
+		kw:  map[string]map[string]Void{},
+		cat: map[string]map[string]Void{},
+	}
+}
+
+// CheckFID checks up that file ID of given tagset does not used by other
+// file with different data. Aliases with the same offset are allowed.
+func (idx *Index) CheckFID(ts TagsetRaw) bool {
+	var fid, ok = ts.TagUint(TIDfid)
+	if !ok {
+		return true
+	}
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+	var rec *fidrec
+	if rec, ok = idx.fid[fid]; !ok {
+		return true
+	}
+	var offset, _ = ts.TagUint(TIDoffset)
+	return rec.offset == offset
+}
+
+func invput(m map[string]map[string]Void, list []string, fkey string) {
+	for _, item := range list {
+		item = strings.ToLower(item)
+		var set, ok = m[item]
+		if !ok {
+			set = map[string]Void{}
+			m[item] = set
+		}
+		set[fkey] = Void{}
+	}
+}
+
+func invdel(m map[string]map[string]Void, list []string, fkey string) {
+	for _, item := range list {
+		item = strings.ToLower(item)
+		if set, ok := m[item]; ok {
+			delete(set, fkey)
+			if len(set) == 0 {
+				delete(m, item)
+			}
+		}
+	}
+}
+
+// put inserts tagset with given full path into indexes.
+// Mutex should be locked by caller.
+func (idx *Index) put(fkey string, ts TagsetRaw) {
+	if fid, ok := ts.TagUint(TIDfid); ok {
+		var rec, ok = idx.fid[fid]
+		if !ok {
+			var offset, _ = ts.TagUint(TIDoffset)
+			rec = &fidrec{offset: offset}
+			idx.fid[fid] = rec
+		}
+		var has bool
+		for _, key := range rec.keys {
+			if key == fkey {
+				has = true
+				break
+			}
+		}
+		if !has {
+			rec.keys = append(rec.keys, fkey)
+		}
+	}
+	if str, ok := ts.TagStr(TIDkeywords); ok {
+		invput(idx.kw, SplitKeywords(str), fkey)
+	}
+	if str, ok := ts.TagStr(TIDcategory); ok {
+		invput(idx.cat, SplitKeywords(str), fkey)
+	}
+}
+
+// del removes tagset with given full path from indexes.
+// Mutex should be locked by caller.
+func (idx *Index) del(fkey string, ts TagsetRaw) {
+	if fid, ok := ts.TagUint(TIDfid); ok {
+		if rec, ok := idx.fid[fid]; ok {
+			for i, key := range rec.keys {
+				if key == fkey {
+					rec.keys = append(rec.keys[:i], rec.keys[i+1:]...)
+					break
+				}
+			}
+			if len(rec.keys) == 0 {
+				delete(idx.fid, fid)
+			}
+		}
+	}
+	if str, ok := ts.TagStr(TIDkeywords); ok {
+		invdel(idx.kw, SplitKeywords(str), fkey)
+	}
+	if str, ok := ts.TagStr(TIDcategory); ok {
+		invdel(idx.cat, SplitKeywords(str), fkey)
+	}
+}
+
+// FID returns full paths of files with given file ID. The first one
+// is the file that was put at first, others are its aliases.
+func (idx *Index) FID(fid uint) []string {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+	if rec, ok := idx.fid[fid]; ok {
+		return append([]string{}, rec.keys...)
+	}
+	return nil
+}
+
+// Keyword returns sorted full paths of files with given keyword.
+func (idx *Index) Keyword(word string) []string {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+	return invget(idx.kw, word)
+}
+
+// Category returns sorted full paths of files with given category.
+func (idx *Index) Category(cat string) []string {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+	return invget(idx.cat, cat)
+}
+
+func invget(m map[string]map[string]Void, item string) (list []string) {
+	var set = m[strings.ToLower(strings.TrimSpace(item))]
+	list = make([]string, 0, len(set))
+	for fkey := range set {
+		list = append(list, fkey)
+	}
+	sort.Strings(list)
+	return
+}
+
+// BuildIndex makes secondary indexes on all tagsets of files tags table,
+// and keeps them in sync with tags table on any following changes.
+// Returns error if some file ID is not unique.
+func (ftt *FTT) BuildIndex() (err error) {
+	ftt.idxmux.Lock()
+	defer ftt.idxmux.Unlock()
+
+	var idx = NewIndex()
+	ftt.tsm.Range(func(fkey string, ts TagsetRaw) bool {
+		if !idx.CheckFID(ts) {
+			err = &ErrTag{ErrFidDup, fkey, TIDfid}
+			return false
+		}
+		idx.put(fkey, ts)
+		return true
+	})
+	if err != nil {
+		return
+	}
+	ftt.idx = idx
+	return
+}
+
+// DropIndex removes secondary indexes, so they are not synchronized anymore.
+func (ftt *FTT) DropIndex() {
+	ftt.idxmux.Lock()
+	ftt.idx = nil
+	ftt.idxmux.Unlock()
+}
+
+// GetIndex returns secondary indexes of files tags table. Indexes are built
+// on first call if they were not built on open or by BuildIndex before.
+func (ftt *FTT) GetIndex() (*Index, error) {
+	ftt.idxmux.Lock()
+	var idx = ftt.idx
+	ftt.idxmux.Unlock()
+	if idx != nil {
+		return idx, nil
+	}
+	if err := ftt.BuildIndex(); err != nil {
+		return nil, err
+	}
+	return ftt.GetIndex()
+}
+
+// poke puts tagset into tags table with synchronization of indexes.
+func (ftt *FTT) poke(fkey string, ts TagsetRaw) {
+	ftt.idxmux.Lock()
+	defer ftt.idxmux.Unlock()
+	if idx := ftt.idx; idx != nil {
+		idx.mux.Lock()
+		defer idx.mux.Unlock()
+		if old, ok := ftt.tsm.Peek(fkey); ok {
+			idx.del(fkey, old)
+		}
+		idx.put(fkey, ts)
+	}
+	ftt.tsm.Poke(fkey, ts)
+}
+
+// delete removes tagset from tags table with synchronization of indexes.
+func (ftt *FTT) delete(fkey string) (ts TagsetRaw, ok bool) {
+	ftt.idxmux.Lock()
+	defer ftt.idxmux.Unlock()
+	if ts, ok = ftt.tsm.Delete(fkey); ok && ftt.idx != nil {
+		ftt.idx.mux.Lock()
+		defer ftt.idx.mux.Unlock()
+		ftt.idx.del(fkey, ts)
+	}
+	return
+}
+
+// GetByFID returns file name and tagset of the file with given file ID.
+// If there are several aliases with this ID, the first one is returned.
+func (pkg *Package) GetByFID(fid uint) (fkey string, ts TagsetRaw, ok bool) {
+	var idx, err = pkg.GetIndex()
+	if err != nil {
+		return
+	}
+	for _, fullkey := range idx.FID(fid) {
+		if fkey = pkg.TrimPath(fullkey); fkey != "" {
+			ts, ok = pkg.tsm.Peek(fullkey)
+			return
+		}
+	}
+	return
+}
+
+// ByKeyword returns sorted names of the files in package workspace
+// that have given item in the keywords tag.
+func (pkg *Package) ByKeyword(word string) ([]string, error) {
+	var idx, err = pkg.GetIndex()
+	if err != nil {
+		return nil, err
+	}
+	return pkg.trimlist(idx.Keyword(word)), nil
+}
+
+// ByCategory returns sorted names of the files in package workspace
+// that have given item in the category tag.
+func (pkg *Package) ByCategory(cat string) ([]string, error) {
+	var idx, err = pkg.GetIndex()
+	if err != nil {
+		return nil, err
+	}
+	return pkg.trimlist(idx.Category(cat)), nil
+}
+
+// trimlist converts full paths to names at package workspace,
+// and skips the paths out of workspace.
+func (pkg *Package) trimlist(list []string) []string {
+	var res = list[:0]
+	for _, fullkey := range list {
+		if fkey := pkg.TrimPath(fullkey); fkey != "" && fkey != "." {
+			res = append(res, fkey)
+		}
+	}
+	return res
+}
+
+// The End.
@@ -0,0 +1,126 @@
+package wpk_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// Test secondary indexes synchronization with tags table.
+func TestIndex(t *testing.T) {
+	var err error
+	var pkg = wpk.NewPackage()
+	var put = func(fkey string, offset, fid uint, kw, cat string) {
+		var ts = pkg.BaseTagset(offset, 10, fkey).
+			Put(wpk.TIDfid, wpk.UintTag(fid))
+		if kw != "" {
+			ts = ts.Put(wpk.TIDkeywords, wpk.StrTag(kw))
+		}
+		if cat != "" {
+			ts = ts.Put(wpk.TIDcategory, wpk.StrTag(cat))
+		}
+		pkg.SetTagset(fkey, ts)
+	}
+	var check = func(list []string, err error, expected ...string) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(list, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected %v, got %v", expected, list)
+		}
+	}
+
+	put("a.jpg", 0, 1, "sea; Beach", "photo")
+	put("b.jpg", 10, 2, "beach,sun", "photo")
+	// build index on demand
+	if fkey, _, ok := pkg.GetByFID(2); !ok || fkey != "b.jpg" {
+		t.Fatalf("expected 'b.jpg' for FID 2, got '%s'", fkey)
+	}
+	put("c.jpg", 20, 3, "", "art")
+
+	var list []string
+	list, err = pkg.ByKeyword("beach")
+	check(list, err, "a.jpg", "b.jpg")
+	list, err = pkg.ByCategory("ART")
+	check(list, err, "c.jpg")
+
+	// replace tagset
+	put("a.jpg", 0, 1, "sea", "")
+	list, err = pkg.ByKeyword("beach")
+	check(list, err, "b.jpg")
+	list, err = pkg.ByCategory("photo")
+	check(list, err, "b.jpg")
+
+	// rename
+	if err = pkg.Rename("b.jpg", "d/b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if fkey, _, ok := pkg.GetByFID(2); !ok || fkey != "d/b.jpg" {
+		t.Fatalf("expected 'd/b.jpg' for FID 2, got '%s'", fkey)
+	}
+	list, err = pkg.ByKeyword("sun")
+	check(list, err, "d/b.jpg")
+
+	// alias shares file ID
+	if err = pkg.PutAlias("d/b.jpg", "e.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	list, err = pkg.ByKeyword("sun")
+	check(list, err, "d/b.jpg", "e.jpg")
+	pkg.DelTagset("d/b.jpg")
+	if fkey, _, ok := pkg.GetByFID(2); !ok || fkey != "e.jpg" {
+		t.Fatalf("expected 'e.jpg' for FID 2, got '%s'", fkey)
+	}
+	pkg.DelTagset("e.jpg")
+	if _, _, ok := pkg.GetByFID(2); ok {
+		t.Fatal("FID 2 expected to be deleted")
+	}
+	list, err = pkg.ByKeyword("sun")
+	check(list, err)
+
+	// workspace
+	if err = pkg.PutAlias("c.jpg", "d/c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	var sub, _ = pkg.Sub("d")
+	list, err = sub.(*wpk.Package).ByCategory("art")
+	check(list, err, "c.jpg")
+
+	// duplicate check
+	var ts = pkg.BaseTagset(30, 10, "f.jpg").Put(wpk.TIDfid, wpk.UintTag(3))
+	if _, err = pkg.CheckTagset(ts); !errors.Is(err, wpk.ErrFidDup) {
+		t.Fatalf("expected duplicate FID error, got %v", err)
+	}
+}
+
+// Test indexes building on package open.
+func TestIndexOpen(t *testing.T) {
+	PackFiles(t, testpack, []string{
+		"bounty.jpg",
+		"img1/claustral.jpg",
+	})
+	defer os.Remove(testpack)
+
+	var err error
+	var pkg = wpk.NewPackage()
+	pkg.Indexed = true
+	if err = pkg.OpenFile(testpack); err != nil {
+		t.Fatal(err)
+	}
+	var ts, _ = pkg.GetTagset("img1/claustral.jpg")
+	pkg.SetTagset("img1/claustral.jpg", wpk.CopyTagset(ts).
+		Put(wpk.TIDkeywords, wpk.StrTag("basalt;bay")))
+	var list []string
+	if list, err = pkg.ByKeyword("bay"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(list, ",") != "img1/claustral.jpg" {
+		t.Fatalf("unexpected keyword search result %v", list)
+	}
+}
+
+// The End.
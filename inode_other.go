@@ -0,0 +1,15 @@
+//go:build !unix
+
+package wpk
+
+import (
+	"io/fs"
+)
+
+// FileInode returns device and inode numbers of file, and number of
+// hard links to it. Returns false if it's not supported by system.
+func FileInode(fi fs.FileInfo) (dev, ino, nlink uint64, ok bool) {
+	return
+}
+
+// The End.
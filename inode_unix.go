@@ -0,0 +1,20 @@
+//go:build unix
+
+package wpk
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// FileInode returns device and inode numbers of file, and number of
+// hard links to it. Returns false if it's not supported by system.
+func FileInode(fi fs.FileInfo) (dev, ino, nlink uint64, ok bool) {
+	var st *syscall.Stat_t
+	if st, ok = fi.Sys().(*syscall.Stat_t); !ok {
+		return
+	}
+	return uint64(st.Dev), uint64(st.Ino), uint64(st.Nlink), true
+}
+
+// The End.
@@ -1,209 +1,91 @@
 package luawpk
 
 import (
-	"crypto/hmac"
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
-	"hash/crc32"
-	"hash/crc64"
 	"io"
-	"mime"
-	"path"
+	"strings"
 
-	"github.com/h2non/filetype"
 	"github.com/schwarzlichtbezirk/wpk"
+	lua "github.com/yuin/gopher-lua"
 )
 
-func adjustmime(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool) (wpk.TagsetRaw, error) {
-	var err error
-	var ok bool
-	if skip || ts.Has(wpk.TIDmime) {
-		return ts, err
-	}
-	var ext = wpk.ToLower(path.Ext(ts.Path()))
-	var ctype string
-	if ctype = mime.TypeByExtension(ext); ctype == "" {
-		if ctype, ok = MimeExt[ext]; !ok {
-			if _, err = r.Seek(0, io.SeekStart); err != nil {
-				return ts, err
-			}
-			if kind, err := filetype.MatchReader(r); err == nil && kind != filetype.Unknown {
-				ctype = kind.MIME.Value
-			} else {
-				ctype = "application/octet-stream"
-			}
+// packopts returns packing pipeline options with hashes marked at package,
+// file ID if it's needed, and tags given at Lua table.
+func (pkg *LuaPackage) packopts(tags *lua.LTable) wpk.PackOpts {
+	var opts = wpk.PackOpts{
+		Workers: 1,
+		Secret:  pkg.secret,
+		Xattrs:  pkg.xattrs,
+	}
+	for _, h := range []struct {
+		tid wpk.TID
+		on  bool
+	}{
+		{wpk.TIDcrc32c, pkg.crc32},
+		{wpk.TIDcrc64iso, pkg.crc64},
+		{wpk.TIDmd5, pkg.md5},
+		{wpk.TIDsha1, pkg.sha1},
+		{wpk.TIDsha224, pkg.sha224},
+		{wpk.TIDsha256, pkg.sha256},
+		{wpk.TIDsha384, pkg.sha384},
+		{wpk.TIDsha512, pkg.sha512},
+	} {
+		if h.on {
+			opts.Hashes = append(opts.Hashes, h.tid)
 		}
 	}
-	return ts.Put(wpk.TIDmime, wpk.StrTag(ctype)), nil
-}
-
-func adjustcrc32c(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDcrc32c) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
-	if _, err = io.Copy(h, r); err != nil {
-		return ts, err
-	}
-	return ts.Put(wpk.TIDcrc32c, h.Sum(nil)), nil
-}
-
-func adjustcrc64iso(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDcrc64iso) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var h = crc64.New(crc64.MakeTable(crc64.ISO))
-	if _, err = io.Copy(h, r); err != nil {
-		return ts, err
-	}
-	return ts.Put(wpk.TIDcrc64iso, h.Sum(nil)), nil
-}
-
-func adjustmd5(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool, secret []byte) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDmd5) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var mac = hmac.New(md5.New, secret)
-	if _, err = io.Copy(mac, r); err != nil {
-		return ts, err
-	}
-	return ts.Put(wpk.TIDmd5, mac.Sum(nil)), nil
-}
-
-func adjustsha1(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool, secret []byte) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDsha1) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var mac = hmac.New(sha1.New, secret)
-	if _, err = io.Copy(mac, r); err != nil {
-		return ts, err
-	}
-	return ts.Put(wpk.TIDsha1, mac.Sum(nil)), nil
-}
-
-func adjustsha224(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool, secret []byte) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDsha224) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var mac = hmac.New(sha256.New224, secret)
-	if _, err = io.Copy(mac, r); err != nil {
-		return ts, err
-	}
-	return ts.Put(wpk.TIDsha224, mac.Sum(nil)), nil
-}
-
-func adjustsha256(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool, secret []byte) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDsha256) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
+	if pkg.automime {
+		opts.Mime = DetectMime
 	}
-	var mac = hmac.New(sha256.New, secret)
-	if _, err = io.Copy(mac, r); err != nil {
-		return ts, err
+	opts.Hook = func(fkey string, ts wpk.TagsetRaw) (wpk.TagsetRaw, error) {
+		if pkg.autofid && (!ts.Has(wpk.TIDfid) || ts.Has(wpk.TIDhardlink)) {
+			pkg.fidcount++
+			ts = ts.Set(wpk.TIDfid, wpk.UintTag(pkg.fidcount))
+		}
+		return TableToTagset(tags, ts)
 	}
-	return ts.Put(wpk.TIDsha256, mac.Sum(nil)), nil
+	return opts
 }
 
-func adjustsha384(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool, secret []byte) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDsha384) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var mac = hmac.New(sha512.New384, secret)
-	if _, err = io.Copy(mac, r); err != nil {
-		return ts, err
+// packsource puts single file into package through packing pipeline.
+// On update the file is packed only if it's new or changed.
+func (pkg *LuaPackage) packsource(src wpk.PackSource, tags *lua.LTable) (err error) {
+	var w = pkg.wpt
+	if pkg.wpf != nil {
+		w = pkg.wpf
+	}
+	if pkg.updating {
+		pkg.touch(src.FKey)
+		var rep *wpk.UpdateReport
+		if rep, err = pkg.Update(w, []wpk.PackSource{src}, wpk.UpdateOpts{
+			PackOpts:  pkg.packopts(tags),
+			CheckHash: pkg.hashcmp,
+		}); err != nil {
+			return
+		}
+		if rep.Unchanged > 0 {
+			// tags given by script can be changed
+			var ts, _ = pkg.GetTagset(src.FKey)
+			if ts, err = TableToTagset(tags, wpk.CopyTagset(ts)); err != nil {
+				return
+			}
+			pkg.SetTagset(src.FKey, ts)
+		}
+		return
 	}
-	return ts.Put(wpk.TIDsha384, mac.Sum(nil)), nil
+	_, err = pkg.PackPipeline(w, []wpk.PackSource{src}, pkg.packopts(tags))
+	return
 }
 
-func adjustsha512(ts wpk.TagsetRaw, r io.ReadSeeker, skip bool, secret []byte) (wpk.TagsetRaw, error) {
-	var err error
-	if skip || ts.Has(wpk.TIDsha512) {
-		return ts, err
-	}
-	if _, err = r.Seek(0, io.SeekStart); err != nil {
-		return ts, err
-	}
-	var mac = hmac.New(sha512.New, secret)
-	if _, err = io.Copy(mac, r); err != nil {
-		return ts, err
-	}
-	return ts.Put(wpk.TIDsha512, mac.Sum(nil)), nil
+// touch marks the file as put on update, so it will not be deleted.
+func (pkg *LuaPackage) touch(fkey string) {
+	pkg.touched[wpk.ToSlash(fkey)] = wpk.Void{}
 }
 
-func (pkg *LuaPackage) adjusttagset(r io.ReadSeeker, ts wpk.TagsetRaw) (wpk.TagsetRaw, error) {
-	var err error
-
-	if pkg.autofid && !ts.Has(wpk.TIDfid) {
-		pkg.fidcount++
-		ts = ts.Put(wpk.TIDfid, wpk.UintTag(pkg.fidcount))
-	}
-
-	if ts, err = adjustmime(ts, r, !pkg.automime); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustcrc32c(ts, r, !pkg.crc32); err != nil {
-		return ts, err
+// opendata returns opener for packing pipeline with given data as content.
+func opendata(data string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(data)), nil
 	}
-
-	if ts, err = adjustcrc64iso(ts, r, !pkg.crc64); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustmd5(ts, r, !pkg.md5, pkg.secret); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustsha1(ts, r, !pkg.sha1, pkg.secret); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustsha224(ts, r, !pkg.sha224, pkg.secret); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustsha256(ts, r, !pkg.sha256, pkg.secret); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustsha384(ts, r, !pkg.sha384, pkg.secret); err != nil {
-		return ts, err
-	}
-
-	if ts, err = adjustsha512(ts, r, !pkg.sha512, pkg.secret); err != nil {
-		return ts, err
-	}
-
-	return ts, err
 }
 
 // The End.
@@ -1,6 +1,7 @@
 package luawpk
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -25,18 +26,27 @@ const PackMT = "wpk"
 // LuaPackage is "wpk" userdata structure.
 type LuaPackage struct {
 	wpk.Package
-	fidcount uint
-	autofid  bool
-	automime bool
-	secret   []byte
-	crc32    bool
-	crc64    bool
-	md5      bool
-	sha1     bool
-	sha224   bool
-	sha256   bool
-	sha384   bool
-	sha512   bool
+	fidcount  uint
+	autofid   bool
+	automime  bool
+	secret    []byte
+	crc32     bool
+	crc64     bool
+	md5       bool
+	sha1      bool
+	sha224    bool
+	sha256    bool
+	sha384    bool
+	sha512    bool
+	hashcmp   bool
+	ratio     float64
+	hardlinks bool
+	xattrs    bool
+
+	inodes map[[2]uint64]string // first put files with hard links to them
+
+	updating bool                // package is opened by update call
+	touched  map[string]wpk.Void // files put on update
 
 	pkgpath string
 	datpath string
@@ -192,16 +202,23 @@ var propertiesPack = []struct {
 	{"sha256", getsha256, setsha256},
 	{"sha384", getsha384, setsha384},
 	{"sha512", getsha512, setsha512},
+	{"hashcmp", gethashcmp, sethashcmp},
+	{"ratio", getratio, setratio},
+	{"hardlinks", gethardlinks, sethardlinks},
+	{"xattrs", getxattrs, setxattrs},
+	{"safeappend", getsafeappend, setsafeappend},
 }
 
 var methodsPack = map[string]lua.LGFunction{
 	"load":      wpkload,
 	"begin":     wpkbegin,
 	"append":    wpkappend,
+	"update":    wpkupdate,
 	"finalize":  wpkfinalize,
 	"flush":     wpkflush,
 	"sumsize":   wpksumsize,
 	"glob":      wpkglob,
+	"query":     wpkquery,
 	"hasfile":   wpkhasfile,
 	"filesize":  wpkfilesize,
 	"putdata":   wpkputdata,
@@ -455,6 +472,76 @@ func setsha512(ls *lua.LState) int {
 	return 0
 }
 
+func gethashcmp(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	ls.Push(lua.LBool(pkg.hashcmp))
+	return 1
+}
+
+func sethashcmp(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	var val = ls.CheckBool(2)
+
+	pkg.hashcmp = val
+	return 0
+}
+
+func getratio(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	ls.Push(lua.LNumber(pkg.ratio))
+	return 1
+}
+
+func setratio(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	var val = float64(ls.CheckNumber(2))
+
+	pkg.ratio = val
+	return 0
+}
+
+func gethardlinks(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	ls.Push(lua.LBool(pkg.hardlinks))
+	return 1
+}
+
+func sethardlinks(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	var val = ls.CheckBool(2)
+
+	pkg.hardlinks = val
+	return 0
+}
+
+func getxattrs(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	ls.Push(lua.LBool(pkg.xattrs))
+	return 1
+}
+
+func setxattrs(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	var val = ls.CheckBool(2)
+
+	pkg.xattrs = val
+	return 0
+}
+
+func getsafeappend(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	ls.Push(lua.LBool(pkg.SafeAppend))
+	return 1
+}
+
+func setsafeappend(ls *lua.LState) int {
+	var pkg = CheckPack(ls, 1)
+	var val = ls.CheckBool(2)
+
+	pkg.SafeAppend = val
+	return 0
+}
+
 // methods section
 
 func wpkload(ls *lua.LState) int {
@@ -552,6 +639,64 @@ func wpkappend(ls *lua.LState) int {
 	return 0
 }
 
+// Opens existing package to update, or begins new package if it does
+// not exist. On update files that are put with the same size and time,
+// or with the same hash if "hashcmp" is set, are not repacked, and
+// files that were not put until finalize are deleted from package.
+// update(pkgpath, datpath)
+//
+//	pkgpath - package file, or tags file of splitted package
+//	datpath - data file of splitted package, optional
+func wpkupdate(ls *lua.LState) int {
+	var err error
+	defer func() {
+		if err != nil {
+			ls.RaiseError(err.Error())
+		}
+	}()
+	var pkg = CheckPack(ls, 1)
+	var pkgpath = ls.CheckString(2)
+	var datpath = ls.OptString(3, "")
+
+	if pkg.wpt != nil {
+		err = ErrPackOpened
+		return 0
+	}
+	if ok, _ := wpk.FileExists(pkgpath); !ok {
+		return wpkbegin(ls)
+	}
+
+	// open package file
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		return 0
+	}
+	pkg.pkgpath, pkg.datpath = pkgpath, datpath
+	if pkg.wpt, err = os.OpenFile(pkgpath, os.O_RDWR, 0755); err != nil {
+		return 0
+	}
+	if datpath != "" {
+		if pkg.wpf, err = os.OpenFile(datpath, os.O_RDWR, 0755); err != nil {
+			pkg.wpt.Close()
+			pkg.wpt = nil
+			return 0
+		}
+	}
+	// starts to append files
+	if err = pkg.Append(pkg.wpt, pkg.wpf); err != nil {
+		return 0
+	}
+	pkg.updating, pkg.touched = true, map[string]wpk.Void{}
+	// file IDs continue the last one
+	pkg.Enum(func(fkey string, ts wpk.TagsetRaw) bool {
+		if fid, ok := ts.TagUint(wpk.TIDfid); ok && fid > pkg.fidcount {
+			pkg.fidcount = fid
+		}
+		return true
+	})
+
+	return 0
+}
+
 func wpkfinalize(ls *lua.LState) int {
 	var err error
 	defer func() {
@@ -566,6 +711,19 @@ func wpkfinalize(ls *lua.LState) int {
 		return 0
 	}
 
+	// delete files that were not put on update
+	if pkg.updating {
+		var list []string
+		pkg.Enum(func(fkey string, ts wpk.TagsetRaw) bool {
+			if _, ok := pkg.touched[fkey]; !ok {
+				list = append(list, fkey)
+			}
+			return true
+		})
+		for _, fkey := range list {
+			pkg.DelTagset(fkey)
+		}
+	}
 	// sync
 	if err = pkg.Sync(pkg.wpt, pkg.wpf); err != nil {
 		return 0
@@ -581,6 +739,17 @@ func wpkfinalize(ls *lua.LState) int {
 		}
 		pkg.wpf = nil
 	}
+	// compact package if it has too much unused space
+	if pkg.updating {
+		pkg.updating, pkg.touched = false, nil
+		var waste, total = pkg.Waste()
+		if pkg.ratio > 0 && total > 0 && float64(waste)/float64(total) > pkg.ratio {
+			if err = wpk.CompactFile(context.Background(), pkg.pkgpath, pkg.datpath, nil); err != nil {
+				return 0
+			}
+			err = pkg.OpenFile(pkg.pkgpath)
+		}
+	}
 
 	return 0
 }
@@ -650,6 +819,26 @@ func wpkglob(ls *lua.LState) int {
 	return n
 }
 
+func wpkquery(ls *lua.LState) int {
+	var err error
+	defer func() {
+		if err != nil {
+			ls.RaiseError(err.Error())
+		}
+	}()
+	var pkg = CheckPack(ls, 1)
+	var expr = ls.CheckString(2)
+
+	var list []string
+	if list, err = pkg.Query(expr); err != nil {
+		return 0
+	}
+	for _, fkey := range list {
+		ls.Push(lua.LString(fkey))
+	}
+	return len(list)
+}
+
 func wpkhasfile(ls *lua.LState) int {
 	var pkg = CheckPack(ls, 1)
 	var fkey = ls.CheckString(2)
@@ -699,27 +888,10 @@ func wpkputdata(ls *lua.LState) int {
 		return 0
 	}
 
-	var r = strings.NewReader(data)
-
-	var w = pkg.wpt
-	if pkg.wpf != nil {
-		w = pkg.wpf
-	}
-	var ts wpk.TagsetRaw
-	if ts, err = pkg.PackData(w, r, fkey); err != nil {
-		return 0
-	}
-
-	if ts, err = pkg.adjusttagset(r, ts); err != nil {
-		return 0
-	}
-
-	if ts, err = TableToTagset(tags, ts); err != nil {
-		return 0
-	}
-
-	pkg.SetupTagset(ts)
-
+	err = pkg.packsource(wpk.PackSource{
+		FKey: fkey,
+		Open: opendata(data),
+	}, tags)
 	return 0
 }
 
@@ -740,31 +912,28 @@ func wpkputfile(ls *lua.LState) int {
 		return 0
 	}
 
-	var file wpk.RFile
-	if file, err = os.Open(fpath); err != nil {
-		return 0
+	var src = wpk.PackSource{
+		FKey:  fkey,
+		FPath: fpath,
 	}
-	defer file.Close()
-
-	var w = pkg.wpt
-	if pkg.wpf != nil {
-		w = pkg.wpf
-	}
-	var ts wpk.TagsetRaw
-	if ts, err = pkg.PackFile(w, file, fkey); err != nil {
-		return 0
-	}
-
-	if ts, err = pkg.adjusttagset(file, ts); err != nil {
-		return 0
-	}
-
-	if ts, err = TableToTagset(tags, ts); err != nil {
-		return 0
+	if pkg.hardlinks {
+		var fi fs.FileInfo
+		if fi, err = os.Stat(fpath); err != nil {
+			return 0
+		}
+		if dev, ino, nlink, ok := wpk.FileInode(fi); ok && nlink > 1 {
+			var key = [2]uint64{dev, ino}
+			if orig, ok := pkg.inodes[key]; ok && pkg.HasTagset(orig) {
+				src.HardLink = orig
+			} else {
+				if pkg.inodes == nil {
+					pkg.inodes = map[[2]uint64]string{}
+				}
+				pkg.inodes[key] = fkey
+			}
+		}
 	}
-
-	pkg.SetupTagset(ts)
-
+	err = pkg.packsource(src, tags)
 	return 0
 }
 
@@ -784,6 +953,12 @@ func wpkrename(ls *lua.LState) int {
 	var fkey1 = ls.CheckString(2)
 	var fkey2 = ls.CheckString(3)
 
+	if pkg.updating {
+		pkg.touch(fkey2)
+		if pkg.HasTagset(fkey2) && pkg.HasTagset(fkey1) {
+			pkg.DelTagset(fkey2) // replace file renamed on previous build
+		}
+	}
 	if err = pkg.Rename(fkey1, fkey2); err != nil {
 		return 0
 	}
@@ -808,6 +983,21 @@ func wpkrenamedir(ls *lua.LState) int {
 	var dir2 = ls.CheckString(3)
 	var skipexist = ls.OptBool(4, true)
 
+	var targets []string
+	if pkg.updating {
+		// replace files renamed on previous build
+		var prefix1, prefix2 = dirprefix(dir1), dirprefix(dir2)
+		pkg.Enum(func(fkey string, ts wpk.TagsetRaw) bool {
+			if strings.HasPrefix(fkey, prefix1) {
+				targets = append(targets, prefix2+fkey[len(prefix1):])
+			}
+			return true
+		})
+		for _, fkey := range targets {
+			pkg.DelTagset(fkey)
+			pkg.touch(fkey)
+		}
+	}
 	var count int
 	if count, err = pkg.RenameDir(dir1, dir2, skipexist); err != nil {
 		return 0
@@ -816,6 +1006,15 @@ func wpkrenamedir(ls *lua.LState) int {
 	return 1
 }
 
+// dirprefix returns slash-terminated directory path.
+func dirprefix(dir string) string {
+	dir = wpk.ToSlash(dir)
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return dir
+}
+
 // Creates copy of tagset with new file name.
 // putalias(fkey1, fkey2)
 //
@@ -832,6 +1031,19 @@ func wpkputalias(ls *lua.LState) int {
 	var fkey1 = ls.CheckString(2)
 	var fkey2 = ls.CheckString(3)
 
+	if pkg.updating {
+		pkg.touch(fkey2)
+		if ts1, ok := pkg.GetTagset(fkey1); ok {
+			if ts2, ok := pkg.GetTagset(fkey2); ok {
+				var offset1, size1 = ts1.Pos()
+				var offset2, size2 = ts2.Pos()
+				if offset1 == offset2 && size1 == size2 {
+					return 0 // alias is actual
+				}
+				pkg.DelTagset(fkey2)
+			}
+		}
+	}
 	if err = pkg.PutAlias(fkey1, fkey2); err != nil {
 		return 0
 	}
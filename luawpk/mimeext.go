@@ -1,5 +1,14 @@
 package luawpk
 
+import (
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/h2non/filetype"
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
 const (
 	htmlcontent = "text/html; charset=utf-8"
 	csscontent  = "text/css; charset=utf-8"
@@ -136,4 +145,22 @@ var MimeExt = map[string]string{
 	".eot":   "application/vnd.ms-fontobject",
 }
 
+// DetectMime returns MIME type of file by its extension, or by content
+// if extension is unknown. Content is recognized by signatures of known
+// file types, and in other case as text or binary data. It's used as
+// packing option by all tools to put the same MIME types.
+func DetectMime(fkey string, data []byte) string {
+	var ext = wpk.ToLower(path.Ext(fkey))
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		return ctype
+	}
+	if ctype, ok := MimeExt[ext]; ok {
+		return ctype
+	}
+	if kind, err := filetype.Match(data); err == nil && kind != filetype.Unknown {
+		return kind.MIME.Value
+	}
+	return http.DetectContentType(data)
+}
+
 // The End.
@@ -11,100 +11,27 @@ import (
 	lua "github.com/yuin/gopher-lua"
 )
 
+// Types of tags values.
 const (
-	TTany = iota
-	TTbin
-	TTstr
-	TTbool
-	TTuint
-	TTnum
-	TTtime
+	TTany  = wpk.TTany
+	TTbin  = wpk.TTbin
+	TTstr  = wpk.TTstr
+	TTbool = wpk.TTbool
+	TTuint = wpk.TTuint
+	TTnum  = wpk.TTnum
+	TTtime = wpk.TTtime
 )
 
-const ISO8601 = "2006-01-02T15:04:05.999Z07:00"
+const ISO8601 = wpk.ISO8601
 
 // TidType helps to convert raw tags to Lua values.
-var TidType = map[wpk.TID]int{
-	wpk.TIDoffset: TTuint,
-	wpk.TIDsize:   TTuint,
-	wpk.TIDpath:   TTstr,
-	wpk.TIDfid:    TTuint,
-	wpk.TIDmtime:  TTtime,
-	wpk.TIDatime:  TTtime,
-	wpk.TIDctime:  TTtime,
-	wpk.TIDbtime:  TTtime,
-	wpk.TIDattr:   TTuint,
-	wpk.TIDmime:   TTstr,
-
-	wpk.TIDcrc32ieee: TTbin,
-	wpk.TIDcrc32c:    TTbin,
-	wpk.TIDcrc32k:    TTbin,
-	wpk.TIDcrc64iso:  TTbin,
-
-	wpk.TIDmd5:    TTbin,
-	wpk.TIDsha1:   TTbin,
-	wpk.TIDsha224: TTbin,
-	wpk.TIDsha256: TTbin,
-	wpk.TIDsha384: TTbin,
-	wpk.TIDsha512: TTbin,
-
-	wpk.TIDtmbjpeg:  TTbin,
-	wpk.TIDtmbwebp:  TTbin,
-	wpk.TIDlabel:    TTstr,
-	wpk.TIDlink:     TTstr,
-	wpk.TIDkeywords: TTstr,
-	wpk.TIDcategory: TTstr,
-	wpk.TIDversion:  TTstr,
-	wpk.TIDauthor:   TTstr,
-	wpk.TIDcomment:  TTstr,
-}
+var TidType = wpk.TidType
 
 // NameTid helps convert Lua-table string keys to associated TID values.
-var NameTid = map[string]wpk.TID{
-	"offset": wpk.TIDoffset,
-	"size":   wpk.TIDsize,
-	"path":   wpk.TIDpath,
-	"fid":    wpk.TIDfid,
-	"mtime":  wpk.TIDmtime,
-	"atime":  wpk.TIDatime,
-	"ctime":  wpk.TIDctime,
-	"btime":  wpk.TIDbtime,
-	"attr":   wpk.TIDattr,
-	"mime":   wpk.TIDmime,
-
-	"crc32":     wpk.TIDcrc32c,
-	"crc32ieee": wpk.TIDcrc32ieee,
-	"crc32c":    wpk.TIDcrc32c,
-	"crc32k":    wpk.TIDcrc32k,
-	"crc64":     wpk.TIDcrc64iso,
-	"crc64iso":  wpk.TIDcrc64iso,
-
-	"md5":    wpk.TIDmd5,
-	"sha1":   wpk.TIDsha1,
-	"sha224": wpk.TIDsha224,
-	"sha256": wpk.TIDsha256,
-	"sha384": wpk.TIDsha384,
-	"sha512": wpk.TIDsha512,
-
-	"tmbjpeg":  wpk.TIDtmbjpeg,
-	"tmbwebp":  wpk.TIDtmbwebp,
-	"label":    wpk.TIDlabel,
-	"link":     wpk.TIDlink,
-	"keywords": wpk.TIDkeywords,
-	"category": wpk.TIDcategory,
-	"version":  wpk.TIDversion,
-	"author":   wpk.TIDauthor,
-	"comment":  wpk.TIDcomment,
-}
+var NameTid = wpk.NameTid
 
 // TidName helps format Lua-tables with string keys associated to TID values.
-var TidName = func() map[wpk.TID]string {
-	var tn = map[wpk.TID]string{}
-	for name, tid := range NameTid {
-		tn[tid] = name
-	}
-	return tn
-}()
+var TidName = wpk.TidName
 
 // ErrKeyUndef represents error on tag identifiers string presentation.
 type ErrKeyUndef struct {
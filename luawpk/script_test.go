@@ -125,4 +125,54 @@ func TestSplitted(t *testing.T) {
 	CheckPackage(t, wptname, wpfname)
 }
 
+// Test incremental update of package by script.
+func TestUpdate(t *testing.T) {
+	var wpkname = wpk.TempPath("update.wpk")
+	os.Remove(wpkname)
+	defer os.Remove(wpkname)
+
+	var offsets = map[string]uint{}
+	for i := 0; i < 2; i++ {
+		if err := lw.RunLuaVM(scrdir + "update.lua"); err != nil {
+			t.Fatal(err)
+		}
+		CheckPackage(t, wpkname, "")
+
+		var pkg = wpk.NewPackage()
+		if err := pkg.OpenFile(wpkname); err != nil {
+			t.Fatal(err)
+		}
+		if pkg.TagsetNum() != 5 {
+			t.Fatalf("expected 5 files in package, got %d", pkg.TagsetNum())
+		}
+		pkg.Enum(func(fkey string, ts wpk.TagsetRaw) bool {
+			var offset, _ = ts.Pos()
+			if i > 0 && offsets[fkey] != offset {
+				t.Errorf("unchanged file '%s' was packed again", fkey)
+			}
+			offsets[fkey] = offset
+			return true
+		})
+	}
+}
+
+// Test MIME types detection by extension and by content.
+func TestDetectMime(t *testing.T) {
+	var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, c := range []struct {
+		fkey  string
+		data  []byte
+		ctype string
+	}{
+		{"web/icon.woff2", nil, lw.MimeExt[".woff2"]},
+		{"IMAGE.NOEXT", png, "image/png"},
+		{"readme", []byte("plain text"), "text/plain; charset=utf-8"},
+		{"data.bin.unknown", []byte{0, 1, 2, 3}, "application/octet-stream"},
+	} {
+		if ctype := lw.DetectMime(c.fkey, c.data); ctype != c.ctype {
+			t.Errorf("MIME type of '%s' is '%s', expected '%s'", c.fkey, ctype, c.ctype)
+		}
+	}
+}
+
 // The End.
@@ -0,0 +1,206 @@
+package wpk
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// MergePolicy determines how to resolve files with the same keys
+// met in different packages on merge.
+type MergePolicy int
+
+const (
+	MergeFirst  MergePolicy = iota // keep the file met at first, skip others
+	MergeLast                      // the file met at last replaces previous
+	MergeError                     // break the merge with error
+	MergeRename                    // put the file with suffix added to the name
+)
+
+// MergePolicies is the set of merge policies by their names
+// used at command line tools.
+var MergePolicies = map[string]MergePolicy{
+	"first":  MergeFirst,
+	"last":   MergeLast,
+	"error":  MergeError,
+	"rename": MergeRename,
+}
+
+// InfoRule determines how to merge the tag of package info tagsets.
+type InfoRule int
+
+const (
+	InfoFirst InfoRule = iota // keep the value met at first
+	InfoLast                  // the value met at last replaces previous
+	InfoJoin                  // join unique string values with separator
+	InfoDrop                  // exclude the tag from result package info
+)
+
+// Default values for merge options.
+const (
+	MergeSuffix  = "-dup"
+	MergeInfoSep = ";"
+)
+
+// MergeOpts is the set of rules for packages merge.
+type MergeOpts struct {
+	Policy      MergePolicy      // policy for duplicate keys
+	Suffix      string           // suffix for renamed duplicates, MergeSuffix if empty
+	InfoRules   map[TID]InfoRule // rules for package info tags
+	InfoDefault InfoRule         // rule for package info tags absent at InfoRules
+	InfoSep     string           // separator for joined values, MergeInfoSep if empty
+}
+
+// DupName returns file key with inserted suffix and number
+// before the file extension. Number is skipped if it less than 2.
+func DupName(fkey, suffix string, n int) string {
+	var ext = path.Ext(fkey)
+	var name = fkey[:len(fkey)-len(ext)]
+	if n > 1 {
+		return name + suffix + strconv.Itoa(n) + ext
+	}
+	return name + suffix + ext
+}
+
+// MergeInfo merges package info tagset of given source into the
+// destination tagset by the rules of options, and returns new tagset.
+func (opts *MergeOpts) MergeInfo(dst, src TagsetRaw) TagsetRaw {
+	var sep = opts.InfoSep
+	if sep == "" {
+		sep = MergeInfoSep
+	}
+	dst = CopyTagset(dst)
+	var tsi = src.Iterator()
+	for tsi.Next() {
+		var tid, tag = tsi.TID(), tsi.Tag()
+		var rule, ok = opts.InfoRules[tid]
+		if !ok {
+			rule = opts.InfoDefault
+		}
+		switch rule {
+		case InfoFirst:
+			dst = dst.Add(tid, tag)
+		case InfoLast:
+			dst = dst.Set(tid, tag)
+		case InfoJoin:
+			var str, _ = tag.TagStr()
+			if old, ok := dst.TagStr(tid); ok && old != "" {
+				var has bool
+				for _, v := range strings.Split(old, sep) {
+					if v == str {
+						has = true
+						break
+					}
+				}
+				if !has {
+					dst = dst.Set(tid, StrTag(old+sep+str))
+				}
+			} else {
+				dst = dst.Set(tid, tag)
+			}
+		case InfoDrop:
+			dst = dst.Del(tid)
+		}
+	}
+	return dst
+}
+
+// Merge copies files of given packages into this package through the taggers
+// of sources. Files data with the same offset in the source package are copied
+// only once, so aliases are remain aliases. Package info tagsets are merged
+// by options rules. Package should be opened for write by Begin or Append,
+// and synchronized by Sync after. To flatten a union, pass its list.
+func (pkg *Package) Merge(w io.WriteSeeker, list []*Package, opts MergeOpts) (err error) {
+	type mergeitem struct {
+		src *Package
+		ts  TagsetRaw
+	}
+	var suffix = opts.Suffix
+	if suffix == "" {
+		suffix = MergeSuffix
+	}
+
+	// make merge plan before any data writing
+	var plan SeqMap[string, mergeitem]
+	plan.Init(0)
+	for _, src := range list {
+		src.Enum(func(fkey string, ts TagsetRaw) bool {
+			if plan.Has(fkey) || pkg.HasTagset(fkey) {
+				switch opts.Policy {
+				case MergeFirst:
+					return true
+				case MergeLast:
+					// plan entry will be replaced
+				case MergeError:
+					err = &fs.PathError{Op: "merge", Path: fkey, Err: fs.ErrExist}
+					return false
+				case MergeRename:
+					var newkey string
+					for n := 1; ; n++ {
+						newkey = DupName(fkey, suffix, n)
+						if !plan.Has(newkey) && !pkg.HasTagset(newkey) {
+							break
+						}
+					}
+					fkey = newkey
+				}
+			}
+			plan.Poke(fkey, mergeitem{src, ts})
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// merge package info
+	var info = pkg.GetInfo()
+	for _, src := range list {
+		info = opts.MergeInfo(info, src.GetInfo())
+	}
+	pkg.SetInfo(info)
+
+	// copy the data
+	type blockkey struct {
+		ftt          *FTT
+		offset, size uint
+	}
+	var blocks = map[blockkey]uint{}
+	plan.Range(func(fkey string, item mergeitem) bool {
+		var offset, size = item.ts.Pos()
+		var bk = blockkey{item.src.FTT, offset, size}
+		pkg.DelTagset(fkey) // for the case of replacing by the last
+
+		var ts TagsetRaw
+		if dstoff, ok := blocks[bk]; ok {
+			ts = pkg.BaseTagset(dstoff, size, fkey)
+		} else {
+			var f RFile
+			if f, err = item.src.Tagger.OpenTagset(item.ts); err != nil {
+				return false
+			}
+			ts, err = pkg.PackData(w, f, fkey)
+			f.Close()
+			if err != nil {
+				return false
+			}
+			blocks[bk], _ = ts.Pos()
+		}
+
+		var tsi = item.ts.Iterator()
+		for tsi.Next() {
+			switch tsi.TID() {
+			case TIDoffset, TIDsize, TIDpath:
+				continue
+			}
+			ts = ts.Put(tsi.TID(), tsi.Tag())
+		}
+		pkg.SetTagset(fkey, ts)
+		return true
+	})
+	return
+}
+
+// The End.
@@ -0,0 +1,179 @@
+package wpk_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+var testmerge = wpk.TempPath("testmerge.wpk")
+
+// Test merge of two packages with each duplicates policy.
+func TestMerge(t *testing.T) {
+	PackFiles(t, testpack1, []string{
+		"bounty.jpg",
+		"img1/claustral.jpg",
+		"img2/marble.jpg",
+	})
+	PackFiles(t, testpack2, []string{
+		"bounty.jpg",
+		"img1/Qarataşlar.jpg",
+		"img2/Uzuncı.jpg",
+	})
+
+	defer os.Remove(testpack1)
+	defer os.Remove(testpack2)
+	defer os.Remove(testmerge)
+
+	var err error
+	var open = func(fpath string) *wpk.Package {
+		var pkg = wpk.NewPackage()
+		if err = pkg.OpenFile(fpath); err != nil {
+			t.Fatal(err)
+		}
+		if pkg.Tagger, err = bulk.MakeTagger(fpath); err != nil {
+			t.Fatal(err)
+		}
+		return pkg
+	}
+
+	var pack1 = open(testpack1)
+	defer pack1.Close()
+	var pack2 = open(testpack2)
+	defer pack2.Close()
+
+	// prepare sources
+	if err = pack1.PutAlias("img1/claustral.jpg", "alias.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	var ts, _ = pack2.GetTagset("bounty.jpg")
+	pack2.SetTagset("bounty.jpg", wpk.CopyTagset(ts).Put(wpk.TIDlabel, wpk.StrTag("second")))
+	pack1.SetInfo(wpk.TagsetRaw{}.
+		Put(wpk.TIDlabel, wpk.StrTag("pack1")).
+		Put(wpk.TIDkeywords, wpk.StrTag("one")))
+	pack2.SetInfo(wpk.TagsetRaw{}.
+		Put(wpk.TIDlabel, wpk.StrTag("pack2")).
+		Put(wpk.TIDkeywords, wpk.StrTag("two")))
+
+	var merge = func(opts wpk.MergeOpts) (*wpk.Package, error) {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(testmerge, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Merge(fwpk, []*wpk.Package{pack1, pack2}, opts); err != nil {
+			return nil, err
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		return open(testmerge), nil
+	}
+
+	var check = func(pkg *wpk.Package, fkey, label string) {
+		var ts, ok = pkg.GetTagset(fkey)
+		if !ok {
+			t.Fatalf("file '%s' not found in merged package", fkey)
+		}
+		if str, _ := ts.TagStr(wpk.TIDlabel); str != label {
+			t.Fatalf("file '%s' expected with label '%s', got '%s'", fkey, label, str)
+		}
+		var orig, data []byte
+		if orig, err = os.ReadFile(mediadir + "bounty.jpg"); err != nil {
+			t.Fatal(err)
+		}
+		if data, err = pkg.ReadFile(fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(orig, data) {
+			t.Fatalf("content of '%s' is not equal to original", fkey)
+		}
+	}
+
+	t.Run("first", func(t *testing.T) {
+		var pkg, err = merge(wpk.MergeOpts{
+			Policy: wpk.MergeFirst,
+			InfoRules: map[wpk.TID]wpk.InfoRule{
+				wpk.TIDkeywords: wpk.InfoJoin,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pkg.Close()
+		if n := pkg.TagsetNum(); n != 6 {
+			t.Fatalf("expected 6 files in merged package, got %d", n)
+		}
+		check(pkg, "bounty.jpg", "")
+		var ts1, _ = pkg.GetTagset("img1/claustral.jpg")
+		var ts2, _ = pkg.GetTagset("alias.jpg")
+		var off1, _ = ts1.Pos()
+		var off2, _ = ts2.Pos()
+		if off1 != off2 {
+			t.Fatal("alias does not refers to the same data")
+		}
+		if str, _ := pkg.GetInfo().TagStr(wpk.TIDlabel); str != "pack1" {
+			t.Fatalf("expected label 'pack1' in package info, got '%s'", str)
+		}
+		if str, _ := pkg.GetInfo().TagStr(wpk.TIDkeywords); str != "one;two" {
+			t.Fatalf("expected keywords 'one;two' in package info, got '%s'", str)
+		}
+	})
+
+	t.Run("last", func(t *testing.T) {
+		var pkg, err = merge(wpk.MergeOpts{
+			Policy:      wpk.MergeLast,
+			InfoDefault: wpk.InfoLast,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pkg.Close()
+		if n := pkg.TagsetNum(); n != 6 {
+			t.Fatalf("expected 6 files in merged package, got %d", n)
+		}
+		check(pkg, "bounty.jpg", "second")
+		if str, _ := pkg.GetInfo().TagStr(wpk.TIDlabel); str != "pack2" {
+			t.Fatalf("expected label 'pack2' in package info, got '%s'", str)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		if _, err := merge(wpk.MergeOpts{
+			Policy: wpk.MergeError,
+		}); !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("expected duplicate error, got %v", err)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		var pkg, err = merge(wpk.MergeOpts{
+			Policy:      wpk.MergeRename,
+			InfoDefault: wpk.InfoDrop,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pkg.Close()
+		if n := pkg.TagsetNum(); n != 7 {
+			t.Fatalf("expected 7 files in merged package, got %d", n)
+		}
+		check(pkg, "bounty.jpg", "")
+		check(pkg, "bounty-dup.jpg", "second")
+		if pkg.GetInfo().Num() != 0 {
+			t.Fatal("package info expected to be empty")
+		}
+	})
+}
+
+// The End.
@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package mmap
+
+import "golang.org/x/sys/unix"
+
+// adviseseq hints the kernel that mapped memory will be read sequentially,
+// so pages can be read ahead aggressively and freed soon after reading.
+func adviseseq(b []byte) {
+	_ = unix.Madvise(b, unix.MADV_SEQUENTIAL)
+}
+
+// The End.
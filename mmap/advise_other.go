@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package mmap
+
+// adviseseq does nothing on platforms without madvise call.
+func adviseseq(b []byte) {}
+
+// The End.
@@ -2,8 +2,11 @@ package mmap
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"io/fs"
 	"os"
+	"sync"
 
 	mm "github.com/edsrzf/mmap-go"
 	"github.com/schwarzlichtbezirk/wpk"
@@ -14,6 +17,10 @@ import (
 // os.Getpagesize() returns incorrect value on Windows.
 const pagesize = 64 * 1024
 
+var (
+	ErrBusy = errors.New("tagger has opened views of mapped memory")
+)
+
 // MappedFile structure gives access to nested into package file by memory mapping.
 // wpk.RFile interface implementation.
 type MappedFile struct {
@@ -49,15 +56,64 @@ func (f *MappedFile) Stat() (fs.FileInfo, error) {
 	return f.tags, nil
 }
 
+// Bytes returns mapped file content without copying.
+// Slice is valid only until the file is closed.
+func (f *MappedFile) Bytes() []byte {
+	return f.region
+}
+
 // Close unmaps memory and closes mapped memory handle.
 func (f *MappedFile) Close() error {
 	return f.Unmap()
 }
 
+// ViewFile structure gives access to nested into package file as to view
+// of memory shared mapping of package data. Opening and closing of view
+// does not make any system calls. wpk.RFile interface implementation.
+type ViewFile struct {
+	wpk.PkgReader
+	tags   wpk.TagsetRaw // has fs.FileInfo interface
+	region []byte
+	tgr    *Tagger
+	closed bool
+}
+
+// Stat is for fs.File interface compatibility.
+func (f *ViewFile) Stat() (fs.FileInfo, error) {
+	return f.tags, nil
+}
+
+// Bytes returns file content at shared mapping without copying.
+// Tagger can not be closed while view is opened, so slice is valid
+// until the view is closed. Slice content must not be modified.
+func (f *ViewFile) Bytes() []byte {
+	return f.region
+}
+
+// Close releases the view at tagger, shared mapping remains.
+func (f *ViewFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.tgr.release()
+	return nil
+}
+
 // Tagger is object to get access to package nested files
-// by memory mapping of wpk-file.
+// by memory mapping of wpk-file. By default each opened file
+// maps its own region. In shared mode the data section of package
+// is mapped once, and opened files are views into this mapping.
 type Tagger struct {
 	fwpk *os.File // open package file descriptor
+
+	mmap   mm.MMap // shared mapping, nil if each file maps its own region
+	base   int64   // offset of shared mapping at package file
+	shared bool
+	window int // files larger than window are mapped by sliding window, zero disables
+	refs   int // number of opened views
+	closed bool
+	mux    sync.Mutex
 }
 
 // MakeTagger creates Tagger object to get access to package nested files.
@@ -70,15 +126,127 @@ func MakeTagger(fpath string) (wpk.Tagger, error) {
 	return &tgr, nil
 }
 
+// MakeWindowTagger creates Tagger object that maps files larger than
+// given window size by sliding window of this size, so huge files
+// can be streamed with low memory usage. Smaller files are mapped entirely.
+func MakeWindowTagger(fpath string, window int) (wpk.Tagger, error) {
+	var err error
+	var tgr = Tagger{window: window}
+	if tgr.window <= 0 {
+		tgr.window = DefWindowSize
+	}
+	if tgr.fwpk, err = os.Open(fpath); err != nil {
+		return nil, err
+	}
+	return &tgr, nil
+}
+
+// MakeSharedTagger creates Tagger object that maps the data section
+// of package once. If given file is package with header, only its data
+// section is mapped, otherwise file is mapped entirely, as data file
+// of splitted package.
+func MakeSharedTagger(fpath string) (wpk.Tagger, error) {
+	var err error
+	var tgr = Tagger{shared: true}
+	if tgr.fwpk, err = os.Open(fpath); err != nil {
+		return nil, err
+	}
+	if err = tgr.mapdata(); err != nil {
+		tgr.fwpk.Close()
+		return nil, err
+	}
+	return &tgr, nil
+}
+
+// mapdata maps data section of package file.
+func (tgr *Tagger) mapdata() (err error) {
+	var fi fs.FileInfo
+	if fi, err = tgr.fwpk.Stat(); err != nil {
+		return
+	}
+	var from, to = int64(0), fi.Size()
+	var hdr wpk.Header
+	if _, err = hdr.ReadFrom(tgr.fwpk); err == nil && hdr.IsReady() == nil && hdr.DataOffset() > 0 {
+		from, to = int64(hdr.DataOffset()), int64(hdr.DataOffset()+hdr.DataSize())
+		if to > fi.Size() {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	err = nil
+	tgr.base = from - from%pagesize
+	if to > tgr.base {
+		if tgr.mmap, err = mm.MapRegion(tgr.fwpk, int(to-tgr.base), mm.RDONLY, 0, tgr.base); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Refs returns number of opened views of shared mapping.
+func (tgr *Tagger) Refs() int {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	return tgr.refs
+}
+
+// release decrements the counter of opened views.
+func (tgr *Tagger) release() {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	tgr.refs--
+}
+
 // OpenTagset creates file object to give access to nested into package file by given tagset.
+// In shared mode files placed outside of mapped data section are mapped by their own regions.
 func (tgr *Tagger) OpenTagset(ts wpk.TagsetRaw) (wpk.RFile, error) {
-	return NewMappedFile(tgr.fwpk, ts)
+	if !tgr.shared {
+		if tgr.window > 0 && ts.Size() > int64(tgr.window) {
+			return NewWindowFile(tgr.fwpk, ts, tgr.window)
+		}
+		return NewMappedFile(tgr.fwpk, ts)
+	}
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	if tgr.closed {
+		return nil, fs.ErrClosed
+	}
+	var offset, size = ts.Pos()
+	var from = int64(offset) - tgr.base
+	var to = from + int64(size)
+	if from < 0 || to > int64(len(tgr.mmap)) {
+		return NewMappedFile(tgr.fwpk, ts)
+	}
+	tgr.refs++
+	var region = tgr.mmap[from:to:to]
+	return &ViewFile{
+		PkgReader: bytes.NewReader(region),
+		tags:      ts,
+		region:    region,
+		tgr:       tgr,
+	}, nil
 }
 
-// Close file handle. This function must be called only for root object,
-// not subdirectories. It has no effect otherwise.
+// Close file handle. In shared mode it fails with ErrBusy if there
+// are opened views, and unmaps the memory otherwise. This function
+// must be called only for root object, not subdirectories.
+// It has no effect otherwise.
 // io.Closer implementation.
 func (tgr *Tagger) Close() error {
+	tgr.mux.Lock()
+	defer tgr.mux.Unlock()
+	if tgr.closed {
+		return fs.ErrClosed
+	}
+	if tgr.refs > 0 {
+		return ErrBusy
+	}
+	tgr.closed = true
+	if tgr.mmap != nil {
+		if err := tgr.mmap.Unmap(); err != nil {
+			return err
+		}
+		tgr.mmap = nil
+	}
 	return tgr.fwpk.Close()
 }
 
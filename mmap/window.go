@@ -0,0 +1,167 @@
+package mmap
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	mm "github.com/edsrzf/mmap-go"
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// DefWindowSize is default size of sliding window for large files mapping.
+const DefWindowSize = 16 * 1024 * 1024
+
+// WindowFile structure gives access to nested into package file by memory
+// mapping of sliding window. Only the window of fixed size is mapped at
+// once, and it's remapped when reading goes outside of it. Mapped memory
+// is advised for sequential reading, so streaming of huge files keeps
+// low memory usage. wpk.RFile interface implementation.
+type WindowFile struct {
+	fwpk   *os.File
+	tags   wpk.TagsetRaw // has fs.FileInfo interface
+	offset int64         // file offset at package
+	size   int64         // file size
+	wsize  int64         // window size, multiple of page size
+	pos    int64         // current reading position
+
+	win     mm.MMap // mapped window
+	winfrom int64   // offset of window at package, aligned to page size
+	mux     sync.Mutex
+}
+
+// NewWindowFile creates file that maps nested to package file by sliding
+// window of given size. Window size is rounded up to page size.
+func NewWindowFile(fwpk *os.File, ts wpk.TagsetRaw, window int) (f *WindowFile, err error) {
+	var offset, size = ts.Pos()
+	var wsize = int64(window)
+	if wsize <= 0 {
+		wsize = DefWindowSize
+	}
+	wsize = (wsize + pagesize - 1) / pagesize * pagesize
+	f = &WindowFile{
+		fwpk:   fwpk,
+		tags:   ts,
+		offset: int64(offset),
+		size:   int64(size),
+		wsize:  wsize,
+	}
+	return
+}
+
+// Stat is for fs.File interface compatibility.
+func (f *WindowFile) Stat() (fs.FileInfo, error) {
+	return f.tags, nil
+}
+
+// remap maps the window that contains given position of file.
+func (f *WindowFile) remap(pos int64) (err error) {
+	if f.win != nil {
+		if err = f.win.Unmap(); err != nil {
+			return
+		}
+		f.win = nil
+	}
+	var abs = f.offset + pos
+	var from = abs - abs%pagesize
+	var to = from + f.wsize
+	if end := f.offset + f.size; to > end {
+		to = end
+	}
+	if f.win, err = mm.MapRegion(f.fwpk, int(to-from), mm.RDONLY, 0, from); err != nil {
+		return
+	}
+	f.winfrom = from
+	adviseseq(f.win)
+	return
+}
+
+// ReadAt reads len(b) bytes from the file starting at byte offset off,
+// and remaps the window if it's needed.
+// io.ReaderAt implementation.
+func (f *WindowFile) ReadAt(b []byte, off int64) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.readat(b, off)
+}
+
+func (f *WindowFile) readat(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, wpk.ErrOutOff
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	for n < len(b) && off < f.size {
+		var abs = f.offset + off
+		if f.win == nil || abs < f.winfrom || abs >= f.winfrom+int64(len(f.win)) {
+			if err = f.remap(off); err != nil {
+				return
+			}
+		}
+		var k = copy(b[n:], f.win[abs-f.winfrom:])
+		if rest := f.size - off; int64(k) > rest {
+			k = int(rest)
+		}
+		n += k
+		off += int64(k)
+	}
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
+// Read reads up to len(b) bytes from the file at current position.
+// io.Reader implementation.
+func (f *WindowFile) Read(b []byte) (n int, err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if rest := f.size - f.pos; int64(len(b)) > rest {
+		b = b[:rest]
+	}
+	n, err = f.readat(b, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+// Seek sets the position for next Read. Window is remapped on reading.
+// io.Seeker implementation.
+func (f *WindowFile) Seek(offset int64, whence int) (int64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, wpk.ErrOutOff
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close unmaps the window.
+func (f *WindowFile) Close() (err error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.win != nil {
+		err = f.win.Unmap()
+		f.win = nil
+	}
+	return
+}
+
+// The End.
@@ -0,0 +1,513 @@
+package wpk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"gopkg.in/djherbis/times.v1"
+)
+
+// PackSource is the source of file for packing pipeline.
+type PackSource struct {
+	FKey  string                        // file name in package
+	FPath string                        // path to file at file system, used if Open is nil
+	Open  func() (io.ReadCloser, error) // opens file content, can return fs.File to get file times
+	Tags  TagsetRaw                     // tags to put into file tagset, replaces computed tags
+	Size  int64                         // expected file size for progress totals, can be zero
+
+	// HardLink is the name of file in package, or of one of previous
+	// sources, that has the same content. If it's present at writing,
+	// data is not copied, and file is put as alias to it with TIDhardlink
+	// tag. Otherwise file is packed as usual.
+	HardLink string
+}
+
+// PackOpts is the set of options for packing pipeline.
+type PackOpts struct {
+	Workers int    // number of workers, number of CPUs if it's zero
+	Hashes  []TID  // hashes to compute for each file, see HashTIDs
+	Secret  []byte // private key for MD5 and SHA hashes
+	Xattrs  bool   // put extended attributes of files at file system into TIDxattr tag
+
+	// BufferSize is the limit of file size to be read by workers ahead of
+	// writing, PackBufferSize if it's zero. Larger files are streamed into
+	// package on writing, and their hashes are computed during writing.
+	BufferSize int64
+
+	// Mime returns MIME type of file with given name and content,
+	// or empty string if it's unknown. It's called on worker, and
+	// gets only first 512 bytes of content for streamed files.
+	Mime func(fkey string, data []byte) string
+	// Transform can change file content, such as compression, after
+	// hashes calculation, and returns tags to put. It's called on worker
+	// with whole content of file, so files are not streamed in this case.
+	Transform func(fkey string, data []byte) ([]byte, TagsetRaw, error)
+	// Hook is called after file writing in order of sources
+	// and can adjust final file tagset.
+	Hook func(fkey string, ts TagsetRaw) (TagsetRaw, error)
+	// Progress is called after each written file.
+	Progress ProgressFunc
+}
+
+// PackBufferSize is default limit of file size to be read by workers
+// ahead of writing. Larger files are streamed into package on writing.
+const PackBufferSize = 4 << 20
+
+// packres is the prepared by worker file content.
+type packres struct {
+	data []byte
+	r    io.ReadCloser // content to stream on writing, if file is too large to be buffered
+	ts   TagsetRaw
+	fi   fs.FileInfo
+	err  error
+	link bool // content was not read, file is expected to be alias
+}
+
+// streamer is reader of file content with already read prefix.
+type streamer struct {
+	io.Reader
+	io.Closer
+}
+
+// prepare reads content of file with size up to buffer size, computes all
+// hashes, detects MIME type and transforms the content. Larger files are
+// left opened to be streamed on writing, whole content is read only if
+// Transform is given.
+func (src *PackSource) prepare(ctx context.Context, opts *PackOpts) (res packres) {
+	if src.HardLink != "" && src.Open == nil {
+		res.fi, res.err = os.Stat(src.FPath)
+		res.link = true
+		return
+	}
+
+	if opts.Xattrs && src.Open == nil {
+		var list []Xattr
+		if list, res.err = ReadXattrs(src.FPath); res.err != nil {
+			return
+		}
+		if tag := XattrTag(list); len(tag) > math.MaxUint16 {
+			res.err = fmt.Errorf("%w: %s", ErrXattrSize, src.FPath)
+			return
+		} else if tag != nil {
+			res.ts = res.ts.Put(TIDxattr, tag)
+		}
+	}
+
+	var r io.ReadCloser
+	if src.Open != nil {
+		r, res.err = src.Open()
+	} else {
+		r, res.err = os.Open(src.FPath)
+	}
+	if res.err != nil {
+		return
+	}
+	defer func() {
+		if res.r == nil {
+			r.Close()
+		}
+	}()
+
+	var limit = opts.BufferSize
+	if limit <= 0 {
+		limit = PackBufferSize
+	}
+	if opts.Transform != nil {
+		limit = -1 // whole content is needed
+	}
+	var buf bytes.Buffer
+	if f, ok := r.(fs.File); ok {
+		if res.fi, res.err = f.Stat(); res.err != nil {
+			return
+		}
+		if limit >= 0 && res.fi.Size() > limit {
+			res.r = r
+			return
+		}
+		buf.Grow(int(res.fi.Size()))
+	}
+	if limit >= 0 {
+		var n int64
+		if n, res.err = io.Copy(&buf, io.LimitReader(ctxreader{ctx, r}, limit+1)); res.err != nil {
+			return
+		}
+		if n > limit {
+			res.r = streamer{io.MultiReader(&buf, r), r}
+			return
+		}
+	} else if _, res.err = io.Copy(&buf, ctxreader{ctx, r}); res.err != nil {
+		return
+	}
+	res.data = buf.Bytes()
+
+	for _, tid := range opts.Hashes {
+		var h = NewHash(tid, opts.Secret)
+		h.Write(res.data)
+		res.ts = res.ts.Put(tid, h.Sum(nil))
+	}
+	if opts.Mime != nil && !src.Tags.Has(TIDmime) {
+		if ctype := opts.Mime(src.FKey, res.data); ctype != "" {
+			res.ts = res.ts.Put(TIDmime, StrTag(ctype))
+		}
+	}
+	if opts.Transform != nil {
+		var ts TagsetRaw
+		if res.data, ts, res.err = opts.Transform(src.FKey, res.data); res.err != nil {
+			return
+		}
+		res.ts = append(res.ts, ts...)
+	}
+	return
+}
+
+// packstream writes content of large file into package on writing,
+// and computes hashes and MIME type during writing.
+func (pkg *Package) packstream(ctx context.Context, w io.WriteSeeker, src *PackSource, r io.Reader, opts *PackOpts) (ts TagsetRaw, err error) {
+	var head []byte
+	var mime = opts.Mime != nil && !src.Tags.Has(TIDmime)
+	if mime {
+		head = make([]byte, 512)
+		var n int
+		if n, err = io.ReadFull(r, head); err != nil && err != io.ErrUnexpectedEOF {
+			return
+		}
+		head = head[:n]
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+	var hs = make([]hash.Hash, len(opts.Hashes))
+	var ws = make([]io.Writer, len(opts.Hashes))
+	for i, tid := range opts.Hashes {
+		hs[i] = NewHash(tid, opts.Secret)
+		ws[i] = hs[i]
+	}
+	if ts, err = pkg.PackData(w, io.TeeReader(ctxreader{ctx, r}, io.MultiWriter(ws...)), src.FKey); err != nil {
+		return
+	}
+	for i, tid := range opts.Hashes {
+		ts = ts.Put(tid, hs[i].Sum(nil))
+	}
+	if mime {
+		if ctype := opts.Mime(src.FKey, head); ctype != "" {
+			ts = ts.Put(TIDmime, StrTag(ctype))
+		}
+	}
+	return
+}
+
+// PackPipeline puts files from given sources into package. Each file is
+// read once at worker pool, and all requested hashes are computed in single
+// pass. Workers can run ahead of writing to limited number of files, which
+// content are kept in memory if it's not greater than buffer size. Larger
+// files are streamed into package on writing. Data writes are serialized in
+// order of sources, so result package is deterministic. File times are taken
+// from sources that provide file info. Returns number of written files, and
+// stops on first error.
+func (pkg *Package) PackPipeline(w io.WriteSeeker, list []PackSource, opts PackOpts) (n int, err error) {
+	return pkg.PackPipelineCtx(context.Background(), w, list, opts)
+}
+
+// PackPipelineCtx is PackPipeline with context. On cancellation it stops
+// after the last written file and returns context error. Package is not
+// synchronized in this case, and keeps the state recoverable by Recover.
+func (pkg *Package) PackPipelineCtx(ctx context.Context, w io.WriteSeeker, list []PackSource, opts PackOpts) (n int, err error) {
+	var prg = Progress{FilesTotal: len(list)}
+	for i := range list {
+		prg.BytesTotal += list[i].Size
+	}
+
+	var workers = opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var res = make([]chan packres, len(list))
+	for i := range res {
+		res[i] = make(chan packres, 1)
+	}
+	var jobs = make(chan int)
+	var stop = make(chan struct{})
+	var sem = make(chan struct{}, 2*workers) // limits running ahead of writing
+
+	// feed the jobs
+	go func() {
+		defer close(jobs)
+		for i := range list {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// run workers
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for k := 0; k < workers; k++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res[i] <- list[i].prepare(ctx, &opts)
+			}
+		}()
+	}
+	defer func() {
+		// close files left for streaming after stop
+		for i := range res {
+			select {
+			case r := <-res[i]:
+				if r.r != nil {
+					r.r.Close()
+				}
+			default:
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	// write the data in order of sources
+	for i := range list {
+		var src = &list[i]
+		var r packres
+		select {
+		case r = <-res[i]:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		<-sem
+		if err = r.err; err != nil {
+			return
+		}
+
+		var ts TagsetRaw
+		var size = int64(len(r.data))
+		var orig TagsetRaw
+		var ok bool
+		if src.HardLink != "" {
+			orig, ok = pkg.GetTagset(src.HardLink)
+		}
+		if ok {
+			ts = CopyTagset(orig).
+				Set(TIDpath, StrTag(pkg.FullPath(ToSlash(src.FKey)))).
+				Set(TIDhardlink, StrTag(pkg.FullPath(ToSlash(src.HardLink))))
+			size = src.Size
+		} else {
+			if r.link { // link target is absent, so read the file
+				var cpy = *src
+				cpy.HardLink = ""
+				if r = cpy.prepare(ctx, &opts); r.err != nil {
+					err = r.err
+					return
+				}
+				size = int64(len(r.data))
+			}
+			if r.r != nil {
+				ts, err = pkg.packstream(ctx, w, src, r.r, &opts)
+				r.r.Close()
+				if err != nil {
+					return
+				}
+				size = ts.Size()
+			} else if ts, err = pkg.PackData(w, bytes.NewReader(r.data), src.FKey); err != nil {
+				return
+			}
+			ts = append(ts, r.ts...)
+		}
+		if r.fi != nil {
+			var tsp = times.Get(r.fi)
+			ts = ts.Set(TIDmtime, TimeTag(tsp.ModTime()))
+			ts = ts.Set(TIDatime, TimeTag(tsp.AccessTime()))
+			if tsp.HasChangeTime() {
+				ts = ts.Set(TIDctime, TimeTag(tsp.ChangeTime()))
+			}
+			if tsp.HasBirthTime() {
+				ts = ts.Set(TIDbtime, TimeTag(tsp.BirthTime()))
+			}
+		}
+		var tsi = src.Tags.Iterator()
+		for tsi.Next() {
+			ts = ts.Set(tsi.TID(), tsi.Tag())
+		}
+		if opts.Hook != nil {
+			if ts, err = opts.Hook(src.FKey, ts); err != nil {
+				return
+			}
+		}
+		pkg.SetTagset(src.FKey, ts)
+		n++
+
+		prg.FKey = src.FKey
+		prg.Files++
+		prg.Bytes += size
+		opts.Progress.report(&prg)
+	}
+	return
+}
+
+// PackDir puts all files of given directory into package through
+// packing pipeline. Names of files in package are prefixed by given
+// prefix. Returns number of written files.
+func (pkg *Package) PackDir(w io.WriteSeeker, dirpath, prefix string, opts PackOpts) (n int, err error) {
+	return pkg.PackDirCtx(context.Background(), w, dirpath, prefix, opts)
+}
+
+// PackDirCtx is PackDir with context, see PackPipelineCtx.
+func (pkg *Package) PackDirCtx(ctx context.Context, w io.WriteSeeker, dirpath, prefix string, opts PackOpts) (n int, err error) {
+	var list []PackSource
+	if err = fs.WalkDir(os.DirFS(dirpath), ".", func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil // file is directory
+		}
+		var fi fs.FileInfo
+		if fi, err = d.Info(); err != nil {
+			return err
+		}
+		list = append(list, PackSource{
+			FKey:  JoinPath(prefix, fpath),
+			FPath: JoinPath(dirpath, fpath),
+			Size:  fi.Size(),
+		})
+		return nil
+	}); err != nil {
+		return
+	}
+	return pkg.PackPipelineCtx(ctx, w, list, opts)
+}
+
+// DirOpts is the set of options to select files of directory for packing.
+type DirOpts struct {
+	IgnoreFile  string   // name of files with ignore rules at any directory, such as IgnoreFileName, no rules if it's empty
+	Include     []string // glob patterns of files to pack, all files if it's empty, see MatchGlob
+	Exclude     []string // glob patterns of files to skip
+	MaxSize     int64    // files greater than this size are skipped, no limit if it's zero
+	FollowLinks bool     // follow symbolic links to directories, links to files are always followed
+	HardLinks   bool     // detect hard links to the same file, and put them as aliases to the first one
+}
+
+// ListDir returns sources for packing pipeline with files of given
+// directory selected by options. Names of files in package are
+// prefixed by given prefix, patterns are matched to names without
+// prefix. Ignore files themselves are not included into the list.
+// With HardLinks option each next file with the same device and inode
+// numbers as some previous one gets HardLink field with its name.
+func ListDir(ctx context.Context, dirpath, prefix string, opts DirOpts) (list []PackSource, err error) {
+	var real string
+	if real, err = filepath.EvalSymlinks(dirpath); err != nil {
+		return
+	}
+	var visited = map[string]Void{real: {}}
+	var links = map[[2]uint64]string{}
+	err = listdir(ctx, dirpath, "", prefix, nil, visited, links, &opts, &list)
+	return
+}
+
+// listdir appends to the list files of directory with given path
+// relative to the packing root, and walks its subdirectories.
+func listdir(ctx context.Context, dirpath, rel, prefix string, ig Ignore, visited map[string]Void, links map[[2]uint64]string, opts *DirOpts, list *[]PackSource) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if opts.IgnoreFile != "" {
+		var f *os.File
+		if f, err = os.Open(JoinPath(dirpath, opts.IgnoreFile)); err == nil {
+			ig, err = append(Ignore{}, ig...).Load(f, rel)
+			f.Close()
+			if err != nil {
+				return
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+	}
+
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(dirpath); err != nil {
+		return
+	}
+	for _, d := range entries {
+		var fpath = JoinPath(dirpath, d.Name())
+		var fkey = JoinPath(rel, d.Name())
+		var fi fs.FileInfo
+		if d.Type()&fs.ModeSymlink != 0 {
+			if fi, err = os.Stat(fpath); err != nil {
+				return
+			}
+			if fi.IsDir() && !opts.FollowLinks {
+				continue
+			}
+		} else if fi, err = d.Info(); err != nil {
+			return
+		}
+
+		if ig.Match(fkey, fi.IsDir()) || MatchGlob(fkey, opts.Exclude) {
+			continue
+		}
+		if fi.IsDir() {
+			var real string
+			if real, err = filepath.EvalSymlinks(fpath); err != nil {
+				return
+			}
+			if _, ok := visited[real]; ok {
+				continue // link to one of parent directories
+			}
+			visited[real] = Void{}
+			err = listdir(ctx, fpath, fkey, prefix, ig, visited, links, opts, list)
+			delete(visited, real)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() || d.Name() == opts.IgnoreFile {
+			continue
+		}
+		if len(opts.Include) > 0 && !MatchGlob(fkey, opts.Include) {
+			continue
+		}
+		if opts.MaxSize > 0 && fi.Size() > opts.MaxSize {
+			continue
+		}
+		var src = PackSource{
+			FKey:  JoinPath(prefix, fkey),
+			FPath: fpath,
+			Size:  fi.Size(),
+		}
+		if opts.HardLinks {
+			if dev, ino, nlink, ok := FileInode(fi); ok && nlink > 1 {
+				var key = [2]uint64{dev, ino}
+				if orig, ok := links[key]; ok {
+					src.HardLink = orig
+				} else {
+					links[key] = src.FKey
+				}
+			}
+		}
+		*list = append(*list, src)
+	}
+	return
+}
+
+// The End.
@@ -0,0 +1,128 @@
+package wpk_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// Test packing pipeline with worker pool.
+func TestPackPipeline(t *testing.T) {
+	var err error
+	var list = []wpk.PackSource{
+		{FKey: "bounty.jpg", FPath: mediadir + "bounty.jpg"},
+		{FKey: "img1/claustral.jpg", FPath: mediadir + "img1/claustral.jpg"},
+		{FKey: "img1/Qarataşlar.jpg", FPath: mediadir + "img1/Qarataşlar.jpg"},
+		{FKey: "img2/marble.jpg", FPath: mediadir + "img2/marble.jpg"},
+		{FKey: "img2/Uzuncı.jpg", FPath: mediadir + "img2/Uzuncı.jpg"},
+		{FKey: "sample.txt", Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(memdata["sample.txt"])), nil
+		}, Tags: wpk.TagsetRaw{}.Put(wpk.TIDmime, wpk.StrTag("text/plain"))},
+	}
+	var secret = []byte("secret")
+	var hooked []string
+	var opts = wpk.PackOpts{
+		Workers: 3,
+		Hashes:  []wpk.TID{wpk.TIDcrc32c, wpk.TIDsha256},
+		Secret:  secret,
+		Mime: func(fkey string, data []byte) string {
+			return "image/jpeg"
+		},
+		Hook: func(fkey string, ts wpk.TagsetRaw) (wpk.TagsetRaw, error) {
+			hooked = append(hooked, fkey)
+			return ts.Put(wpk.TIDlabel, wpk.StrTag("hooked")), nil
+		},
+	}
+
+	defer os.Remove(testpack)
+	// files greater than buffer size are streamed on writing
+	for _, bufsize := range []int64{0, 20} {
+		hooked = nil
+		opts.BufferSize = bufsize
+		t.Run(fmt.Sprintf("buffer%d", bufsize), func(t *testing.T) {
+			var fwpk *os.File
+			if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+				t.Fatal(err)
+			}
+			defer fwpk.Close()
+
+			var pkg = wpk.NewPackage()
+			if err = pkg.Begin(fwpk, nil); err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			if n, err = pkg.PackPipeline(fwpk, list, opts); err != nil {
+				t.Fatal(err)
+			}
+			if n != len(list) {
+				t.Fatalf("expected %d packed files, got %d", len(list), n)
+			}
+			if err = pkg.Sync(fwpk, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// check up order, content and tags
+			var lastoff uint
+			for i, src := range list {
+				if hooked[i] != src.FKey {
+					t.Fatalf("hook #%d called for '%s', expected '%s'", i, hooked[i], src.FKey)
+				}
+				var ts, ok = pkg.GetTagset(src.FKey)
+				if !ok {
+					t.Fatalf("file '%s' is absent", src.FKey)
+				}
+				var offset, _ = ts.Pos()
+				if offset < lastoff {
+					t.Fatalf("file '%s' is written out of order", src.FKey)
+				}
+				lastoff = offset
+
+				var orig = memdata[src.FKey]
+				if src.FPath != "" {
+					if orig, err = os.ReadFile(src.FPath); err != nil {
+						t.Fatal(err)
+					}
+					if !ts.Has(wpk.TIDmtime) {
+						t.Errorf("file '%s' has no modification time", src.FKey)
+					}
+				}
+				var data = make([]byte, len(orig))
+				if _, err = fwpk.ReadAt(data, int64(offset)); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(orig, data) {
+					t.Fatalf("content of '%s' is not equal to original", src.FKey)
+				}
+				for _, tid := range opts.Hashes {
+					var h = wpk.NewHash(tid, secret)
+					h.Write(orig)
+					if tag, _ := ts.Get(tid); !bytes.Equal(tag, h.Sum(nil)) {
+						t.Errorf("file '%s' has wrong hash with tag ID %d", src.FKey, tid)
+					}
+				}
+				var ctype, _ = ts.TagStr(wpk.TIDmime)
+				if (src.FPath != "" && ctype != "image/jpeg") || (src.FPath == "" && ctype != "text/plain") {
+					t.Errorf("file '%s' has unexpected MIME type '%s'", src.FKey, ctype)
+				}
+				if label, _ := ts.TagStr(wpk.TIDlabel); label != "hooked" {
+					t.Errorf("file '%s' was not adjusted by hook", src.FKey)
+				}
+			}
+
+			// check up error on absent source
+			if _, err = pkg.PackPipeline(fwpk, []wpk.PackSource{
+				{FKey: "none.dat", FPath: mediadir + "none.dat"},
+			}, opts); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("expected not exist error, got %v", err)
+			}
+		})
+	}
+}
+
+// The End.
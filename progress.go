@@ -0,0 +1,51 @@
+package wpk
+
+import (
+	"context"
+	"io"
+)
+
+// Progress is the state of long-running operation.
+type Progress struct {
+	FKey       string // name of the last processed file
+	Files      int    // number of processed files
+	FilesTotal int    // total number of files
+	Bytes      int64  // number of processed bytes
+	BytesTotal int64  // total number of bytes, can be zero if it's unknown
+}
+
+// ProgressFunc is called after each processed file of long-running operation.
+type ProgressFunc func(Progress)
+
+// ProgressChan returns progress callback that sends the state to given channel.
+// Sending does not block the operation, state is skipped if channel is busy.
+func ProgressChan(ch chan<- Progress) ProgressFunc {
+	return func(p Progress) {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// report calls progress callback if it's set.
+func (f ProgressFunc) report(p *Progress) {
+	if f != nil {
+		f(*p)
+	}
+}
+
+// ctxreader is reader that stops reading if context is done.
+type ctxreader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxreader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// The End.
@@ -0,0 +1,240 @@
+package wpk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+// Test progress reporting and cancellation of packing from directory.
+func TestPackDirCtx(t *testing.T) {
+	var err error
+
+	defer os.Remove(testpack)
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpk.Close()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelled context breaks packing
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err = pkg.PackDirCtx(ctx, fwpk, mediadir, "", wpk.PackOpts{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+
+	var last wpk.Progress
+	var calls int
+	var n int
+	if n, err = pkg.PackDir(fwpk, mediadir, "", wpk.PackOpts{
+		Workers: 2,
+		Progress: func(p wpk.Progress) {
+			if p.Files != calls+1 {
+				t.Fatalf("progress #%d reports %d files", calls+1, p.Files)
+			}
+			calls++
+			last = p
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls != n || last.Files != last.FilesTotal || last.FilesTotal != pkg.TagsetNum() {
+		t.Fatalf("progress does not reach totals: %+v, %d files packed", last, n)
+	}
+	if last.Bytes != last.BytesTotal || last.Bytes == 0 {
+		t.Fatalf("progress bytes does not reach totals: %+v", last)
+	}
+}
+
+// Test files extraction, and compaction of package with garbage.
+func TestExtractCompact(t *testing.T) {
+	var err error
+	var list = []string{
+		"bounty.jpg",
+		"img1/claustral.jpg",
+		"img2/marble.jpg",
+	}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	// append the file and delete one to leave garbage in package
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(testpack1, os.O_RDWR, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.OpenStream(fwpk); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Append(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pkg.PackData(fwpk, bytes.NewReader(memdata["sample.txt"]), "sample.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.PutAlias("img1/claustral.jpg", "claustral.jpg"); err != nil {
+			t.Fatal(err)
+		}
+		pkg.DelTagset("img2/marble.jpg")
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	t.Run("extract", func(t *testing.T) {
+		var dir = t.TempDir()
+		var ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		if err = pkg.ExtractCtx(ctx, dir, wpk.ExtractOpts{}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected cancellation error, got %v", err)
+		}
+
+		var last wpk.Progress
+		if err = pkg.Extract(dir, wpk.ExtractOpts{
+			OrgTime: true,
+			Progress: func(p wpk.Progress) {
+				last = p
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if last.Files != 4 || last.FilesTotal != 4 || last.Bytes != last.BytesTotal {
+			t.Fatalf("unexpected final progress: %+v", last)
+		}
+		for _, fkey := range []string{"bounty.jpg", "img1/claustral.jpg", "claustral.jpg"} {
+			var orig, ext []byte
+			if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+				if orig, err = os.ReadFile(mediadir + "img1/" + fkey); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if ext, err = os.ReadFile(dir + "/" + fkey); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(orig, ext) {
+				t.Fatalf("content of extracted file '%s' is not equal to original", fkey)
+			}
+		}
+		var ext []byte
+		if ext, err = os.ReadFile(dir + "/sample.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ext, memdata["sample.txt"]) {
+			t.Fatal("content of extracted 'sample.txt' is not equal to original")
+		}
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		defer os.Remove(testpack2)
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(testpack2, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var last wpk.Progress
+		var dst *wpk.Package
+		if dst, err = pkg.Compact(fwpk, func(p wpk.Progress) {
+			last = p
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if last.Files != 4 || last.Bytes != last.BytesTotal {
+			t.Fatalf("unexpected final progress: %+v", last)
+		}
+
+		var fi1, fi2 os.FileInfo
+		if fi1, err = os.Stat(testpack1); err != nil {
+			t.Fatal(err)
+		}
+		if fi2, err = fwpk.Stat(); err != nil {
+			t.Fatal(err)
+		}
+		if fi2.Size() >= fi1.Size() {
+			t.Fatalf("compacted package has %d bytes, source has %d bytes", fi2.Size(), fi1.Size())
+		}
+
+		if dst.TagsetNum() != pkg.TagsetNum() {
+			t.Fatalf("compacted package has %d files, expected %d", dst.TagsetNum(), pkg.TagsetNum())
+		}
+		var ts1, _ = dst.GetTagset("img1/claustral.jpg")
+		var ts2, _ = dst.GetTagset("claustral.jpg")
+		var off1, size1 = ts1.Pos()
+		var off2, size2 = ts2.Pos()
+		if off1 != off2 || size1 != size2 {
+			t.Fatal("alias does not refer to the same data at compacted package")
+		}
+
+		var rep *wpk.FsckReport
+		if rep, err = wpk.FsckFile(testpack2, wpk.FsckOpts{Hashes: true}); err != nil {
+			t.Fatal(err)
+		}
+		if rep.Worst() > wpk.SevWarn {
+			t.Fatalf("compacted package is damaged: %v", rep.Findings)
+		}
+		for _, f := range rep.Findings {
+			if errors.Is(f.What, wpk.ErrUnused) {
+				t.Fatalf("compacted package has unused data: %s", f.String())
+			}
+		}
+	})
+}
+
+// Test progress reporting on verification of hashes.
+func TestFsckProgress(t *testing.T) {
+	var err error
+	PackFiles(t, testpack1, []string{"bounty.jpg", "img1/claustral.jpg"})
+	defer os.Remove(testpack1)
+
+	var f *os.File
+	if f, err = os.Open(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var last wpk.Progress
+	var opts = wpk.FsckOpts{
+		Hashes: true,
+		Progress: func(p wpk.Progress) {
+			last = p
+		},
+	}
+	if _, err = wpk.Fsck(f, f, opts); err != nil {
+		t.Fatal(err)
+	}
+	if last.Files != 2 || last.FilesTotal != 2 || last.Bytes != last.BytesTotal {
+		t.Fatalf("unexpected final progress: %+v", last)
+	}
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err = wpk.FsckCtx(ctx, f, f, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+}
@@ -0,0 +1,504 @@
+package wpk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrQuery is error on query expression parsing.
+type ErrQuery struct {
+	What error  // error message
+	Pos  int    // position in expression
+	Tok  string // token at position
+}
+
+func (e *ErrQuery) Error() string {
+	if e.Tok == "" {
+		return fmt.Sprintf("query at position %d: %s", e.Pos, e.What.Error())
+	}
+	return fmt.Sprintf("query at position %d, token '%s': %s", e.Pos, e.Tok, e.What.Error())
+}
+
+func (e *ErrQuery) Unwrap() error {
+	return e.What
+}
+
+// Errors on query expression.
+var (
+	ErrQuerySyntax = errors.New("syntax error")
+	ErrQueryEnd    = errors.New("unexpected end of expression")
+	ErrQueryTag    = errors.New("tag name is undefined")
+	ErrQueryOp     = errors.New("operator is not applicable to tag type")
+	ErrQueryVal    = errors.New("value can not be converted to tag type")
+)
+
+// KeywordsSep is the set of delimiters for keywords and category tags.
+const KeywordsSep = ",;"
+
+// SplitKeywords splits string with delimiter-separated values,
+// such as keywords or category tags, to trimmed non-empty items.
+func SplitKeywords(s string) (list []string) {
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool {
+		return strings.ContainsRune(KeywordsSep, r)
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// Query operators.
+const (
+	qopHas = iota
+	qopEQ
+	qopNE
+	qopLT
+	qopLE
+	qopGT
+	qopGE
+	qopContains
+	qopStarts
+	qopEnds
+	qopLike
+)
+
+var qopsym = map[string]int{
+	"=":  qopEQ,
+	"==": qopEQ,
+	"!=": qopNE,
+	"<>": qopNE,
+	"<":  qopLT,
+	"<=": qopLE,
+	">":  qopGT,
+	">=": qopGE,
+}
+
+// qnode is node of compiled query expression.
+type qnode interface {
+	match(ts TagsetRaw) bool
+}
+
+type qand [2]qnode
+
+func (n qand) match(ts TagsetRaw) bool {
+	return n[0].match(ts) && n[1].match(ts)
+}
+
+type qor [2]qnode
+
+func (n qor) match(ts TagsetRaw) bool {
+	return n[0].match(ts) || n[1].match(ts)
+}
+
+type qnot [1]qnode
+
+func (n qnot) match(ts TagsetRaw) bool {
+	return !n[0].match(ts)
+}
+
+// qcond is the condition on single tag.
+type qcond struct {
+	tid TID
+	op  int
+	str string    // value for string types
+	bin []byte    // value for binary type
+	u   uint      // value for unsigned integer and boolean types
+	f   float64   // value for number type
+	t   time.Time // value for time type
+}
+
+func cmpres(c, op int) bool {
+	switch op {
+	case qopEQ:
+		return c == 0
+	case qopNE:
+		return c != 0
+	case qopLT:
+		return c < 0
+	case qopLE:
+		return c <= 0
+	case qopGT:
+		return c > 0
+	case qopGE:
+		return c >= 0
+	}
+	return false
+}
+
+func cmpord[T uint | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (n *qcond) match(ts TagsetRaw) bool {
+	var tag, ok = ts.Get(n.tid)
+	if !ok {
+		return false
+	}
+	if n.op == qopHas {
+		return true
+	}
+	switch TidType[n.tid] {
+	case TTbin:
+		return cmpres(bytes.Compare(tag, n.bin), n.op)
+	case TTbool:
+		var val, ok = tag.TagBool()
+		if !ok {
+			return false
+		}
+		var u uint
+		if val {
+			u = 1
+		}
+		return cmpres(cmpord(u, n.u), n.op)
+	case TTuint:
+		var val, ok = tag.TagUint()
+		return ok && cmpres(cmpord(val, n.u), n.op)
+	case TTnum:
+		var val, ok = tag.TagNumber()
+		return ok && cmpres(cmpord(val, n.f), n.op)
+	case TTtime:
+		var val, ok = tag.TagTime()
+		return ok && cmpres(val.Compare(n.t), n.op)
+	default: // TTany, TTstr
+		var val = B2S(tag)
+		switch n.op {
+		case qopContains:
+			if n.tid == TIDkeywords || n.tid == TIDcategory {
+				for _, item := range SplitKeywords(val) {
+					if strings.EqualFold(item, n.str) {
+						return true
+					}
+				}
+				return false
+			}
+			return strings.Contains(val, n.str)
+		case qopStarts:
+			return strings.HasPrefix(val, n.str)
+		case qopEnds:
+			return strings.HasSuffix(val, n.str)
+		case qopLike:
+			var matched, _ = path.Match(n.str, val)
+			return matched
+		default:
+			return cmpres(strings.Compare(val, n.str), n.op)
+		}
+	}
+}
+
+// qtoken is lexical token of query expression.
+type qtoken struct {
+	str string // token content
+	pos int    // position in expression
+	sym bool   // token is operator symbol or parenthesis
+}
+
+func qlex(expr string) (list []qtoken, err error) {
+	var i = 0
+	for i < len(expr) {
+		var c = expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			list = append(list, qtoken{expr[i : i+1], i, true})
+			i++
+		case c == '<' || c == '>' || c == '=' || c == '!':
+			var j = i + 1
+			if j < len(expr) && (expr[j] == '=' || (c == '<' && expr[j] == '>')) {
+				j++
+			}
+			if _, ok := qopsym[expr[i:j]]; !ok {
+				return nil, &ErrQuery{ErrQuerySyntax, i, expr[i:j]}
+			}
+			list = append(list, qtoken{expr[i:j], i, true})
+			i = j
+		case c == '"' || c == '\'':
+			var j = strings.IndexByte(expr[i+1:], c)
+			if j < 0 {
+				return nil, &ErrQuery{ErrQueryEnd, len(expr), ""}
+			}
+			list = append(list, qtoken{expr[i+1 : i+1+j], i, false})
+			i += j + 2
+		default:
+			var j = i
+			for j < len(expr) && !strings.ContainsRune(" \t\r\n()<>=!\"'", rune(expr[j])) {
+				j++
+			}
+			list = append(list, qtoken{expr[i:j], i, false})
+			i = j
+		}
+	}
+	return
+}
+
+// qparser is recursive descent parser of query expression.
+type qparser struct {
+	list []qtoken
+	pos  int
+	end  int // length of expression
+}
+
+func (p *qparser) peek() (qtoken, bool) {
+	if p.pos < len(p.list) {
+		return p.list[p.pos], true
+	}
+	return qtoken{pos: p.end}, false
+}
+
+// iskw checks that next token is given keyword, and skips it if so.
+func (p *qparser) iskw(kw string) bool {
+	if tok, ok := p.peek(); ok && !tok.sym && strings.EqualFold(tok.str, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *qparser) issym(sym string) bool {
+	if tok, ok := p.peek(); ok && tok.sym && tok.str == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *qparser) errat(what error) error {
+	var tok, _ = p.peek()
+	if tok.str == "" && what == ErrQuerySyntax {
+		what = ErrQueryEnd
+	}
+	return &ErrQuery{what, tok.pos, tok.str}
+}
+
+// expr = term { "or" term }
+func (p *qparser) expr() (n qnode, err error) {
+	if n, err = p.term(); err != nil {
+		return
+	}
+	for p.iskw("or") {
+		var n2 qnode
+		if n2, err = p.term(); err != nil {
+			return
+		}
+		n = qor{n, n2}
+	}
+	return
+}
+
+// term = factor { "and" factor }
+func (p *qparser) term() (n qnode, err error) {
+	if n, err = p.factor(); err != nil {
+		return
+	}
+	for p.iskw("and") {
+		var n2 qnode
+		if n2, err = p.factor(); err != nil {
+			return
+		}
+		n = qand{n, n2}
+	}
+	return
+}
+
+// factor = "not" factor | "(" expr ")" | cond
+func (p *qparser) factor() (n qnode, err error) {
+	if p.iskw("not") {
+		if n, err = p.factor(); err != nil {
+			return
+		}
+		return qnot{n}, nil
+	}
+	if p.issym("(") {
+		if n, err = p.expr(); err != nil {
+			return
+		}
+		if !p.issym(")") {
+			return nil, p.errat(ErrQuerySyntax)
+		}
+		return
+	}
+	return p.cond()
+}
+
+// cond = name [ op value ]
+func (p *qparser) cond() (n qnode, err error) {
+	var tok, ok = p.peek()
+	if !ok || tok.sym {
+		return nil, p.errat(ErrQuerySyntax)
+	}
+	var tid TID
+	if tid, ok = ParseTid(ToLower(tok.str)); !ok {
+		return nil, p.errat(ErrQueryTag)
+	}
+	p.pos++
+	var c = &qcond{tid: tid}
+
+	// get operator
+	if tok, ok = p.peek(); !ok {
+		return c, nil // existence of tag
+	}
+	if tok.sym {
+		if c.op, ok = qopsym[tok.str]; !ok {
+			return c, nil // closing parenthesis
+		}
+		p.pos++
+	} else {
+		switch {
+		case p.iskw("contains"):
+			c.op = qopContains
+		case p.iskw("like"):
+			c.op = qopLike
+		case p.iskw("starts"):
+			c.op = qopStarts
+			if !p.iskw("with") {
+				return nil, p.errat(ErrQuerySyntax)
+			}
+		case p.iskw("ends"):
+			c.op = qopEnds
+			if !p.iskw("with") {
+				return nil, p.errat(ErrQuerySyntax)
+			}
+		default:
+			return c, nil // existence of tag followed by boolean operator
+		}
+	}
+	var tt = TidType[tid]
+	if c.op >= qopContains && tt != TTstr && tt != TTany {
+		return nil, &ErrQuery{ErrQueryOp, tok.pos, tok.str}
+	}
+	if tt == TTbool && c.op != qopEQ && c.op != qopNE {
+		return nil, &ErrQuery{ErrQueryOp, tok.pos, tok.str}
+	}
+
+	// get value
+	if tok, ok = p.peek(); !ok || tok.sym {
+		return nil, p.errat(ErrQuerySyntax)
+	}
+	var tag TagRaw
+	if tag, err = ParseTag(tid, tok.str); err != nil {
+		return nil, p.errat(ErrQueryVal)
+	}
+	switch tt {
+	case TTbin:
+		c.bin = tag
+	case TTbool:
+		c.u, _ = tag.TagUint()
+	case TTuint:
+		c.u, _ = tag.TagUint()
+	case TTnum:
+		c.f, _ = tag.TagNumber()
+	case TTtime:
+		c.t, _ = tag.TagTime()
+	default:
+		c.str = tok.str
+		if c.op == qopLike {
+			if _, err = path.Match(c.str, ""); err != nil {
+				return nil, p.errat(err)
+			}
+		}
+	}
+	p.pos++
+	return c, nil
+}
+
+// Query is compiled expression to select tagsets by its tags.
+//
+// Expression consists of conditions joined by "and", "or", "not" operators
+// and parentheses. Each condition is tag name followed by comparison operator
+// and value, or tag name only to check tag existence. Tag names are the same
+// as keys of NameTid, or decimal tag identifiers. Comparison operators are
+// "=", "!=", "<", "<=", ">", ">=" for any tag type, and "contains",
+// "starts with", "ends with", "like" for string tags. "like" matches value
+// with glob pattern, "contains" checks up the keywords and category tags for
+// item in delimiter-separated list case-insensitive. Values are converted
+// to tag type: sizes can have K, M, G, T suffixes, times are in ISO8601
+// format or date only, binary values are in hex. Values with spaces or
+// operator symbols should be quoted. Condition on absent tag is always false.
+//
+// Example:
+//
+//	keywords contains beach and mime starts with image/ and size > 1MB
+type Query struct {
+	root qnode
+	expr string
+}
+
+// ParseQuery compiles query expression.
+func ParseQuery(expr string) (q *Query, err error) {
+	var p = qparser{end: len(expr)}
+	if p.list, err = qlex(expr); err != nil {
+		return
+	}
+	var root qnode
+	if root, err = p.expr(); err != nil {
+		return
+	}
+	if p.pos < len(p.list) {
+		err = p.errat(ErrQuerySyntax)
+		return
+	}
+	q = &Query{root: root, expr: expr}
+	return
+}
+
+// Match checks up that tagset satisfies to query.
+func (q *Query) Match(ts TagsetRaw) bool {
+	return q.root.match(ts)
+}
+
+// String returns source expression of query.
+func (q *Query) String() string {
+	return q.expr
+}
+
+// Query returns the names of all files in package with tagsets
+// satisfies to given query expression.
+func (pkg *Package) Query(expr string) (res []string, err error) {
+	var q *Query
+	if q, err = ParseQuery(expr); err != nil {
+		return
+	}
+	pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+		if q.Match(ts) {
+			res = append(res, fkey)
+		}
+		return true
+	})
+	return
+}
+
+// Query returns the names of all files in union with tagsets satisfies
+// to given query expression. If union have more than one file with
+// the same name, only first is checked up.
+func (u *Union) Query(expr string) (res []string, err error) {
+	var q *Query
+	if q, err = ParseQuery(expr); err != nil {
+		return
+	}
+	var found = map[string]Void{}
+	for _, pkg := range u.List {
+		pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+			if _, ok := found[fkey]; !ok {
+				if q.Match(ts) {
+					res = append(res, fkey)
+				}
+				found[fkey] = Void{}
+			}
+			return true
+		})
+	}
+	return
+}
+
+// The End.
@@ -0,0 +1,112 @@
+package wpk_test
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// Test query expressions matching on tagset.
+func TestQueryMatch(t *testing.T) {
+	var ts = wpk.TagsetRaw{}.
+		Put(wpk.TIDsize, wpk.UintTag(3*1024*1024)).
+		Put(wpk.TIDpath, wpk.StrTag("photo/beach.jpg")).
+		Put(wpk.TIDmtime, wpk.TimeTag(time.Date(2023, 5, 17, 12, 30, 0, 0, time.UTC))).
+		Put(wpk.TIDmime, wpk.StrTag("image/jpeg")).
+		Put(wpk.TIDkeywords, wpk.StrTag("Sea; beach, sun")).
+		Put(wpk.TIDlabel, wpk.StrTag("summer time"))
+
+	for expr, expected := range map[string]bool{
+		"mime":                               true,
+		"author":                             false,
+		"not author":                         true,
+		"size > 1MB":                         true,
+		"size>=3M and size<=3M":              true,
+		"size < 1024":                        false,
+		"mime = image/jpeg":                  true,
+		"mime != 'image/jpeg'":               false,
+		"mime starts with image/":            true,
+		"path ends with .png":                false,
+		"path like 'photo/*.jpg'":            true,
+		"keywords contains beach":            true,
+		"keywords contains SEA":              true,
+		"keywords contains bea":              false,
+		"label contains 'mer ti'":            true,
+		"mtime > 2023-01-01":                 true,
+		"mtime < '2023-05-17T12:00:00Z'":     false,
+		"author = john or mime = image/jpeg": true,
+		"(author = john or mime = image/jpeg) and size < 1K":                 false,
+		"not (keywords contains sun) or label = 'summer time'":               true,
+		"keywords contains beach and mime starts with image/ and size > 1MB": true,
+	} {
+		var q, err = wpk.ParseQuery(expr)
+		if err != nil {
+			t.Fatalf("query \"%s\": %s", expr, err)
+		}
+		if q.Match(ts) != expected {
+			t.Errorf("query \"%s\" expected to be %t", expr, expected)
+		}
+	}
+
+	for expr, what := range map[string]error{
+		"":                  wpk.ErrQueryEnd,
+		"size >":            wpk.ErrQueryEnd,
+		"(mime":             wpk.ErrQueryEnd,
+		"mime = 'image":     wpk.ErrQueryEnd,
+		"mime image":        wpk.ErrQuerySyntax,
+		"unknown = 1":       wpk.ErrQueryTag,
+		"size contains 1":   wpk.ErrQueryOp,
+		"size > big":        wpk.ErrQueryVal,
+		"mtime > yesterday": wpk.ErrQueryVal,
+		"mime starts image": wpk.ErrQuerySyntax,
+		"mime = a ! b":      wpk.ErrQuerySyntax,
+	} {
+		var _, err = wpk.ParseQuery(expr)
+		if !errors.Is(err, what) {
+			t.Errorf("query \"%s\" expected error '%v', got '%v'", expr, what, err)
+		}
+	}
+}
+
+// Test files selection from package by query.
+func TestQueryPackage(t *testing.T) {
+	PackFiles(t, testpack, []string{
+		"bounty.jpg",
+		"img1/claustral.jpg",
+		"img1/Qarataşlar.jpg",
+		"img2/marble.jpg",
+	})
+	defer os.Remove(testpack)
+
+	var err error
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(testpack); err != nil {
+		t.Fatal(err)
+	}
+
+	var ts, _ = pkg.GetTagset("img1/claustral.jpg")
+	pkg.SetTagset("img1/claustral.jpg", wpk.CopyTagset(ts).
+		Put(wpk.TIDkeywords, wpk.StrTag("basalt;bay")))
+
+	var list []string
+	if list, err = pkg.Query("path starts with img1/ and not keywords contains bay"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(list, ",") != "img1/Qarataşlar.jpg" {
+		t.Fatalf("unexpected query result %v", list)
+	}
+	if list, err = pkg.Query("keywords contains basalt or path = bounty.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(list)
+	if strings.Join(list, ",") != "bounty.jpg,img1/claustral.jpg" {
+		t.Fatalf("unexpected query result %v", list)
+	}
+}
+
+// The End.
@@ -0,0 +1,96 @@
+package wpk
+
+import (
+	"io"
+	"os"
+)
+
+// Recover restores the last consistent state of package which was left
+// after interrupted writing, and opens it. Package started by Begin and
+// never synchronized becomes empty. Package left with SignBuild signature
+// gets the true signature if its file tags table pointed by header is valid.
+// Trailing data written after the last consistent state is cut off
+// for single package file if given stream supports truncation.
+// Package should not be opened for writing by anyone else during recovery.
+func (ftt *FTT) Recover(rws io.ReadWriteSeeker) (err error) {
+	// read header
+	if _, err = rws.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	var hdr Header
+	if _, err = hdr.ReadFrom(rws); err != nil {
+		return
+	}
+	var sign = B2S(hdr.signature[:])
+	if sign != SignReady && sign != SignBuild {
+		return ErrSignBad
+	}
+
+	if hdr.fttsize == 0 { // nothing was synchronized
+		hdr.fttcount = 0
+		hdr.fttoffset = hdr.datoffset
+		hdr.datsize = 0
+	}
+	ftt.Init(&hdr)
+	if hdr.fttsize > 0 {
+		// read the last written file tags table
+		if _, err = rws.Seek(int64(hdr.fttoffset), io.SeekStart); err != nil {
+			return
+		}
+		var fttbuf = make([]byte, hdr.fttsize)
+		if _, err = io.ReadFull(rws, fttbuf); err != nil {
+			return
+		}
+		var fttsize int64
+		if fttsize, err = ftt.Parse(fttbuf); err != nil {
+			return
+		}
+		if fttsize != int64(hdr.fttsize) {
+			return ErrSignFTT
+		}
+		hdr.fttcount = uint64(ftt.tsm.Len())
+	}
+
+	// rewrite true header
+	copy(hdr.signature[:], SignReady)
+	if _, err = rws.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if _, err = hdr.WriteTo(rws); err != nil {
+		return
+	}
+	if err = flush(rws); err != nil {
+		return
+	}
+
+	// cut off the trailing data
+	if f, ok := rws.(truncater); ok {
+		var end = hdr.fttoffset + hdr.fttsize
+		if hdr.datoffset > 0 && hdr.datoffset+hdr.datsize > end { // single package file
+			end = hdr.datoffset + hdr.datsize
+		}
+		if end < HeaderSize {
+			end = HeaderSize
+		}
+		if err = f.Truncate(int64(end)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RecoverFile restores the last consistent state of package
+// with given file name, it calls `Recover` method with file stream.
+// For splitted package it should be the file with tags table.
+func (ftt *FTT) RecoverFile(fpath string) (err error) {
+	var f *os.File
+	if f, err = os.OpenFile(fpath, os.O_RDWR, 0); err != nil {
+		return
+	}
+	defer f.Close()
+
+	err = ftt.Recover(f)
+	return
+}
+
+// The End.
@@ -0,0 +1,283 @@
+package wpk_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// Test recovery of single file package after interrupted writing.
+func TestRecover(t *testing.T) {
+	var err error
+	var fwpk *os.File
+	var tagsnum int
+	var pkg = wpk.NewPackage()
+
+	defer os.Remove(testpack)
+
+	var putfile = func(name string) {
+		var file fs.File
+		if file, err = os.Open(mediadir + name); err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var ts wpk.TagsetRaw
+		if ts, err = pkg.PackFile(fwpk, file, name); err != nil {
+			t.Fatal(err)
+		}
+		pkg.SetupTagset(ts.Put(wpk.TIDlink, wpk.StrTag(mediadir+name)))
+		tagsnum++
+	}
+	var recover = func(expected int) {
+		var rec = wpk.NewPackage()
+		if err = rec.RecoverFile(testpack); err != nil {
+			t.Fatal(err)
+		}
+		if rec.TagsetNum() != expected {
+			t.Fatalf("expected %d files in recovered package, got %d", expected, rec.TagsetNum())
+		}
+	}
+
+	t.Run("begin", func(t *testing.T) {
+		if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		putfile("bounty.jpg")
+		fwpk.Close() // crash before sync
+
+		if err = wpk.NewPackage().OpenFile(testpack); !errors.Is(err, wpk.ErrSignPre) {
+			t.Fatalf("expected not ready package, got %v", err)
+		}
+		recover(0)
+		var fi, _ = os.Stat(testpack)
+		if fi.Size() != wpk.HeaderSize {
+			t.Fatalf("expected truncated to header package, got %d bytes", fi.Size())
+		}
+	})
+
+	t.Run("append", func(t *testing.T) {
+		tagsnum = 0
+		pkg = wpk.NewPackage()
+		if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		putfile("bounty.jpg")
+		putfile("img1/claustral.jpg")
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		var fi, _ = fwpk.Stat()
+		var goodsize = fi.Size()
+
+		pkg.SafeAppend = true
+		if err = pkg.Append(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		putfile("img2/marble.jpg")
+		fwpk.Close() // crash before sync
+
+		// package stays readable in the last consistent state
+		var last = wpk.NewPackage()
+		if err = last.OpenFile(testpack); err != nil {
+			t.Fatal(err)
+		}
+		if last.TagsetNum() != 2 {
+			t.Fatalf("expected 2 files in package, got %d", last.TagsetNum())
+		}
+		recover(2)
+		fi, _ = os.Stat(testpack)
+		if fi.Size() != goodsize {
+			t.Fatalf("expected %d bytes in recovered package, got %d", goodsize, fi.Size())
+		}
+
+		// continue to append after recovery
+		tagsnum = 2
+		pkg = wpk.NewPackage()
+		if fwpk, err = os.OpenFile(testpack, os.O_RDWR, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+		if err = pkg.OpenStream(fwpk); err != nil {
+			t.Fatal(err)
+		}
+		pkg.SafeAppend = true
+		if err = pkg.Append(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		putfile("img2/marble.jpg")
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		CheckPackage(t, fwpk, fwpk, tagsnum)
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		// put prebuild signature to synchronized package
+		var f *os.File
+		if f, err = os.OpenFile(testpack, os.O_RDWR, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.WriteAt([]byte(wpk.SignBuild), 0); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		recover(3)
+		var last = wpk.NewPackage()
+		if err = last.OpenFile(testpack); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Test that repeated appending without new files does not grow the package.
+func TestAppendSize(t *testing.T) {
+	for _, safe := range []bool{false, true} {
+		t.Run(fmt.Sprintf("safe=%t", safe), func(t *testing.T) {
+			var err error
+			var pkgpath = path.Join(t.TempDir(), "append.wpk")
+			var fwpk *os.File
+			if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+				t.Fatal(err)
+			}
+			defer fwpk.Close()
+			var pkg = wpk.NewPackage()
+			pkg.SafeAppend = safe
+			if err = pkg.Begin(fwpk, nil); err != nil {
+				t.Fatal(err)
+			}
+			for fkey, data := range memdata {
+				if _, err = pkg.PackData(fwpk, bytes.NewReader(data), fkey); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = pkg.Sync(fwpk, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// edit tags of files as metadata edit does
+			var sizes []int64
+			for i := 0; i < 6; i++ {
+				if err = pkg.Append(fwpk, nil); err != nil {
+					t.Fatal(err)
+				}
+				var ts, _ = pkg.GetTagset("sample.txt")
+				pkg.SetTagset("sample.txt", ts.Set(wpk.TIDcomment, wpk.StrTag(fmt.Sprintf("edit #%d", i))))
+				if err = pkg.Sync(fwpk, nil); err != nil {
+					t.Fatal(err)
+				}
+				var fi, _ = fwpk.Stat()
+				sizes = append(sizes, fi.Size())
+
+				var check = wpk.NewPackage()
+				if err = check.OpenFile(pkgpath); err != nil {
+					t.Fatal(err)
+				}
+				if ts, _ = check.GetTagset("sample.txt"); ts.Has(wpk.TIDcomment) {
+					if str, _ := ts.TagStr(wpk.TIDcomment); str != fmt.Sprintf("edit #%d", i) {
+						t.Fatalf("unexpected comment '%s' after edit #%d", str, i)
+					}
+				} else {
+					t.Fatalf("comment is lost after edit #%d", i)
+				}
+			}
+			// tags tables are placed in turn to two places for crash-safe
+			// appending, since the first free place is big enough
+			for i := 4; i < len(sizes); i++ {
+				if sizes[i] != sizes[i-2] {
+					t.Fatalf("package grows on appending without files: %v", sizes)
+				}
+			}
+			if !safe && sizes[1] != sizes[0] {
+				t.Fatalf("package size is changed on appending without files: %v", sizes)
+			}
+		})
+	}
+}
+
+// Test that splitted package keeps previous tags table on sync.
+func TestRecoverSplit(t *testing.T) {
+	var err error
+	var fwpt, fwpf *os.File
+	var tagsnum int
+	var pkg = wpk.NewPackage()
+
+	defer os.Remove(testpkgt)
+	defer os.Remove(testpkgf)
+
+	if fwpt, err = os.OpenFile(testpkgt, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpt.Close()
+	if fwpf, err = os.OpenFile(testpkgf, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpf.Close()
+
+	var putfile = func(name string) {
+		var file fs.File
+		if file, err = os.Open(mediadir + name); err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var ts wpk.TagsetRaw
+		if ts, err = pkg.PackFile(fwpf, file, name); err != nil {
+			t.Fatal(err)
+		}
+		pkg.SetupTagset(ts.Put(wpk.TIDlink, wpk.StrTag(mediadir+name)))
+		tagsnum++
+	}
+	var fttpos = func() (offset, size uint) {
+		var hdr wpk.Header
+		if _, err = fwpt.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = hdr.ReadFrom(fwpt); err != nil {
+			t.Fatal(err)
+		}
+		return hdr.FttOffset(), hdr.FttSize()
+	}
+
+	if err = pkg.Begin(fwpt, fwpf); err != nil {
+		t.Fatal(err)
+	}
+	var prevoff, prevsize uint
+	for i, name := range []string{
+		"bounty.jpg",
+		"img1/claustral.jpg",
+		"img1/Qarataşlar.jpg",
+		"img2/marble.jpg",
+		"img2/Uzuncı.jpg",
+	} {
+		putfile(name)
+		if err = pkg.Sync(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+		var offset, size = fttpos()
+		if i > 0 && offset < prevoff+prevsize && offset+size > prevoff {
+			t.Fatalf("tags table #%d overlaps previous one", i+1)
+		}
+		if i == 0 && offset != wpk.HeaderSize {
+			t.Fatalf("expected first table at header end, got %d", offset)
+		}
+		prevoff, prevsize = offset, size
+		CheckPackage(t, fwpt, fwpf, tagsnum)
+	}
+	if fi, _ := fwpt.Stat(); fi.Size() > int64(prevoff+prevsize) {
+		t.Fatal("tags table file contains trailing data")
+	}
+}
+
+// The End.
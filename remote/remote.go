@@ -0,0 +1,298 @@
+package remote
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+var (
+	ErrStatus  = errors.New("unexpected response status")
+	ErrNoRange = errors.New("server does not support range requests")
+	ErrRange   = errors.New("server returns unexpected range")
+)
+
+// Default values of options.
+const (
+	DefBlockSize   = 64 * 1024
+	DefCacheBlocks = 256
+)
+
+// Options is the set of options for remote access to package.
+type Options struct {
+	Client      *http.Client // client to make requests, http.DefaultClient if it's nil
+	BlockSize   int          // size of cached blocks, DefBlockSize if it's zero
+	CacheBlocks int          // maximum number of cached blocks, DefCacheBlocks if it's zero
+}
+
+// Stats is statistics of remote reader.
+type Stats struct {
+	Requests int64 // number of made HTTP requests
+	Hits     int64 // number of blocks taken from cache
+	Misses   int64 // number of fetched blocks
+}
+
+// block is cached part of remote file.
+type block struct {
+	idx  int64
+	data []byte
+}
+
+// Reader gives random access to remote file by HTTP Range requests.
+// File is divided into blocks of fixed size, and each read fetches
+// missing blocks, adjacent blocks are fetched by single request.
+// Recently used blocks are cached. io.ReaderAt implementation.
+type Reader struct {
+	url    string
+	client *http.Client
+	size   int64
+	bsize  int64
+	maxblk int
+
+	cache map[int64]*list.Element // block index to LRU list element
+	lru   *list.List              // cached blocks, most recently used first
+	mux   sync.Mutex
+
+	requests, hits, misses atomic.Int64
+}
+
+// NewReader creates reader of remote file at given URL. It fetches
+// the first block of file to determine the size of file.
+func NewReader(url string, opts Options) (r *Reader, err error) {
+	r = &Reader{
+		url:    url,
+		client: opts.Client,
+		bsize:  int64(opts.BlockSize),
+		maxblk: opts.CacheBlocks,
+		cache:  map[int64]*list.Element{},
+		lru:    list.New(),
+	}
+	if r.client == nil {
+		r.client = http.DefaultClient
+	}
+	if r.bsize <= 0 {
+		r.bsize = DefBlockSize
+	}
+	if r.maxblk <= 0 {
+		r.maxblk = DefCacheBlocks
+	}
+
+	var data []byte
+	if data, r.size, err = r.fetch(0, r.bsize); err != nil {
+		return nil, err
+	}
+	r.misses.Add(1)
+	r.put(0, data)
+	return
+}
+
+// Size returns size of remote file.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Stats returns statistics of requests and cache usage.
+func (r *Reader) Stats() Stats {
+	return Stats{
+		Requests: r.requests.Load(),
+		Hits:     r.hits.Load(),
+		Misses:   r.misses.Load(),
+	}
+}
+
+// fetch makes request for given range of bytes and returns
+// received data and total size of remote file.
+func (r *Reader) fetch(from, to int64) (data []byte, total int64, err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, r.url, nil); err != nil {
+		return
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to-1))
+	r.requests.Add(1)
+	var resp *http.Response
+	if resp, err = r.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		err = ErrNoRange
+		return
+	default:
+		err = fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+		return
+	}
+
+	// parse "bytes from-to/total" value
+	var cr = resp.Header.Get("Content-Range")
+	var rng, size, ok = strings.Cut(strings.TrimPrefix(cr, "bytes "), "/")
+	if !ok {
+		err = ErrRange
+		return
+	}
+	var sfrom, _, _ = strings.Cut(rng, "-")
+	var rfrom int64
+	if rfrom, err = strconv.ParseInt(sfrom, 10, 64); err != nil || rfrom != from {
+		err = ErrRange
+		return
+	}
+	if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+		err = ErrRange
+		return
+	}
+	if to > total {
+		to = total
+	}
+	data = make([]byte, to-from)
+	if _, err = io.ReadFull(resp.Body, data); err != nil {
+		return
+	}
+	return
+}
+
+// get returns cached block with given index.
+func (r *Reader) get(idx int64) ([]byte, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if e, ok := r.cache[idx]; ok {
+		r.lru.MoveToFront(e)
+		return e.Value.(*block).data, true
+	}
+	return nil, false
+}
+
+// put places block with given index to cache, and evicts
+// least recently used blocks if cache is full.
+func (r *Reader) put(idx int64, data []byte) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if e, ok := r.cache[idx]; ok {
+		r.lru.MoveToFront(e)
+		return
+	}
+	r.cache[idx] = r.lru.PushFront(&block{idx: idx, data: data})
+	for r.lru.Len() > r.maxblk {
+		var e = r.lru.Back()
+		delete(r.cache, e.Value.(*block).idx)
+		r.lru.Remove(e)
+	}
+}
+
+// ReadAt reads len(p) bytes from remote file starting at byte offset off.
+// io.ReaderAt implementation.
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, wpk.ErrOutOff
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	var end = off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	if end == off {
+		return 0, nil
+	}
+	var first, last = off / r.bsize, (end - 1) / r.bsize
+
+	// take cached blocks, and fetch missing runs of blocks
+	var blocks = make([][]byte, last-first+1)
+	for idx := first; idx <= last; idx++ {
+		if data, ok := r.get(idx); ok {
+			r.hits.Add(1)
+			blocks[idx-first] = data
+		}
+	}
+	for idx := first; idx <= last; idx++ {
+		if blocks[idx-first] != nil {
+			continue
+		}
+		var till = idx + 1
+		for till <= last && blocks[till-first] == nil {
+			till++
+		}
+		var data []byte
+		if data, _, err = r.fetch(idx*r.bsize, till*r.bsize); err != nil {
+			return
+		}
+		for ; idx < till; idx++ {
+			var bdata = data[:min64(r.bsize, int64(len(data)))]
+			data = data[len(bdata):]
+			blocks[idx-first] = bdata
+			r.misses.Add(1)
+			r.put(idx, bdata)
+		}
+		idx-- // compensate loop increment
+	}
+
+	// copy data from blocks
+	for idx := first; idx <= last; idx++ {
+		var data = blocks[idx-first]
+		var bstart = idx * r.bsize
+		if hi := end - bstart; hi < int64(len(data)) {
+			data = data[:hi]
+		}
+		if lo := off - bstart; lo > 0 {
+			data = data[lo:]
+		}
+		n += copy(p[n:], data)
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// MakeTagger creates Tagger object to get access to package nested
+// files placed at given URL. Data is read by HTTP Range requests.
+func MakeTagger(url string, opts Options) (wpk.Tagger, error) {
+	var r, err = NewReader(url, opts)
+	if err != nil {
+		return nil, err
+	}
+	return wpk.NewReaderTagger(r, r.Size()), nil
+}
+
+// OpenPackage fetches the header and file tags table of package placed
+// at given URL, and returns package with tagger that reads nested files
+// by HTTP Range requests. For splitted package given URL should point
+// to ".wpt" file, and data file is taken at the same URL with ".wpf"
+// extension. Blocks with tags table are cached too, so reading of the
+// files placed next to it needs no extra requests.
+func OpenPackage(url string, opts Options) (pkg *wpk.Package, err error) {
+	var r *Reader
+	if r, err = NewReader(url, opts); err != nil {
+		return
+	}
+	pkg = wpk.NewPackage()
+	if err = pkg.OpenStream(io.NewSectionReader(r, 0, r.Size())); err != nil {
+		return nil, err
+	}
+	if pkg.IsSplitted() {
+		if r, err = NewReader(wpk.MakeDataPath(url), opts); err != nil {
+			return nil, err
+		}
+	}
+	pkg.Tagger = wpk.NewReaderTagger(r, r.Size())
+	return
+}
+
+// The End.
@@ -13,6 +13,7 @@ func TestS2B(t *testing.T) {
 	var s = "some string"
 	var ps = unsafe.Pointer(unsafe.StringData(s))
 	var b = []byte(s)
+	b[0] = 'S' // prevent compiler from sharing string memory with unmodified slice
 	var pb = unsafe.Pointer(unsafe.SliceData(b))
 	if ps == pb {
 		t.Error("string pointer is equal to pointer on new allocated bytes slice")
@@ -81,7 +82,7 @@ func ExampleJoinFilePath() {
 	// dir/base.ext
 }
 
-func ExampleBaseName() {
+func ExamplePathName() {
 	fmt.Println(wpk.PathName("C:\\Windows\\system.ini"))
 	fmt.Println(wpk.PathName("/go/bin/wpkbuild_win_x64.exe"))
 	fmt.Println(wpk.PathName("wpkbuild_win_x64.exe"))
@@ -0,0 +1,216 @@
+package wpk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncOpts is the set of options for incremental synchronization
+// of destination directory with package content.
+type SyncOpts struct {
+	Include   []string      // glob patterns of files to synchronize, all files if it's empty
+	Exclude   []string      // glob patterns of files to skip, such files are never deleted
+	Path      PathPolicy    // what to do with unsafe file names
+	Delete    bool          // delete destination files that are not present in package
+	DryRun    bool          // only make the report, do not change anything
+	Secret    []byte        // private key for MD5 and SHA hashes
+	ModWindow time.Duration // allowed difference of modification times
+	Workers   int           // number of parallel workers, number of CPUs if it's zero
+	Progress  ProgressFunc  // called after each processed file
+}
+
+// SyncReport is the list of changes made by synchronization.
+// Files are named by package keys, deleted files are named
+// by slash-separated path relative to destination directory.
+type SyncReport struct {
+	Added     []string // files that were absent at destination
+	Updated   []string // files with changed content
+	Deleted   []string // files that are not present in package
+	Unchanged int      // number of files that were left as is
+	Bytes     int64    // number of written bytes
+}
+
+// SyncHashTIDs is the list of hashes that are used to compare
+// file content on synchronization in order of preference.
+var SyncHashTIDs = []TID{
+	TIDsha256, TIDcrc64iso, TIDcrc32c, TIDcrc32ieee, TIDcrc32k,
+	TIDsha512, TIDsha384, TIDsha224, TIDsha1, TIDmd5,
+}
+
+// SyncDir makes destination directory to have the same content as package.
+func (pkg *Package) SyncDir(dstdir string, opts SyncOpts) (*SyncReport, error) {
+	return pkg.SyncDirCtx(context.Background(), dstdir, opts)
+}
+
+// SyncDirCtx is SyncDir with context. It compares each file of package
+// with the destination file by stored hash if it's present, or by size
+// and modification time, and writes only new and changed files. Written
+// files gets modification time from package, so next synchronization
+// finds them unchanged. On cancellation it returns context error, and
+// the report with changes that were made before.
+func (pkg *Package) SyncDirCtx(ctx context.Context, dstdir string, opts SyncOpts) (rep *SyncReport, err error) {
+	rep = &SyncReport{}
+	// paths given by directory walk are cleaned, so destination
+	// path should be the same to match them with extracted files
+	dstdir = ToSlash(filepath.Clean(dstdir))
+	var eo = ExtractOpts{
+		OrgTime: true,
+		Include: opts.Include,
+		Exclude: opts.Exclude,
+		Path:    opts.Path,
+	}
+	var list []extractjob
+	if list, err = pkg.extractlist(dstdir, &eo); err != nil {
+		return
+	}
+
+	var mux sync.Mutex
+	err = runjobs(ctx, list, opts.Workers, opts.Progress, func(ctx context.Context, job *extractjob) (n int64, err error) {
+		var exist, same bool
+		if exist, same, err = syncequal(ctx, job.ts, job.fpath, &opts); err != nil || same {
+			if same {
+				mux.Lock()
+				rep.Unchanged++
+				mux.Unlock()
+			}
+			return job.ts.Size(), err
+		}
+		var written int64
+		if !opts.DryRun {
+			if written, err = pkg.extractfile(ctx, job.fkey, job.ts, job.fpath, &eo); err != nil {
+				return
+			}
+		}
+		mux.Lock()
+		if exist {
+			rep.Updated = append(rep.Updated, job.fkey)
+		} else {
+			rep.Added = append(rep.Added, job.fkey)
+		}
+		rep.Bytes += written
+		mux.Unlock()
+		return job.ts.Size(), nil
+	})
+	sort.Strings(rep.Added)
+	sort.Strings(rep.Updated)
+	if err != nil || !opts.Delete {
+		return
+	}
+
+	var names = make(map[string]Void, len(list))
+	for _, job := range list {
+		names[job.fpath] = Void{}
+	}
+	err = syncdelete(ctx, dstdir, names, &opts, rep)
+	return
+}
+
+// syncequal checks up whether destination file exists, and whether
+// it has the same content as the file with given tagset.
+func syncequal(ctx context.Context, ts TagsetRaw, fpath string, opts *SyncOpts) (exist, same bool, err error) {
+	var fi fs.FileInfo
+	if fi, err = os.Stat(fpath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	exist = true
+	if !fi.Mode().IsRegular() || fi.Size() != ts.Size() {
+		return
+	}
+
+	for _, tid := range SyncHashTIDs {
+		if tag, ok := ts.Get(tid); ok {
+			var f *os.File
+			if f, err = os.Open(fpath); err != nil {
+				return
+			}
+			defer f.Close()
+			var h = NewHash(tid, opts.Secret)
+			if _, err = io.Copy(h, ctxreader{ctx, f}); err != nil {
+				return
+			}
+			same = bytes.Equal(h.Sum(nil), tag)
+			return
+		}
+	}
+
+	if mtime, ok := ts.TagTime(TIDmtime); ok {
+		var diff = fi.ModTime().Sub(mtime).Truncate(time.Millisecond)
+		if diff < 0 {
+			diff = -diff
+		}
+		same = diff <= opts.ModWindow
+	}
+	return
+}
+
+// syncdelete deletes files of destination directory that are absent
+// in given set of extracted files, and directories that became empty.
+func syncdelete(ctx context.Context, dstdir string, names map[string]Void, opts *SyncOpts, rep *SyncReport) error {
+	var dirs = map[string]Void{}
+	var err = filepath.WalkDir(dstdir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && fpath == dstdir {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		var rel string
+		if rel, err = filepath.Rel(dstdir, fpath); err != nil {
+			return err
+		}
+		rel = ToSlash(rel)
+		if _, ok := names[JoinPath(dstdir, rel)]; ok {
+			return nil
+		}
+		if len(opts.Include) > 0 && !MatchGlob(rel, opts.Include) {
+			return nil
+		}
+		if MatchGlob(rel, opts.Exclude) {
+			return nil
+		}
+		if !opts.DryRun {
+			if err = os.Remove(fpath); err != nil {
+				return err
+			}
+		}
+		rep.Deleted = append(rep.Deleted, rel)
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = Void{}
+		}
+		return nil
+	})
+	if err != nil || opts.DryRun {
+		return err
+	}
+	// remove directories that became empty, from the deepest ones,
+	// directories with content can not be removed
+	var list = make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(list)))
+	for _, dir := range list {
+		os.Remove(JoinPath(dstdir, dir))
+	}
+	return nil
+}
+
+// The End.
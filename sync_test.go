@@ -0,0 +1,207 @@
+package wpk_test
+
+import (
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+// Test incremental synchronization of directory with package.
+func TestSyncDir(t *testing.T) {
+	var err error
+	var pkgpath = path.Join(t.TempDir(), "sync.wpk")
+	var mtime = time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	var files = map[string][]byte{
+		"sample.txt":     memdata["sample.txt"],
+		"docs/array.dat": memdata["array.dat"],
+	}
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		for fkey, data := range files {
+			var ts wpk.TagsetRaw
+			if ts, err = pkg.PackData(fwpk, bytes.NewReader(data), fkey); err != nil {
+				t.Fatal(err)
+			}
+			ts = ts.Put(wpk.TIDmtime, wpk.TimeTag(mtime))
+			if fkey == "docs/array.dat" {
+				var h = crc32.NewIEEE()
+				h.Write(data)
+				ts = ts.Put(wpk.TIDcrc32ieee, h.Sum(nil))
+			}
+			pkg.SetTagset(fkey, ts)
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var dir = t.TempDir()
+	var sync = func(opts wpk.SyncOpts, expect wpk.SyncReport) {
+		t.Helper()
+		var rep *wpk.SyncReport
+		if rep, err = pkg.SyncDir(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		rep.Bytes = 0
+		if !reflect.DeepEqual(*rep, expect) {
+			t.Fatalf("unexpected report %+v, expected %+v", *rep, expect)
+		}
+	}
+	var write = func(name string, data []byte, mt time.Time) {
+		t.Helper()
+		var fpath = path.Join(dir, name)
+		if err = os.MkdirAll(path.Dir(fpath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fpath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(fpath, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dry run does not write anything
+	sync(wpk.SyncOpts{DryRun: true}, wpk.SyncReport{
+		Added: []string{"docs/array.dat", "sample.txt"},
+	})
+	if _, err = os.Stat(path.Join(dir, "sample.txt")); !os.IsNotExist(err) {
+		t.Fatal("file was written on dry run")
+	}
+
+	sync(wpk.SyncOpts{}, wpk.SyncReport{
+		Added: []string{"docs/array.dat", "sample.txt"},
+	})
+	sync(wpk.SyncOpts{}, wpk.SyncReport{
+		Unchanged: 2,
+	})
+
+	// file with the same size and time is compared by hash
+	var data = append([]byte{}, memdata["array.dat"]...)
+	data[0] = 255
+	write("docs/array.dat", data, mtime)
+	// file without hash is compared by size and time
+	write("sample.txt", memdata["sample.txt"], time.Now())
+	write("extra/junk.bin", []byte("junk"), time.Now())
+	write("keep.log", []byte("log"), time.Now())
+	sync(wpk.SyncOpts{
+		Delete:  true,
+		Exclude: []string{"*.log"},
+	}, wpk.SyncReport{
+		Updated: []string{"docs/array.dat", "sample.txt"},
+		Deleted: []string{"extra/junk.bin"},
+	})
+	for fkey, orig := range files {
+		var b []byte
+		if b, err = os.ReadFile(path.Join(dir, fkey)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, orig) {
+			t.Fatalf("content of synchronized file '%s' is not equal to original", fkey)
+		}
+	}
+	if _, err = os.Stat(path.Join(dir, "extra")); !os.IsNotExist(err) {
+		t.Fatal("empty directory was not deleted")
+	}
+	if _, err = os.Stat(path.Join(dir, "keep.log")); err != nil {
+		t.Fatal("excluded file was deleted")
+	}
+	sync(wpk.SyncOpts{}, wpk.SyncReport{
+		Unchanged: 2,
+	})
+}
+
+// Test synchronization with relative not cleaned destination path.
+func TestSyncDirRelative(t *testing.T) {
+	var err error
+	var dir = t.TempDir()
+	var pkgpath = path.Join(dir, "sync.wpk")
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, fkey := range []string{"a.txt", "sub/b.txt"} {
+			var ts wpk.TagsetRaw
+			if ts, err = pkg.PackData(fwpk, bytes.NewReader(memdata["sample.txt"]), fkey); err != nil {
+				t.Fatal(err)
+			}
+			pkg.SetTagset(fkey, ts.Put(wpk.TIDmtime, wpk.TimeTag(time.Now().Add(-time.Hour))))
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var wd string
+	if wd, err = os.Getwd(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, expect := range []wpk.SyncReport{
+		{Added: []string{"a.txt", "sub/b.txt"}},
+		{Unchanged: 2},
+	} {
+		var rep *wpk.SyncReport
+		if rep, err = pkg.SyncDir("./out/../out/", wpk.SyncOpts{Delete: true}); err != nil {
+			t.Fatal(err)
+		}
+		rep.Bytes = 0
+		if !reflect.DeepEqual(*rep, expect) {
+			t.Fatalf("unexpected report %+v, expected %+v", *rep, expect)
+		}
+	}
+	for _, fkey := range []string{"a.txt", "sub/b.txt"} {
+		if _, err = os.Stat(path.Join(dir, "out", fkey)); err != nil {
+			t.Fatalf("synchronized file '%s' is absent: %v", fkey, err)
+		}
+	}
+}
+
+// The End.
@@ -0,0 +1,152 @@
+package wpk
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+)
+
+// SectionFile structure gives access to nested into package file
+// by section of some io.ReaderAt. RFile interface implementation.
+type SectionFile struct {
+	*io.SectionReader
+	tags TagsetRaw // has fs.FileInfo interface
+}
+
+// Stat is for fs.File interface compatibility.
+func (f *SectionFile) Stat() (fs.FileInfo, error) {
+	return f.tags, nil
+}
+
+// Close is for fs.File interface compatibility.
+func (f *SectionFile) Close() error {
+	return nil
+}
+
+// ReaderTagger is object to get access to package nested files
+// by sections of any io.ReaderAt with known size, such as file
+// at embed.FS, bytes.Reader, or opened file. It can be used
+// concurrently if given reader supports concurrent ReadAt calls.
+type ReaderTagger struct {
+	r      io.ReaderAt
+	size   int64
+	closer io.Closer // closes the source if it was opened by helper
+}
+
+// NewReaderTagger creates Tagger object to get access to package nested
+// files at given reader with given size. Source is not closed by tagger.
+func NewReaderTagger(r io.ReaderAt, size int64) *ReaderTagger {
+	return &ReaderTagger{
+		r:    r,
+		size: size,
+	}
+}
+
+// OpenTagset creates file object to give access to nested into package file by given tagset.
+func (tgr *ReaderTagger) OpenTagset(ts TagsetRaw) (RFile, error) {
+	var offset, size = ts.Pos()
+	if int64(offset) > tgr.size {
+		return nil, ErrOutOff
+	}
+	if int64(offset+size) > tgr.size {
+		return nil, ErrOutSize
+	}
+	return &SectionFile{
+		SectionReader: io.NewSectionReader(tgr.r, int64(offset), int64(size)),
+		tags:          ts,
+	}, nil
+}
+
+// Close releases the source if it was opened by package opening helper.
+// io.Closer implementation.
+func (tgr *ReaderTagger) Close() (err error) {
+	if tgr.closer != nil {
+		err = tgr.closer.Close()
+		tgr.closer = nil
+	}
+	return
+}
+
+// OpenReaderAt opens single-file package at given reader with given size.
+// Tags table is read by FTT.OpenStream from the same source, and
+// package tagger gives access to nested files at it.
+func OpenReaderAt(r io.ReaderAt, size int64) (pkg *Package, err error) {
+	pkg = NewPackage()
+	if err = pkg.OpenStream(io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, err
+	}
+	if pkg.IsSplitted() {
+		return nil, ErrNoDatFile
+	}
+	pkg.Tagger = NewReaderTagger(r, size)
+	return
+}
+
+// OpenBytes opens single-file package that is entirely placed in given slice.
+// Slice content should not be modified while package is used.
+func OpenBytes(b []byte) (*Package, error) {
+	return OpenReaderAt(bytes.NewReader(b), int64(len(b)))
+}
+
+// openfsreader opens file at given file system as io.ReaderAt. File is
+// read into memory if it does not support random access, as files of
+// embed.FS prior to go1.21.
+func openfsreader(fsys fs.FS, fpath string) (r io.ReaderAt, size int64, c io.Closer, err error) {
+	var f fs.File
+	if f, err = fsys.Open(fpath); err != nil {
+		return
+	}
+	if ra, ok := f.(io.ReaderAt); ok {
+		var fi fs.FileInfo
+		if fi, err = f.Stat(); err != nil {
+			f.Close()
+			return
+		}
+		return ra, fi.Size(), f, nil
+	}
+	defer f.Close()
+	var b []byte
+	if b, err = io.ReadAll(f); err != nil {
+		return
+	}
+	return bytes.NewReader(b), int64(len(b)), nil, nil
+}
+
+// OpenFS opens package placed at given file system, such as embed.FS.
+// If package is splitted, data file is opened from the same file system
+// with ".wpf" extension. Files are closed on package closing.
+func OpenFS(fsys fs.FS, fpath string) (pkg *Package, err error) {
+	var r io.ReaderAt
+	var size int64
+	var c io.Closer
+	if r, size, c, err = openfsreader(fsys, fpath); err != nil {
+		return
+	}
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+
+	pkg = NewPackage()
+	if err = pkg.OpenStream(io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, err
+	}
+	if pkg.IsSplitted() {
+		if c != nil {
+			c.Close()
+		}
+		if r, size, c, err = openfsreader(fsys, MakeDataPath(fpath)); err != nil {
+			return nil, err
+		}
+	}
+	pkg.Tagger = &ReaderTagger{
+		r:      r,
+		size:   size,
+		closer: c,
+	}
+	c = nil // tagger owns the file now
+	return
+}
+
+// The End.
@@ -0,0 +1,745 @@
+package wpk_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/auto"
+	"github.com/schwarzlichtbezirk/wpk/fsys"
+	"github.com/schwarzlichtbezirk/wpk/mmap"
+	"github.com/schwarzlichtbezirk/wpk/remote"
+)
+
+// Test shared file handle and counting of opened files at fsys tagger.
+func TestFsysTagger(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg", "img2/marble.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	var tgr wpk.Tagger
+	if tgr, err = fsys.MakeTagger(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	pkg.Tagger = tgr
+	var ft = tgr.(*fsys.Tagger)
+
+	// read files concurrently through shared handle
+	var wg sync.WaitGroup
+	var files = make([]fs.File, len(list))
+	for i, fkey := range list {
+		if files[i], err = pkg.Open(fkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var errs = make([]error, len(list))
+	for i, fkey := range list {
+		wg.Add(1)
+		go func(i int, fkey string) {
+			defer wg.Done()
+			var orig, data []byte
+			if orig, errs[i] = os.ReadFile(mediadir + fkey); errs[i] != nil {
+				return
+			}
+			if data, errs[i] = io.ReadAll(files[i]); errs[i] != nil {
+				return
+			}
+			if !bytes.Equal(orig, data) {
+				errs[i] = errors.New("content of '" + fkey + "' is not equal to original")
+			}
+		}(i, fkey)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ft.Refs(); n != len(list) {
+		t.Fatalf("expected %d opened files, got %d", len(list), n)
+	}
+
+	// tagger can not be closed while files are opened
+	if err = pkg.Close(); !errors.Is(err, fsys.ErrBusy) {
+		t.Fatalf("expected busy error, got %v", err)
+	}
+	for _, f := range files[1:] {
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = files[1].Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("expected error on second closing, got %v", err)
+	}
+
+	// wait for the last file
+	var done = make(chan error)
+	go func() {
+		done <- ft.CloseWait()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("tagger is closed while file is opened")
+	default:
+	}
+	if _, err = pkg.Open(list[1]); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("expected error on opening at closing tagger, got %v", err)
+	}
+	if err = files[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// onlyreader hides io.ReaderAt of the file.
+type onlyreader struct {
+	fs.File
+}
+
+// noatfs is file system which files do not support random access.
+type noatfs struct {
+	fs.FS
+}
+
+func (fsys noatfs) Open(fpath string) (fs.File, error) {
+	var f, err = fsys.FS.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return onlyreader{f}, nil
+}
+
+// Test package opening from memory and from file system.
+func TestReaderTagger(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg", "img2/marble.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var b []byte
+	if b, err = os.ReadFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+
+	var check = func(t *testing.T, pkg *wpk.Package) {
+		defer pkg.Close()
+		if pkg.TagsetNum() != len(list) {
+			t.Fatalf("expected %d files, got %d", len(list), pkg.TagsetNum())
+		}
+		for _, fkey := range list {
+			var orig, data []byte
+			if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+				t.Fatal(err)
+			}
+			if data, err = fs.ReadFile(pkg, fkey); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(orig, data) {
+				t.Fatalf("content of '%s' is not equal to original", fkey)
+			}
+		}
+	}
+
+	t.Run("bytes", func(t *testing.T) {
+		var pkg *wpk.Package
+		if pkg, err = wpk.OpenBytes(b); err != nil {
+			t.Fatal(err)
+		}
+		check(t, pkg)
+		if _, err = wpk.OpenBytes(b[:wpk.HeaderSize-1]); err == nil {
+			t.Fatal("package is opened from truncated data")
+		}
+	})
+
+	var mfs = fstest.MapFS{
+		"pack/test.wpk": &fstest.MapFile{Data: b},
+	}
+	t.Run("fs", func(t *testing.T) {
+		var pkg *wpk.Package
+		if pkg, err = wpk.OpenFS(mfs, "pack/test.wpk"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, pkg)
+	})
+	t.Run("fs-noat", func(t *testing.T) {
+		var pkg *wpk.Package
+		if pkg, err = wpk.OpenFS(noatfs{mfs}, "pack/test.wpk"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, pkg)
+	})
+	t.Run("fs-split", func(t *testing.T) {
+		// make splitted package with the same files
+		var fwpt, fwpf *os.File
+		if fwpt, err = os.OpenFile(testpkgt, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(testpkgt)
+		defer fwpt.Close()
+		if fwpf, err = os.OpenFile(testpkgf, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(testpkgf)
+		defer fwpf.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+		for _, fkey := range list {
+			var data []byte
+			if data, err = os.ReadFile(mediadir + fkey); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = pkg.PackData(fwpf, bytes.NewReader(data), fkey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = pkg.Sync(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+
+		var bt, bf []byte
+		if bt, err = os.ReadFile(testpkgt); err != nil {
+			t.Fatal(err)
+		}
+		if bf, err = os.ReadFile(testpkgf); err != nil {
+			t.Fatal(err)
+		}
+		var sfs = fstest.MapFS{
+			"test.wpt": &fstest.MapFile{Data: bt},
+			"test.wpf": &fstest.MapFile{Data: bf},
+		}
+		if pkg, err = wpk.OpenFS(sfs, "test.wpt"); err != nil {
+			t.Fatal(err)
+		}
+		if !pkg.IsSplitted() {
+			t.Fatal("package is not splitted")
+		}
+		check(t, pkg)
+	})
+}
+
+// Test package reading by HTTP Range requests.
+func TestRemote(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg", "img2/marble.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var b []byte
+	if b, err = os.ReadFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	var ranges []string
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test.wpk":
+			ranges = append(ranges, r.Header.Get("Range"))
+			http.ServeContent(w, r, "test.wpk", arcmtime, bytes.NewReader(b))
+		case "/norange.wpk":
+			w.Write(b)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var pkg *wpk.Package
+	var opts = remote.Options{
+		Client:      srv.Client(),
+		BlockSize:   4096,
+		CacheBlocks: 64,
+	}
+	if pkg, err = remote.OpenPackage(srv.URL+"/test.wpk", opts); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+	if pkg.TagsetNum() != len(list) {
+		t.Fatalf("expected %d files, got %d", len(list), pkg.TagsetNum())
+	}
+	var reqnum = len(ranges)
+
+	// each file is fetched by single request
+	for _, fkey := range list {
+		var orig, data []byte
+		if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+			t.Fatal(err)
+		}
+		if data, err = fs.ReadFile(pkg, fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(orig, data) {
+			t.Fatalf("content of '%s' is not equal to original", fkey)
+		}
+	}
+	if len(ranges)-reqnum > len(list) {
+		t.Fatalf("expected at most %d requests for %d files, got %d: %s",
+			len(list), len(list), len(ranges)-reqnum, strings.Join(ranges[reqnum:], "; "))
+	}
+
+	// second reading is taken from cache
+	reqnum = len(ranges)
+	for _, fkey := range list {
+		if _, err = fs.ReadFile(pkg, fkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ranges) != reqnum {
+		t.Fatalf("files are fetched again, %d extra requests", len(ranges)-reqnum)
+	}
+
+	if _, err = remote.OpenPackage(srv.URL+"/norange.wpk", opts); !errors.Is(err, remote.ErrNoRange) {
+		t.Fatalf("expected error for server without ranges, got %v", err)
+	}
+	if _, err = remote.OpenPackage(srv.URL+"/absent.wpk", opts); !errors.Is(err, remote.ErrStatus) {
+		t.Fatalf("expected status error, got %v", err)
+	}
+}
+
+// countreader counts read bytes of underlying reader.
+type countreader struct {
+	r io.ReaderAt
+	n int64
+	m sync.Mutex
+}
+
+func (r *countreader) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = r.r.ReadAt(p, off)
+	r.m.Lock()
+	r.n += int64(n)
+	r.m.Unlock()
+	return
+}
+
+// Test caching tagger decorator.
+func TestCacheTagger(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg", "img2/marble.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var b []byte
+	if b, err = os.ReadFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	var cr = &countreader{r: bytes.NewReader(b)}
+	var pkg *wpk.Package
+	if pkg, err = wpk.OpenBytes(b); err != nil {
+		t.Fatal(err)
+	}
+	var ct = wpk.NewCacheTagger(wpk.NewReaderTagger(cr, int64(len(b))), wpk.CacheOpts{
+		Budget:    24 * 1024,
+		BlockSize: 4096,
+	})
+	pkg.Tagger = ct
+	defer pkg.Close()
+
+	var readall = func(fkey string) {
+		var orig, data []byte
+		if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+			t.Fatal(err)
+		}
+		if data, err = fs.ReadFile(pkg, fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(orig, data) {
+			t.Fatalf("content of '%s' is not equal to original", fkey)
+		}
+	}
+
+	// the first reading takes data from source, the second from cache
+	readall(list[0])
+	var n = cr.n
+	readall(list[0])
+	if cr.n != n {
+		t.Fatalf("file is read from source again, %d bytes", cr.n-n)
+	}
+	var stat = ct.Stats()
+	if stat.Hits == 0 || stat.Misses == 0 || stat.Used == 0 {
+		t.Fatalf("unexpected statistics: %+v", stat)
+	}
+
+	// reading of other files evicts blocks to fit the budget
+	readall(list[1])
+	readall(list[2])
+	stat = ct.Stats()
+	if stat.Used > 24*1024 || stat.Evicted == 0 {
+		t.Fatalf("cache does not fit the budget: %+v", stat)
+	}
+	n = cr.n
+	readall(list[0])
+	if cr.n == n {
+		t.Fatal("evicted file is not read from source")
+	}
+
+	// read at random position
+	var f fs.File
+	if f, err = pkg.Open(list[1]); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var orig []byte
+	if orig, err = os.ReadFile(mediadir + list[1]); err != nil {
+		t.Fatal(err)
+	}
+	var buf = make([]byte, 5000)
+	if _, err = f.(io.ReaderAt).ReadAt(buf, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, orig[3000:8000]) {
+		t.Fatal("content read at position is not equal to original")
+	}
+}
+
+// doubletagger decodes content of nested files by doubling of each byte,
+// so the size of content differs from stored size.
+type doubletagger struct {
+	wpk.Tagger
+}
+
+// doublefile is nested file with decoded content.
+type doublefile struct {
+	*bytes.Reader
+	fi fs.FileInfo
+}
+
+func (f *doublefile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *doublefile) Close() error {
+	return nil
+}
+
+// doubleinfo is file info with size of decoded content.
+type doubleinfo struct {
+	wpk.TagsetRaw
+}
+
+func (fi doubleinfo) Size() int64 {
+	return 2 * fi.TagsetRaw.Size()
+}
+
+func (tgr doubletagger) OpenTagset(ts wpk.TagsetRaw) (wpk.RFile, error) {
+	var f, err = tgr.Tagger.OpenTagset(ts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var b []byte
+	if b, err = io.ReadAll(f); err != nil {
+		return nil, err
+	}
+	var d = make([]byte, 2*len(b))
+	for i, c := range b {
+		d[2*i], d[2*i+1] = c, c
+	}
+	return &doublefile{bytes.NewReader(d), doubleinfo{ts}}, nil
+}
+
+// Test caching tagger over tagger that decodes files content.
+func TestCacheTaggerDecode(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var b []byte
+	if b, err = os.ReadFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	var pkg *wpk.Package
+	if pkg, err = wpk.OpenBytes(b); err != nil {
+		t.Fatal(err)
+	}
+	var ct = wpk.NewCacheTagger(doubletagger{wpk.NewReaderTagger(bytes.NewReader(b), int64(len(b)))}, wpk.CacheOpts{
+		BlockSize: 4096,
+	})
+	pkg.Tagger = ct
+	defer pkg.Close()
+
+	for _, fkey := range list {
+		var orig []byte
+		if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+			t.Fatal(err)
+		}
+		var dbl = make([]byte, 0, 2*len(orig))
+		for _, c := range orig {
+			dbl = append(dbl, c, c)
+		}
+		for i := 0; i < 2; i++ { // the second reading is taken from cache
+			var f fs.File
+			if f, err = pkg.Open(fkey); err != nil {
+				t.Fatal(err)
+			}
+			var data []byte
+			data, err = io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dbl, data) {
+				t.Fatalf("decoded content of '%s' has size %d, expected %d", fkey, len(data), len(dbl))
+			}
+			var fi fs.FileInfo
+			if fi, err = f.Stat(); err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != int64(len(dbl)) {
+				t.Fatalf("file '%s' has size %d, expected %d", fkey, fi.Size(), len(dbl))
+			}
+		}
+	}
+	if stat := ct.Stats(); stat.Hits == 0 {
+		t.Fatalf("decoded content is not cached: %+v", stat)
+	}
+}
+
+// Test views of shared memory mapping.
+func TestMmapShared(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg", "img2/marble.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(testpack1); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = mmap.MakeSharedTagger(testpack1); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []fs.File
+	for _, fkey := range list {
+		var f fs.File
+		if f, err = pkg.Open(fkey); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+
+		var orig []byte
+		if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+			t.Fatal(err)
+		}
+		var vf, ok = f.(*mmap.ViewFile)
+		if !ok {
+			t.Fatalf("file '%s' is not a view of shared mapping", fkey)
+		}
+		if !bytes.Equal(orig, vf.Bytes()) {
+			t.Fatalf("content of '%s' is not equal to original", fkey)
+		}
+		var data []byte
+		if data, err = io.ReadAll(vf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(orig, data) {
+			t.Fatalf("read content of '%s' is not equal to original", fkey)
+		}
+	}
+
+	if err = pkg.Close(); !errors.Is(err, mmap.ErrBusy) {
+		t.Fatalf("expected busy error, got %v", err)
+	}
+	for _, f := range files {
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pkg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pkg.Open(list[0]); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("expected error on opening at closed tagger, got %v", err)
+	}
+}
+
+// Test sliding window mapping of large file.
+func TestMmapWindow(t *testing.T) {
+	var err error
+	defer os.Remove(testpack)
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(testpack, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpk.Close()
+
+	// large file placed at unaligned offset
+	var big = make([]byte, 300*1024+123)
+	for i := range big {
+		big[i] = byte(i * 7 / 5)
+	}
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pkg.PackData(fwpk, bytes.NewReader(memdata["sample.txt"]), "sample.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pkg.PackData(fwpk, bytes.NewReader(big), "big.dat"); err != nil {
+		t.Fatal(err)
+	}
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkg.Tagger, err = mmap.MakeWindowTagger(testpack, 64*1024); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var f fs.File
+	if f, err = pkg.Open("big.dat"); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var wf, ok = f.(*mmap.WindowFile)
+	if !ok {
+		t.Fatal("large file is not mapped by window")
+	}
+
+	var data []byte
+	if data, err = io.ReadAll(wf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, big) {
+		t.Fatal("content of large file is not equal to original")
+	}
+
+	// read across windows bounds in random order
+	for _, pos := range []int64{200000, 65000, 0, 250000, 307000} {
+		var buf = make([]byte, 10000)
+		var n int
+		n, err = wf.ReadAt(buf, pos)
+		if pos+int64(len(buf)) > int64(len(big)) {
+			if err != io.EOF {
+				t.Fatalf("expected EOF at position %d, got %v", pos, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], big[pos:pos+int64(n)]) {
+			t.Fatalf("content at position %d is not equal to original", pos)
+		}
+	}
+	if _, err = wf.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = io.ReadAll(wf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, big[len(big)-100:]) {
+		t.Fatal("content of file tail is not equal to original")
+	}
+
+	// small file is mapped entirely
+	var sf fs.File
+	if sf, err = pkg.Open("sample.txt"); err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if _, ok := sf.(*mmap.MappedFile); !ok {
+		t.Fatal("small file is not mapped entirely")
+	}
+}
+
+// Test package opening with auto-detection of layout and tagger.
+func TestOpenPackage(t *testing.T) {
+	var err error
+	var list = []string{"bounty.jpg", "img1/claustral.jpg"}
+	PackFiles(t, testpack1, list)
+	defer os.Remove(testpack1)
+
+	var check = func(t *testing.T, fpath string, opts auto.Options) {
+		var pkg *wpk.Package
+		if pkg, err = auto.OpenPackage(fpath, opts); err != nil {
+			t.Fatal(err)
+		}
+		for _, fkey := range list {
+			var orig, data []byte
+			if orig, err = os.ReadFile(mediadir + fkey); err != nil {
+				t.Fatal(err)
+			}
+			if data, err = fs.ReadFile(pkg, fkey); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(orig, data) {
+				t.Fatalf("content of '%s' is not equal to original", fkey)
+			}
+		}
+		if err = pkg.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mode := range []auto.Mode{"", auto.ModeAuto, auto.ModeBulk, auto.ModeMmap, auto.ModeShare, auto.ModeFsys} {
+		t.Run("mode-"+string(mode), func(t *testing.T) {
+			check(t, testpack1, auto.Options{Mode: mode})
+		})
+	}
+	t.Run("threshold", func(t *testing.T) {
+		check(t, testpack1, auto.Options{Threshold: 1, Window: 4096})
+	})
+	t.Run("badmode", func(t *testing.T) {
+		if _, err = auto.OpenPackage(testpack1, auto.Options{Mode: "foo"}); !errors.Is(err, auto.ErrMode) {
+			t.Fatalf("expected mode error, got %v", err)
+		}
+	})
+
+	// splitted package opened by any of its files
+	t.Run("split", func(t *testing.T) {
+		var fwpt, fwpf *os.File
+		if fwpt, err = os.OpenFile(testpkgt, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(testpkgt)
+		defer fwpt.Close()
+		if fwpf, err = os.OpenFile(testpkgf, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(testpkgf)
+		defer fwpf.Close()
+
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+		for _, fkey := range list {
+			var f *os.File
+			if f, err = os.Open(mediadir + fkey); err != nil {
+				t.Fatal(err)
+			}
+			_, err = pkg.PackFile(fwpf, f, fkey)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = pkg.Sync(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+
+		check(t, testpkgt, auto.Options{Mode: auto.ModeShare})
+		check(t, testpkgf, auto.Options{})
+		check(t, testpack, auto.Options{Mode: auto.ModeFsys}) // ".wpk" does not exist
+	})
+}
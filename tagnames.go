@@ -0,0 +1,226 @@
+package wpk
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of tags values.
+const (
+	TTany = iota
+	TTbin
+	TTstr
+	TTbool
+	TTuint
+	TTnum
+	TTtime
+)
+
+// ISO8601 is time format acceptable for Date constructors.
+// See https://tc39.es/ecma262/#sec-date-time-string-format
+const ISO8601 = "2006-01-02T15:04:05.999Z07:00"
+
+// TidType helps to convert raw tags to typed values.
+var TidType = map[TID]int{
+	TIDoffset: TTuint,
+	TIDsize:   TTuint,
+	TIDpath:   TTstr,
+	TIDfid:    TTuint,
+	TIDmtime:  TTtime,
+	TIDatime:  TTtime,
+	TIDctime:  TTtime,
+	TIDbtime:  TTtime,
+	TIDattr:   TTuint,
+	TIDmime:   TTstr,
+
+	TIDcrc32ieee: TTbin,
+	TIDcrc32c:    TTbin,
+	TIDcrc32k:    TTbin,
+	TIDcrc64iso:  TTbin,
+
+	TIDmd5:    TTbin,
+	TIDsha1:   TTbin,
+	TIDsha224: TTbin,
+	TIDsha256: TTbin,
+	TIDsha384: TTbin,
+	TIDsha512: TTbin,
+
+	TIDtmbjpeg:  TTbin,
+	TIDtmbwebp:  TTbin,
+	TIDlabel:    TTstr,
+	TIDlink:     TTstr,
+	TIDkeywords: TTstr,
+	TIDcategory: TTstr,
+	TIDversion:  TTstr,
+	TIDauthor:   TTstr,
+	TIDcomment:  TTstr,
+	TIDhardlink: TTstr,
+	TIDxattr:    TTbin,
+}
+
+// NameTid helps convert string names of tags to associated TID values.
+var NameTid = map[string]TID{
+	"offset": TIDoffset,
+	"size":   TIDsize,
+	"path":   TIDpath,
+	"fid":    TIDfid,
+	"mtime":  TIDmtime,
+	"atime":  TIDatime,
+	"ctime":  TIDctime,
+	"btime":  TIDbtime,
+	"attr":   TIDattr,
+	"mime":   TIDmime,
+
+	"crc32":     TIDcrc32c,
+	"crc32ieee": TIDcrc32ieee,
+	"crc32c":    TIDcrc32c,
+	"crc32k":    TIDcrc32k,
+	"crc64":     TIDcrc64iso,
+	"crc64iso":  TIDcrc64iso,
+
+	"md5":    TIDmd5,
+	"sha1":   TIDsha1,
+	"sha224": TIDsha224,
+	"sha256": TIDsha256,
+	"sha384": TIDsha384,
+	"sha512": TIDsha512,
+
+	"tmbjpeg":  TIDtmbjpeg,
+	"tmbwebp":  TIDtmbwebp,
+	"label":    TIDlabel,
+	"link":     TIDlink,
+	"keywords": TIDkeywords,
+	"category": TIDcategory,
+	"version":  TIDversion,
+	"author":   TIDauthor,
+	"comment":  TIDcomment,
+	"hardlink": TIDhardlink,
+	"xattr":    TIDxattr,
+}
+
+// TidName helps format tags with string names associated to TID values.
+var TidName = func() map[TID]string {
+	var tn = map[TID]string{}
+	for name, tid := range NameTid {
+		tn[tid] = name
+	}
+	return tn
+}()
+
+// ErrTagVal is error on tag value string presentation.
+var ErrTagVal = errors.New("tag value can not be converted to tag type")
+
+// ParseTid returns tag identifier by its name or decimal number.
+func ParseTid(name string) (TID, bool) {
+	if tid, ok := NameTid[name]; ok {
+		return tid, true
+	}
+	if n, err := strconv.ParseUint(name, 10, 16); err == nil {
+		return TID(n), true
+	}
+	return TIDnone, false
+}
+
+// ParseSize converts string with unsigned integer to number.
+// Integer can be followed by size suffix K, M, G, T, with optional
+// B or iB, that multiplies value by appropriate power of 1024.
+func ParseSize(s string) (uint, error) {
+	var mul uint = 1
+	var u = strings.TrimSuffix(strings.TrimSuffix(ToUpper(s), "B"), "I")
+	if l := len(u); l > 0 {
+		switch u[l-1] {
+		case 'K':
+			mul = 1 << 10
+		case 'M':
+			mul = 1 << 20
+		case 'G':
+			mul = 1 << 30
+		case 'T':
+			mul = 1 << 40
+		}
+		if mul > 1 {
+			u = u[:l-1]
+		}
+	}
+	var n, err = strconv.ParseUint(u, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(n) * mul, nil
+}
+
+// ParseTime converts string in ISO8601 format, or date only,
+// to time value.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(ISO8601, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// ParseTag converts string to tag with given ID by its type.
+func ParseTag(tid TID, s string) (tag TagRaw, err error) {
+	switch TidType[tid] {
+	case TTbin:
+		return hex.DecodeString(s)
+	case TTbool:
+		var val bool
+		if val, err = strconv.ParseBool(s); err != nil {
+			return
+		}
+		return BoolTag(val), nil
+	case TTuint:
+		var val uint
+		if val, err = ParseSize(s); err != nil {
+			return
+		}
+		return UintTag(val), nil
+	case TTnum:
+		var val float64
+		if val, err = strconv.ParseFloat(s, 64); err != nil {
+			return
+		}
+		return NumberTag(val), nil
+	case TTtime:
+		var val time.Time
+		if val, err = ParseTime(s); err != nil {
+			return
+		}
+		return TimeTag(val), nil
+	default: // TTany, TTstr
+		return StrTag(s), nil
+	}
+}
+
+// FormatTag returns string presentation of tag with given ID by its type.
+func FormatTag(tid TID, tag TagRaw) (string, error) {
+	switch TidType[tid] {
+	case TTbin:
+		return hex.EncodeToString(tag), nil
+	case TTbool:
+		if val, ok := tag.TagBool(); ok {
+			return strconv.FormatBool(val), nil
+		}
+	case TTuint:
+		if val, ok := tag.TagUint(); ok {
+			return strconv.FormatUint(uint64(val), 10), nil
+		}
+	case TTnum:
+		if val, ok := tag.TagNumber(); ok {
+			return strconv.FormatFloat(val, 'g', -1, 64), nil
+		}
+	case TTtime:
+		if val, ok := tag.TagTime(); ok {
+			return val.UTC().Format(ISO8601), nil
+		}
+	default: // TTany, TTstr
+		return B2S(tag), nil
+	}
+	return "", fmt.Errorf("tag ID %d: %w", tid, ErrTagVal)
+}
+
+// The End.
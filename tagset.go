@@ -315,8 +315,8 @@ func (ts TagsetRaw) SetOk(tid TID, tag TagRaw) (TagsetRaw, bool) {
 	if tl == uint16(tsi.pos-tsi.tag) {
 		copy(ts[tsi.tag:tsi.pos], tag)
 	} else {
-		SetU16(ts[tsi.tag-PTStagsz:tsi.tag], tl) // set tag length
-		var suff = ts[tsi.pos:]
+		SetU16(ts[tsi.tag-PTStagsz:tsi.tag], tl)     // set tag length
+		var suff = append([]byte{}, ts[tsi.pos:]...) // copy to prevent overlap on append
 		ts = append(ts[:tsi.tag], tag...)
 		ts = append(ts, suff...)
 	}
@@ -125,6 +125,31 @@ func TestTagset(t *testing.T) {
 	assert(ts.Num() == 4, "number of tags after repeated delete 'mime' must be unchanged")
 }
 
+// Test that replacing tag by longer one keeps the following tags
+// when tagset has enough capacity to be appended in place.
+func TestTagsetSetLonger(t *testing.T) {
+	var ts = append(make(wpk.TagsetRaw, 0, 256), wpk.TagsetRaw{}.
+		Put(wpk.TIDpath, wpk.StrTag("a.txt")).
+		Put(wpk.TIDfid, wpk.UintTag(100)).
+		Put(wpk.TIDmime, wpk.StrTag("text/plain"))...)
+	var ok bool
+	if ts, ok = ts.SetOk(wpk.TIDpath, wpk.StrTag("some/longer/name.txt")); ok {
+		t.Fatal("content of 'path' tag should be replaced by 'Set'")
+	}
+	if ts.Num() != 3 {
+		t.Fatalf("number of tags after replace is %d, expected 3", ts.Num())
+	}
+	if fkey, _ := ts.TagStr(wpk.TIDpath); fkey != "some/longer/name.txt" {
+		t.Fatalf("'path' tag is '%s'", fkey)
+	}
+	if fid, _ := ts.TagUint(wpk.TIDfid); fid != 100 {
+		t.Fatalf("'fid' tag following replaced one is broken: %d", fid)
+	}
+	if mime, _ := ts.TagStr(wpk.TIDmime); mime != "text/plain" {
+		t.Fatalf("'mime' tag following replaced one is broken: '%s'", mime)
+	}
+}
+
 func ExampleTagsetIterator_Next() {
 	var ts = wpk.TagsetRaw{}.
 		Put(wpk.TIDpath, wpk.StrTag("picture.jpg")).
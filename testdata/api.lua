@@ -108,6 +108,13 @@ to build wpk-packages.
 		signed by 'secret' key.
 	sha512 - get/set mode to put for each new file tag with SHA512-hash of file,
 		signed by 'secret' key.
+	hardlinks - get/set mode to detect hard links at 'putfile' calls. File that
+		is hard link to already packed file is put as alias to it without data copy,
+		with 'hardlink' tag that contains name of the first file.
+	xattrs - get/set mode to put for each new file at 'putfile' call tag with
+		extended attributes of file, on Linux only.
+	safeappend - get/set mode to keep previous tags table of package in single
+		file on 'append', so package stays readable if appending was interrupted.
 
 	methods:
 	load(pkgpath, datpath) - read allocation table and tags table by specified
@@ -121,7 +128,9 @@ to build wpk-packages.
 		Splitted package can be used after each update by 'flush' during writing.
 		If package with given path is already exist, it will be rewritten.
 	append() - start to append new files to already existing package, opened by
-		previous call to 'load'. Package can not be used until writing will be 'finalize'.
+		previous call to 'load'. If 'safeappend' mode is set, package keeps its previous
+		state until writing will be 'finalize', so it stays readable if appending was
+		interrupted.
 	finalize() - write allocation table and tags table, and finalize package writing.
 	flush() - only for splitted package writes allocation table and tags table,
 		and continue common files writing workflow.
@@ -129,6 +138,10 @@ to build wpk-packages.
 		data, so sumsize can be more then datasize.
 	glob(pattern) - returns the names of all files in package matching pattern or nil
 		if there is no matching file.
+	query(expr) - returns the names of all files in package with tags satisfying to
+		query expression, or nil if there is no such file. Expression is conditions
+		on tags joined by 'and', 'or', 'not' and parentheses, for example:
+		"keywords contains beach and mime starts with image/ and size > 1MB".
 	hasfile(fkey) - check up file name existence in tags table.
 	filesize(fkey) - return record size of specified file name.
 	putdata(fkey, data, tags) - write file with specified as string 'data' content,
@@ -230,6 +243,8 @@ to build wpk-packages.
 	version 	114	string
 	author  	115	string
 	comment 	116	string
+	hardlink	117	string
+	xattr   	118	hex string
 
 ]]
 
@@ -0,0 +1,38 @@
+
+--[[
+Script with sample of incremental package building. On first run
+it creates new package, on next runs it packs only new and changed
+files, and deletes files that are not put anymore. Package is
+compacted if unused space exceeds the half of data.
+]]
+
+local pkgpath = path.join(tmpdir, "update.wpk") -- make package full file name on temporary directory
+
+-- inits new package
+local pkg = wpk.new()
+pkg.automime = true -- put MIME type for each file if it is not given explicit
+pkg.secret = "package-private-key" -- private key to sign cryptographic hashes for each file
+pkg.sha256 = true -- generate SHA256 hash for each file
+pkg.hashcmp = true -- compare files content by hash if modification time differs
+pkg.ratio = 0.5 -- compact package if more than half of data is unused
+
+-- open existing package to update, or begin new one
+pkg:update(pkgpath)
+log("starts: "..pkgpath)
+
+local mediadir = path.join(scrdir, "media").."/"
+pkg:putfile("bounty.jpg", mediadir.."bounty.jpg", {keywords = "beach"})
+pkg:putfile("claustral.jpg", mediadir.."img1/claustral.jpg", {keywords = "beach;rock"})
+pkg:putfile("marble.jpg", mediadir.."img2/marble.jpg", {keywords = "beach"})
+pkg:putdata("sample.txt", "The quick brown fox jumps over the lazy dog", {
+	mime = "text/plain;charset=utf-8",
+	keywords = "fox;dog",
+})
+pkg:putalias("claustral.jpg", "jasper.jpg")
+
+log(string.format("packaged: %d files to %d aliases, data %d bytes", pkg.recnum, pkg.tagnum, pkg.datasize))
+
+-- write tags table, delete files that were not put, and finalize wpk-file
+pkg:finalize()
+
+log "done."
@@ -0,0 +1,153 @@
+package wpk
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// UpdateOpts is the set of options for incremental update of package.
+type UpdateOpts struct {
+	PackOpts       // options to pack new and changed files
+	CheckHash bool // compare content by stored hash if it's present
+	Delete    bool // delete files of package that are absent in sources
+}
+
+// UpdateReport is the list of changes made by incremental update.
+type UpdateReport struct {
+	Added     []string // files that were absent in package
+	Updated   []string // files with changed content
+	Deleted   []string // files that are absent in sources
+	Unchanged int      // number of files that were left as is
+}
+
+// Update puts into package new and changed files from given sources,
+// and skips unchanged files. Package should be opened and prepared
+// by Append call before.
+func (pkg *Package) Update(w io.WriteSeeker, list []PackSource, opts UpdateOpts) (*UpdateReport, error) {
+	return pkg.UpdateCtx(context.Background(), w, list, opts)
+}
+
+// UpdateCtx is Update with context. Each source is compared to the packed
+// file by size and modification time, and optionally by stored hash, see
+// SyncHashTIDs. If modification time differs, but hash is the same, file
+// is considered unchanged. Sizes are not compared if Transform is given.
+// Sources without file path can be compared only by hash. Changed files
+// are replaced by new data, so previous data remains in package as unused
+// until compaction. Tags of unchanged files are left as is. On error or
+// cancellation changed files that were not written keep previous tagsets.
+func (pkg *Package) UpdateCtx(ctx context.Context, w io.WriteSeeker, list []PackSource, opts UpdateOpts) (rep *UpdateReport, err error) {
+	rep = &UpdateReport{}
+	var keep = make(map[string]Void, len(list))
+	var pack []PackSource
+	var updated = map[string]TagsetRaw{} // previous tagsets of changed files
+	for i := range list {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var src = &list[i]
+		keep[ToSlash(src.FKey)] = Void{}
+		var ts, ok = pkg.GetTagset(src.FKey)
+		if ok {
+			var same bool
+			if same, err = src.unchanged(ctx, ts, &opts); err != nil {
+				return
+			}
+			if same {
+				rep.Unchanged++
+				continue
+			}
+			updated[src.FKey] = ts
+		}
+		pack = append(pack, *src)
+	}
+
+	if opts.Delete {
+		pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+			if _, ok := keep[fkey]; !ok {
+				rep.Deleted = append(rep.Deleted, fkey)
+			}
+			return true
+		})
+		for _, fkey := range rep.Deleted {
+			pkg.DelTagset(fkey)
+		}
+		sort.Strings(rep.Deleted)
+	}
+
+	for _, src := range pack {
+		if _, ok := updated[src.FKey]; ok {
+			pkg.DelTagset(src.FKey)
+		}
+	}
+	var n int
+	if n, err = pkg.PackPipelineCtx(ctx, w, pack, opts.PackOpts); err != nil {
+		for _, src := range pack[n:] {
+			if ts, ok := updated[src.FKey]; ok && !pkg.HasTagset(src.FKey) {
+				pkg.SetTagset(src.FKey, ts)
+			}
+		}
+	}
+	for _, src := range pack[:n] {
+		if _, ok := updated[src.FKey]; ok {
+			rep.Updated = append(rep.Updated, src.FKey)
+		} else {
+			rep.Added = append(rep.Added, src.FKey)
+		}
+	}
+	return
+}
+
+// unchanged checks up that source has the same content as packed file.
+func (src *PackSource) unchanged(ctx context.Context, ts TagsetRaw, opts *UpdateOpts) (bool, error) {
+	var sametime bool
+	if src.Open == nil {
+		var fi, err = os.Stat(src.FPath)
+		if err != nil {
+			return false, err
+		}
+		if opts.Transform == nil && fi.Size() != ts.Size() {
+			return false, nil
+		}
+		if mtime, ok := ts.TagTime(TIDmtime); ok {
+			sametime = fi.ModTime().Truncate(time.Millisecond).Equal(mtime.Truncate(time.Millisecond))
+		}
+		if sametime && !opts.CheckHash {
+			return true, nil
+		}
+	}
+	if !opts.CheckHash {
+		return false, nil
+	}
+
+	for _, tid := range SyncHashTIDs {
+		if tag, ok := ts.Get(tid); ok {
+			var r io.ReadCloser
+			var err error
+			if src.Open != nil {
+				r, err = src.Open()
+			} else {
+				r, err = os.Open(src.FPath)
+			}
+			if err != nil {
+				return false, err
+			}
+			defer r.Close()
+			var h = NewHash(tid, opts.Secret)
+			var n int64
+			if n, err = io.Copy(h, ctxreader{ctx, r}); err != nil {
+				return false, err
+			}
+			if opts.Transform == nil && n != ts.Size() {
+				return false, nil
+			}
+			return bytes.Equal(h.Sum(nil), tag), nil
+		}
+	}
+	return sametime, nil
+}
+
+// The End.
@@ -0,0 +1,300 @@
+package wpk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+// Test incremental update of package and compaction of its file.
+func TestUpdate(t *testing.T) {
+	var err error
+	var dir = t.TempDir()
+	var pkgpath = path.Join(dir, "update.wpk")
+	var srcdir = path.Join(dir, "src")
+	var mtime = time.Now().Add(-time.Hour).Truncate(time.Second)
+	var write = func(name string, data []byte) {
+		t.Helper()
+		var fpath = path.Join(srcdir, name)
+		if err = os.MkdirAll(path.Dir(fpath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fpath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(fpath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var update = func(opts wpk.UpdateOpts, expect wpk.UpdateReport) *wpk.Package {
+		t.Helper()
+		var list []wpk.PackSource
+		if list, err = wpk.ListDir(context.Background(), srcdir, "", wpk.DirOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+		var pkg = wpk.NewPackage()
+		if fi, _ := fwpk.Stat(); fi.Size() == 0 {
+			err = pkg.Begin(fwpk, nil)
+		} else if err = pkg.OpenStream(fwpk); err == nil {
+			err = pkg.Append(fwpk, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rep *wpk.UpdateReport
+		if rep, err = pkg.Update(fwpk, list, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*rep, expect) {
+			t.Fatalf("unexpected report %+v, expected %+v", *rep, expect)
+		}
+		return pkg
+	}
+
+	var opts = wpk.UpdateOpts{
+		PackOpts: wpk.PackOpts{
+			Hashes: []wpk.TID{wpk.TIDsha256},
+		},
+		Delete: true,
+	}
+	write("sample.txt", memdata["sample.txt"])
+	write("data/array.dat", memdata["array.dat"])
+	write("data/old.dat", memdata["array.dat"])
+	update(opts, wpk.UpdateReport{
+		Added: []string{"data/array.dat", "data/old.dat", "sample.txt"},
+	})
+	update(opts, wpk.UpdateReport{
+		Unchanged: 3,
+	})
+
+	// change content with the same size and time to find it by hash
+	var data = append([]byte{}, memdata["array.dat"]...)
+	data[0] = 255
+	write("data/array.dat", data)
+	update(opts, wpk.UpdateReport{
+		Unchanged: 3,
+	})
+	opts.CheckHash = true
+	if err = os.Remove(path.Join(srcdir, "data/old.dat")); err != nil {
+		t.Fatal(err)
+	}
+	write("new.txt", []byte("new file"))
+	var pkg = update(opts, wpk.UpdateReport{
+		Added:     []string{"new.txt"},
+		Updated:   []string{"data/array.dat"},
+		Deleted:   []string{"data/old.dat"},
+		Unchanged: 1,
+	})
+
+	var waste, total = pkg.Waste()
+	if waste == 0 || waste >= total {
+		t.Fatalf("unexpected unused space %d of %d bytes", waste, total)
+	}
+	if err = wpk.CompactFile(context.Background(), pkgpath, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+	if waste, _ = pkg.Waste(); waste != 0 {
+		t.Fatalf("compacted package has %d unused bytes", waste)
+	}
+	for fkey, orig := range map[string][]byte{
+		"sample.txt":     memdata["sample.txt"],
+		"data/array.dat": data,
+		"new.txt":        []byte("new file"),
+	} {
+		var b []byte
+		if b, err = pkg.ReadFile(fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, orig) {
+			t.Fatalf("content of file '%s' is not equal to original", fkey)
+		}
+	}
+	if pkg.TagsetNum() != 3 {
+		t.Fatalf("expected 3 files in compacted package, got %d", pkg.TagsetNum())
+	}
+}
+
+// Test that cancelled update keeps previous tagsets of not written files.
+func TestUpdateCancel(t *testing.T) {
+	var err error
+	var pkgpath = path.Join(t.TempDir(), "cancel.wpk")
+	var keys = []string{"a.txt", "b.txt", "c.txt"}
+	var fwpk *os.File
+	if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer fwpk.Close()
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	var prev = map[string]wpk.TagsetRaw{}
+	for _, fkey := range keys {
+		if prev[fkey], err = pkg.PackData(fwpk, bytes.NewReader(memdata["sample.txt"]), fkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = pkg.Append(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// sources without path are always changed, the second one cancels update
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var list []wpk.PackSource
+	for i, fkey := range keys {
+		var i = i
+		list = append(list, wpk.PackSource{
+			FKey: fkey,
+			Open: func() (io.ReadCloser, error) {
+				if i == 1 {
+					cancel()
+					return nil, context.Canceled
+				}
+				return io.NopCloser(bytes.NewReader(memdata["array.dat"])), nil
+			},
+		})
+	}
+	var rep *wpk.UpdateReport
+	if rep, err = pkg.UpdateCtx(ctx, fwpk, list, wpk.UpdateOpts{
+		PackOpts: wpk.PackOpts{Workers: 1},
+	}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("update is not cancelled, error: %v", err)
+	}
+	if len(rep.Updated) > 1 {
+		t.Fatalf("files after cancellation are updated: %v", rep.Updated)
+	}
+	for i, fkey := range keys {
+		var ts, ok = pkg.GetTagset(fkey)
+		if !ok {
+			t.Fatalf("file '%s' is lost after cancellation", fkey)
+		}
+		if i >= len(rep.Updated) && !bytes.Equal(ts, prev[fkey]) {
+			t.Fatalf("file '%s' is not written, but its tagset is changed", fkey)
+		}
+	}
+}
+
+// Test that failed compaction of splitted package keeps original files.
+func TestCompactFileRollback(t *testing.T) {
+	var err error
+	var dir = t.TempDir()
+	var pkgpath = path.Join(dir, "split.wpt")
+	var datpath = path.Join(dir, "split.wpf")
+	func() {
+		var fwpt, fwpf *os.File
+		if fwpt, err = os.Create(pkgpath); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpt.Close()
+		if fwpf, err = os.Create(datpath); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpf.Close()
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+		for fkey, data := range memdata {
+			if _, err = pkg.PackData(fwpf, bytes.NewReader(data), fkey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = pkg.Sync(fwpt, fwpf); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	var origt, origf []byte
+	if origt, err = os.ReadFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if origf, err = os.ReadFile(datpath); err != nil {
+		t.Fatal(err)
+	}
+
+	// tags table can not be placed if directory is at its path
+	if err = wpk.CompactFile(context.Background(), pkgpath, datpath, func(p wpk.Progress) {
+		if p.Files > 0 && p.Files == p.FilesTotal {
+			if err := os.Remove(pkgpath); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(path.Join(pkgpath, "busy"), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}); err == nil {
+		t.Fatal("tags table was placed over directory")
+	}
+	var b []byte
+	if b, err = os.ReadFile(datpath); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, origf) {
+		t.Fatal("data file is not rolled back")
+	}
+	for _, fpath := range []string{pkgpath + ".tmp", datpath + ".tmp", datpath + wpk.CompactOldExt} {
+		if _, err = os.Stat(fpath); err == nil {
+			t.Fatalf("temporary file '%s' is left", fpath)
+		}
+	}
+
+	// compaction succeeds when the path is free
+	if err = os.RemoveAll(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(pkgpath, origt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = wpk.CompactFile(context.Background(), pkgpath, datpath, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(datpath + wpk.CompactOldExt); err == nil {
+		t.Fatal("original data file is left after compaction")
+	}
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(datpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+	for fkey, orig := range memdata {
+		if b, err = pkg.ReadFile(fkey); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, orig) {
+			t.Fatalf("content of file '%s' is not equal to original", fkey)
+		}
+	}
+}
+
+// The End.
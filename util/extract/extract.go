@@ -1,18 +1,16 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
-	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
 
 	"github.com/schwarzlichtbezirk/wpk"
-	"github.com/schwarzlichtbezirk/wpk/bulk"
-	"github.com/schwarzlichtbezirk/wpk/fsys"
-	"github.com/schwarzlichtbezirk/wpk/mmap"
+	"github.com/schwarzlichtbezirk/wpk/auto"
 )
 
 // command line settings
@@ -24,12 +22,18 @@ var (
 	OrgTime bool
 	ShowLog bool
 	PkgMode string
-)
-
-var pkg = wpk.NewPackage()
-
-var (
-	ErrNoWay = errors.New("no way to here")
+	ArcFmt  string
+	Include string
+	Exclude string
+	SafeArg string
+	OverArg string
+	Workers int
+	HardLnk bool
+	Xattrs  bool
+	SyncDir bool
+	Delete  bool
+	DryRun  bool
+	Secret  string
 )
 
 func parseargs() {
@@ -38,10 +42,32 @@ func parseargs() {
 	flag.BoolVar(&MkDst, "md", false, "create destination path if it does not exist")
 	flag.BoolVar(&OrgTime, "ft", false, "change the access and modification times of extracted files to original file times")
 	flag.BoolVar(&ShowLog, "sl", true, "show process log for each extracting file")
-	flag.StringVar(&PkgMode, "pm", "mmap", "package opening mode, can be \"bulk\", \"mmap\" and \"fsys\"")
+	flag.StringVar(&PkgMode, "pm", "mmap", "package opening mode, can be \"auto\", \"bulk\", \"mmap\", \"share\" and \"fsys\"")
+	flag.StringVar(&ArcFmt, "arc", "", "write files of all packages to stdout as archive instead of destination path, can be \"zip\", \"tar\" and \"tgz\"")
+	flag.StringVar(&Include, "inc", "", "glob patterns of files to extract divided by ';', all files if it's empty")
+	flag.StringVar(&Exclude, "exc", "", "glob patterns of files to skip divided by ';'")
+	flag.StringVar(&SafeArg, "safe", "reject", "what to do with file names that refers outside of destination path, can be \"reject\", \"skip\" and \"rewrite\"")
+	flag.StringVar(&OverArg, "ow", "always", "what to do with existing files, can be \"always\", \"skip\" and \"newer\"")
+	flag.IntVar(&Workers, "workers", 0, "number of parallel workers to write files, number of CPUs if it's zero")
+	flag.BoolVar(&HardLnk, "hl", false, "restore hard links between files that were packed as hard links, instead of data copy")
+	flag.BoolVar(&Xattrs, "xattr", false, "restore extended attributes of files stored at package, on Linux only, attributes that can not be set for lack of permissions or file system support are skipped with warning")
+	flag.BoolVar(&SyncDir, "sync", false, "synchronize destination path with package, write only new and changed files")
+	flag.BoolVar(&Delete, "del", false, "on synchronization delete files of destination path that are not present in package")
+	flag.BoolVar(&DryRun, "dry", false, "on synchronization only show changes, do not write anything")
+	flag.StringVar(&Secret, "secret", "", "private key to compare MD5 and SHA hashes on synchronization")
 	flag.Parse()
 }
 
+// splitlist returns not empty elements of list divided by ';'.
+func splitlist(list string) (res []string) {
+	for _, s := range strings.Split(list, ";") {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return
+}
+
 func checkargs() int {
 	var ec = 0 // error counter
 
@@ -62,12 +88,18 @@ func checkargs() int {
 		ec++
 	}
 
+	if _, ok := ArcFormats[ArcFmt]; !ok && ArcFmt != "" {
+		log.Println("given archive format does not supported")
+		ec++
+	}
+
 	DstPath = wpk.ToSlash(wpk.Envfmt(DstPath, nil))
-	if DstPath == "" {
+	if ArcFmt != "" {
+		// destination path is not used on archive writing
+	} else if DstPath == "" {
 		log.Println("destination path does not specified")
 		ec++
-	}
-	if ok, _ := wpk.DirExists(DstPath); !ok {
+	} else if ok, _ := wpk.DirExists(DstPath); !ok {
 		if MkDst {
 			if err := os.MkdirAll(DstPath, os.ModePerm); err != nil {
 				log.Println(err.Error())
@@ -79,7 +111,31 @@ func checkargs() int {
 		}
 	}
 
-	if PkgMode != "bulk" && PkgMode != "mmap" && PkgMode != "fsys" {
+	for _, pattern := range append(splitlist(Include), splitlist(Exclude)...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Printf("glob pattern '%s' is malformed", pattern)
+			ec++
+		}
+	}
+	if _, ok := wpk.PathPolicies[SafeArg]; !ok {
+		log.Println("given policy for unsafe file names does not supported")
+		ec++
+	}
+	if _, ok := wpk.OverwritePolicies[OverArg]; !ok {
+		log.Println("given policy for existing files does not supported")
+		ec++
+	}
+
+	if SyncDir && ArcFmt != "" {
+		log.Println("synchronization can not be used with archive writing")
+		ec++
+	}
+	if SyncDir && Delete && len(SrcList) > 1 {
+		log.Println("files deletion on synchronization can be used only with single package")
+		ec++
+	}
+
+	if _, err := auto.ParseMode(PkgMode); err != nil {
 		log.Println("given package opening type does not supported")
 		ec++
 	}
@@ -87,89 +143,104 @@ func checkargs() int {
 	return ec
 }
 
-func openpackage(pkgpath string) (err error) {
-	if err = pkg.OpenFile(pkgpath); err != nil {
-		return
-	}
-	var fpath string
-	if pkg.IsSplitted() {
-		fpath = wpk.MakeDataPath(pkgpath)
-	} else {
-		fpath = pkgpath
-	}
-	switch PkgMode {
-	case "bulk":
-		if pkg.Tagger, err = bulk.MakeTagger(fpath); err != nil {
-			return
-		}
-	case "mmap":
-		if pkg.Tagger, err = mmap.MakeTagger(fpath); err != nil {
-			return
-		}
-	case "fsys":
-		if pkg.Tagger, err = fsys.MakeTagger(fpath); err != nil {
+// ArcFormats is the set of archive formats to write by "arc" flag.
+var ArcFormats = map[string]wpk.ArcFormat{
+	"zip": wpk.ArcZip,
+	"tar": wpk.ArcTar,
+	"tgz": wpk.ArcTgz,
+}
+
+func openpackage(pkgpath string) (*wpk.Package, error) {
+	return auto.OpenPackage(pkgpath, auto.Options{
+		Mode: auto.Mode(PkgMode),
+	})
+}
+
+func readpackage(ctx context.Context) (err error) {
+	log.Printf("destination path: %s", DstPath)
+
+	for _, pkgpath := range SrcList {
+		log.Printf("source package: %s", pkgpath)
+		func() {
+			var pkg *wpk.Package
+			if pkg, err = openpackage(pkgpath); err != nil {
+				return
+			}
+			defer pkg.Close()
+
+			var prg wpk.Progress
+			var opts = wpk.ExtractOpts{
+				OrgTime:   OrgTime,
+				Include:   splitlist(Include),
+				Exclude:   splitlist(Exclude),
+				Path:      wpk.PathPolicies[SafeArg],
+				Overwrite: wpk.OverwritePolicies[OverArg],
+				Workers:   Workers,
+				HardLinks: HardLnk,
+				Xattrs:    Xattrs,
+				XattrSkip: func(fkey string, names []string) {
+					log.Printf("extended attributes of '%s' are not restored: %s", fkey, strings.Join(names, ", "))
+				},
+				Progress: func(p wpk.Progress) {
+					if ShowLog && p.Files > prg.Files {
+						log.Printf("#%-3d %6d bytes   %s", p.Files, p.Bytes-prg.Bytes, p.FKey)
+					}
+					prg = p
+				},
+			}
+			if err = pkg.ExtractCtx(ctx, DstPath, opts); err != nil {
+				return
+			}
+			var num, sum = prg.Files, prg.Bytes
+			log.Printf("unpacked: %d files on %d bytes", num, sum)
+		}()
+		if err != nil {
 			return
 		}
-	default:
-		panic(ErrNoWay)
 	}
+
 	return
 }
 
-func readpackage() (err error) {
+func syncpackage(ctx context.Context) (err error) {
 	log.Printf("destination path: %s", DstPath)
 
 	for _, pkgpath := range SrcList {
 		log.Printf("source package: %s", pkgpath)
 		func() {
-			if err = openpackage(pkgpath); err != nil {
+			var pkg *wpk.Package
+			if pkg, err = openpackage(pkgpath); err != nil {
 				return
 			}
 			defer pkg.Close()
 
-			var num, sum int64
-			pkg.Enum(func(fkey string, ts wpk.TagsetRaw) (next bool) {
-				defer func() {
-					next = err == nil
-				}()
-
-				var fullpath = wpk.JoinPath(DstPath, fkey)
-				if err = os.MkdirAll(path.Dir(fullpath), os.ModePerm); err != nil {
-					return
-				}
-
-				var src wpk.RFile
-				if src, err = pkg.OpenTagset(ts); err != nil {
-					return
-				}
-				defer src.Close()
-
-				var dst io.WriteCloser
-				if dst, err = os.OpenFile(fullpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755); err != nil {
-					return
+			var opts = wpk.SyncOpts{
+				Include: splitlist(Include),
+				Exclude: splitlist(Exclude),
+				Path:    wpk.PathPolicies[SafeArg],
+				Delete:  Delete,
+				DryRun:  DryRun,
+				Secret:  []byte(Secret),
+				Workers: Workers,
+			}
+			var rep *wpk.SyncReport
+			rep, err = pkg.SyncDirCtx(ctx, DstPath, opts)
+			if rep != nil && ShowLog {
+				for _, fkey := range rep.Added {
+					log.Printf("added:   %s", fkey)
 				}
-				if OrgTime {
-					var atime, aok = ts.TagTime(wpk.TIDatime)
-					var mtime, mok = ts.TagTime(wpk.TIDmtime)
-					if aok && mok {
-						defer os.Chtimes(fullpath, atime, mtime)
-					}
+				for _, fkey := range rep.Updated {
+					log.Printf("updated: %s", fkey)
 				}
-				defer dst.Close()
-
-				var n int64
-				if n, err = io.Copy(dst, src); err != nil {
-					return
-				}
-				num++
-				sum += n
-
-				if ShowLog {
-					log.Printf("#%-3d %6d bytes   %s", num, n, fkey)
+				for _, fkey := range rep.Deleted {
+					log.Printf("deleted: %s", fkey)
 				}
+			}
+			if err != nil {
 				return
-			})
-			log.Printf("unpacked: %d files on %d bytes", num, sum)
+			}
+			log.Printf("synchronized: %d added, %d updated, %d deleted, %d unchanged files, %d bytes written",
+				len(rep.Added), len(rep.Updated), len(rep.Deleted), rep.Unchanged, rep.Bytes)
 		}()
 		if err != nil {
 			return
@@ -179,14 +250,41 @@ func readpackage() (err error) {
 	return
 }
 
+func writearchive() (err error) {
+	var u wpk.Union
+	defer u.Close()
+	for _, pkgpath := range SrcList {
+		log.Printf("source package: %s", pkgpath)
+		var pkg *wpk.Package
+		if pkg, err = openpackage(pkgpath); err != nil {
+			return
+		}
+		u.List = append(u.List, pkg)
+	}
+
+	log.Printf("write %s archive to stdout", ArcFmt)
+	return wpk.ExportArchive(os.Stdout, &u, ArcFormats[ArcFmt])
+}
+
 func main() {
 	parseargs()
 	if checkargs() > 0 {
 		return
 	}
 
+	var ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
 	log.Println("starts")
-	if err := readpackage(); err != nil {
+	var err error
+	if ArcFmt != "" {
+		err = writearchive()
+	} else if SyncDir {
+		err = syncpackage(ctx)
+	} else {
+		err = readpackage(ctx)
+	}
+	if err != nil {
 		log.Println(err.Error())
 		return
 	}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// command line settings
+var (
+	srcfile string
+	SrcList []string
+	DstPath string
+	Hashes  bool
+	Secret  string
+	ShowAll bool
+)
+
+func parseargs() {
+	flag.StringVar(&srcfile, "src", "", "package full file name, or list of files divided by ';'")
+	flag.StringVar(&DstPath, "repair", "", "full file name of corrected package copy, only single source package can be repaired")
+	flag.BoolVar(&Hashes, "hash", false, "verify hashes of files content if they are present")
+	flag.StringVar(&Secret, "secret", "", "private key to verify MD5 and SHA hashes")
+	flag.BoolVar(&ShowAll, "all", false, "show findings with info severity")
+	flag.Parse()
+}
+
+func checkargs() int {
+	var ec = 0 // error counter
+
+	for i, fpath := range strings.Split(srcfile, ";") {
+		if fpath == "" {
+			continue
+		}
+		fpath = wpk.ToSlash(wpk.Envfmt(fpath, nil))
+		if ok, _ := wpk.FileExists(fpath); !ok {
+			log.Printf("source file #%d '%s' does not exist", i+1, fpath)
+			ec++
+			continue
+		}
+		SrcList = append(SrcList, fpath)
+	}
+	if len(srcfile) == 0 {
+		log.Println("package file does not specified")
+		ec++
+	}
+
+	DstPath = wpk.ToSlash(wpk.Envfmt(DstPath, nil))
+	if DstPath != "" && len(SrcList) > 1 {
+		log.Println("only single package can be repaired")
+		ec++
+	}
+
+	return ec
+}
+
+// report prints findings and returns the highest severity.
+func report(rep *wpk.FsckReport) wpk.Severity {
+	for _, f := range rep.Findings {
+		if f.Sev > wpk.SevInfo || ShowAll {
+			log.Println(f.String())
+		}
+	}
+	log.Printf("checked: %d files, %d data blocks, %d hashes", rep.Files, rep.Blocks, rep.Hashed)
+	return rep.Worst()
+}
+
+func run() (worst wpk.Severity, err error) {
+	var opts = wpk.FsckOpts{
+		Hashes: Hashes,
+		Secret: []byte(Secret),
+	}
+	for _, pkgpath := range SrcList {
+		log.Printf("source package: %s", pkgpath)
+		var rep *wpk.FsckReport
+		if DstPath != "" {
+			rep, err = wpk.RepairFile(DstPath, pkgpath, opts)
+		} else {
+			rep, err = wpk.FsckFile(pkgpath, opts)
+		}
+		if rep != nil {
+			if sev := report(rep); sev > worst {
+				worst = sev
+			}
+		}
+		if err != nil {
+			return
+		}
+		if DstPath != "" {
+			log.Printf("corrected copy: %s", DstPath)
+		}
+	}
+	return
+}
+
+func main() {
+	parseargs()
+	if checkargs() > 0 {
+		os.Exit(2)
+	}
+
+	log.Println("starts")
+	var worst, err = run()
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(2)
+	}
+	log.Println("done.")
+	if worst >= wpk.SevError {
+		os.Exit(1)
+	}
+}
+
+// The End.
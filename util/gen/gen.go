@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/auto"
+	lw "github.com/schwarzlichtbezirk/wpk/luawpk"
+)
+
+// command line settings
+var (
+	SrcFile  string
+	LuaFile  string
+	DstFile  string
+	PkgName  string
+	TypeName string
+	Prefix   string
+	VarName  string
+	Embed    bool
+)
+
+func parseargs() {
+	flag.StringVar(&SrcFile, "src", "", "package full file name, \".wpk\" or \".wpt\" file")
+	flag.StringVar(&LuaFile, "lua", "", "Lua build script to run before reading of package")
+	flag.StringVar(&DstFile, "dst", "wpkkeys.go", "output Go file name")
+	flag.StringVar(&PkgName, "pkg", os.Getenv("GOPACKAGE"), "name of Go package for output file, taken from go:generate environment if it's omitted")
+	flag.StringVar(&TypeName, "type", "AssetKey", "name of type for file keys")
+	flag.StringVar(&Prefix, "prefix", "Key", "prefix for names of keys constants")
+	flag.StringVar(&VarName, "var", "Assets", "name of variable with embedded package, and prefix for other declarations")
+	flag.BoolVar(&Embed, "embed", false, "embed package into binary, package files should be placed at output file directory or below")
+	flag.Parse()
+}
+
+func checkargs() int {
+	var ec = 0 // error counter
+
+	LuaFile = wpk.ToSlash(wpk.Envfmt(LuaFile, nil))
+	if LuaFile != "" {
+		if ok, _ := wpk.FileExists(LuaFile); !ok {
+			log.Printf("Lua script '%s' does not exist", LuaFile)
+			ec++
+		}
+	}
+
+	SrcFile = wpk.ToSlash(wpk.Envfmt(SrcFile, nil))
+	if SrcFile == "" {
+		log.Println("package file does not specified")
+		ec++
+	}
+
+	DstFile = wpk.ToSlash(wpk.Envfmt(DstFile, nil))
+	if DstFile == "" {
+		log.Println("output file does not specified")
+		ec++
+	}
+
+	if PkgName == "" {
+		PkgName = "main"
+	}
+	if !isident(PkgName) || !isident(TypeName) || !isident(VarName) {
+		log.Println("package, type and variable names should be valid Go identifiers")
+		ec++
+	}
+
+	return ec
+}
+
+// isident checks up that given string is valid Go identifier.
+func isident(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConstName makes name of constant for given file key. All characters
+// that can not be in identifier are treated as words separators, and
+// each word is capitalized, so "img1/claustral.jpg" turns to "Img1ClaustralJpg".
+func ConstName(fkey string) string {
+	var buf strings.Builder
+	buf.WriteString(Prefix)
+	var up = true
+	for _, c := range fkey {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if up {
+				c = unicode.ToUpper(c)
+				up = false
+			}
+			buf.WriteRune(c)
+		} else {
+			up = true
+		}
+	}
+	var name = buf.String()
+	if !isident(name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// KeyDecl is the declaration of constant with file key.
+type KeyDecl struct {
+	Name string
+	Key  string
+}
+
+// GenData is the data for output file template.
+type GenData struct {
+	Source  string
+	Package string
+	Type    string
+	Var     string
+	Keys    []KeyDecl
+	Sum     string
+	Embed   bool
+	Files   []string // embedded files relative to output directory
+	PkgFile string   // embedded file with tags table
+}
+
+var gentmpl = template.Must(template.New("gen").Funcs(template.FuncMap{
+	"quote": func(s string) string { return fmt.Sprintf("%q", s) },
+}).Parse(`// Code generated by wpk gen from {{quote .Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .Embed}}
+	"embed"
+{{- end}}
+	"fmt"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// {{.Type}} is the name of file in package.
+type {{.Type}} string
+
+// String returns file name.
+func (k {{.Type}}) String() string {
+	return string(k)
+}
+
+// Names of files in package.
+const (
+{{- range .Keys}}
+	{{.Name}} {{$.Type}} = {{quote .Key}}
+{{- end}}
+)
+
+// {{.Var}}Keys is the list of all files in package.
+var {{.Var}}Keys = []{{.Type}}{
+{{- range .Keys}}
+	{{.Name}},
+{{- end}}
+}
+
+// {{.Var}}KeysSum is the checksum of files names list in package.
+const {{.Var}}KeysSum = {{quote .Sum}}
+
+// Check{{.Var}} returns error if given package does not contain
+// exactly the files for which constants were generated.
+func Check{{.Var}}(pkg *wpk.Package) error {
+	if sum := pkg.KeysSum(); sum != {{.Var}}KeysSum {
+		return fmt.Errorf("package files does not match generated keys, checksum is %s, expected %s", sum, {{.Var}}KeysSum)
+	}
+	return nil
+}
+{{- if .Embed}}
+
+//go:embed{{range .Files}} {{quote .}}{{end}}
+var {{.Var}}FS embed.FS
+
+// {{.Var}} is the package embedded into binary.
+var {{.Var}} *wpk.Package
+
+func init() {
+	var err error
+	if {{.Var}}, err = wpk.OpenFS({{.Var}}FS, {{quote .PkgFile}}); err != nil {
+		panic(err)
+	}
+	if err = Check{{.Var}}({{.Var}}); err != nil {
+		panic(err)
+	}
+}
+{{- end}}
+`))
+
+// embedpath returns path of file relative to output file directory.
+func embedpath(fpath string) (string, error) {
+	var dir, err = filepath.Abs(filepath.Dir(DstFile))
+	if err != nil {
+		return "", err
+	}
+	var abs string
+	if abs, err = filepath.Abs(fpath); err != nil {
+		return "", err
+	}
+	var rel string
+	if rel, err = filepath.Rel(dir, abs); err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("file '%s' is not placed at output file directory", fpath)
+	}
+	return rel, nil
+}
+
+func generate() (err error) {
+	if LuaFile != "" {
+		log.Printf("run build script: %s", LuaFile)
+		if err = lw.RunLuaVM(LuaFile); err != nil {
+			return
+		}
+	}
+
+	log.Printf("source package: %s", SrcFile)
+	var pkg *wpk.Package
+	if pkg, err = auto.OpenPackage(SrcFile, auto.Options{Mode: auto.ModeFsys}); err != nil {
+		return
+	}
+	defer pkg.Close()
+
+	var data = GenData{
+		Source:  filepath.Base(SrcFile),
+		Package: PkgName,
+		Type:    TypeName,
+		Var:     VarName,
+		Sum:     pkg.KeysSum(),
+		Embed:   Embed,
+	}
+	var names = map[string]int{}
+	for _, fkey := range pkg.Keys() {
+		var name = ConstName(fkey)
+		if n := names[name]; n > 0 {
+			names[name] = n + 1
+			name = fmt.Sprintf("%s_%d", name, n+1)
+		} else {
+			names[name] = 1
+		}
+		data.Keys = append(data.Keys, KeyDecl{Name: name, Key: fkey})
+	}
+
+	if Embed {
+		var pkgpath = auto.PkgPath(SrcFile)
+		if data.PkgFile, err = embedpath(pkgpath); err != nil {
+			return
+		}
+		data.Files = append(data.Files, data.PkgFile)
+		if pkg.IsSplitted() {
+			var datfile string
+			if datfile, err = embedpath(wpk.MakeDataPath(pkgpath)); err != nil {
+				return
+			}
+			data.Files = append(data.Files, datfile)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = gentmpl.Execute(&buf, &data); err != nil {
+		return
+	}
+	var src []byte
+	if src, err = format.Source(buf.Bytes()); err != nil {
+		return
+	}
+	if err = os.WriteFile(DstFile, src, 0644); err != nil {
+		return
+	}
+	log.Printf("written %d keys to %s", len(data.Keys), DstFile)
+	return
+}
+
+func main() {
+	parseargs()
+	if checkargs() > 0 {
+		os.Exit(2)
+	}
+
+	log.Println("starts")
+	if err := generate(); err != nil {
+		log.Println(err.Error())
+		os.Exit(1)
+	}
+	log.Println("done.")
+}
+
+// The End.
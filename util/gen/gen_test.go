@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+// genkeys is the list of files of test package,
+// some of them have the same constants names.
+var genkeys = []string{
+	"img1/claustral.jpg",
+	"a-b.txt",
+	"a_b.txt",
+	"1st.txt",
+}
+
+// makepkg writes package with files of given list to given path.
+func makepkg(t *testing.T, pkgpath string, list []string) {
+	t.Helper()
+	var fwpk, err = os.Create(pkgpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fwpk.Close()
+	var pkg = wpk.NewPackage()
+	if err = pkg.Begin(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, fkey := range list {
+		if _, err = pkg.PackData(fwpk, strings.NewReader("content of "+fkey), fkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = pkg.Sync(fwpk, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test names of constants for file keys.
+func TestConstName(t *testing.T) {
+	Prefix = "Key"
+	for fkey, name := range map[string]string{
+		"img1/claustral.jpg": "KeyImg1ClaustralJpg",
+		"a-b.txt":            "KeyABTxt",
+		"a_b.txt":            "KeyABTxt",
+		"1st.txt":            "Key1stTxt",
+		"Qarataşlar.jpg":     "KeyQarataşlarJpg",
+	} {
+		if res := ConstName(fkey); res != name {
+			t.Errorf("constant name for '%s' is '%s', expected '%s'", fkey, res, name)
+		}
+	}
+	Prefix = ""
+	if res := ConstName("1st.txt"); res != "_1stTxt" {
+		t.Errorf("constant name without prefix is '%s', expected '_1stTxt'", res)
+	}
+}
+
+// Test generation of code with embedded package, and build of it.
+func TestGenerate(t *testing.T) {
+	var err error
+	var gobin string
+	if gobin, err = exec.LookPath("go"); err != nil {
+		t.Skip("go tool is not found:", err)
+	}
+	var root string
+	if root, err = filepath.Abs("../.."); err != nil {
+		t.Fatal(err)
+	}
+	var gosum []byte
+	if gosum, err = os.ReadFile(filepath.Join(root, "go.sum")); err != nil {
+		t.Fatal(err)
+	}
+
+	var dir = t.TempDir()
+	var gomod = "module gentest\n\ngo 1.20\n\n" +
+		"require github.com/schwarzlichtbezirk/wpk v0.0.0\n\n" +
+		"replace github.com/schwarzlichtbezirk/wpk => " + filepath.ToSlash(root) + "\n"
+	var mainsrc = `package main
+
+import "fmt"
+
+func main() {
+	for _, key := range AssetsKeys {
+		data, err := Assets.ReadFile(key.String())
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(data))
+	}
+	fmt.Println(KeyABTxt, KeyABTxt_2)
+}
+`
+	for fname, data := range map[string][]byte{
+		"go.mod":  []byte(gomod),
+		"go.sum":  gosum,
+		"main.go": []byte(mainsrc),
+	} {
+		if err = os.WriteFile(filepath.Join(dir, fname), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makepkg(t, filepath.Join(dir, "assets.wpk"), genkeys)
+
+	SrcFile = filepath.Join(dir, "assets.wpk")
+	DstFile = filepath.Join(dir, "wpkkeys.go")
+	PkgName, TypeName, Prefix, VarName = "main", "AssetKey", "Key", "Assets"
+	Embed = true
+	if err = generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var src, fmtsrc []byte
+	if src, err = os.ReadFile(DstFile); err != nil {
+		t.Fatal(err)
+	}
+	if fmtsrc, err = format.Source(src); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, fmtsrc) {
+		t.Fatal("generated code is not formatted")
+	}
+
+	var cmd = exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	var out []byte
+	if out, err = cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code can not be built: %v\n%s", err, out)
+	}
+	var expected = []string{ // keys are sorted
+		"content of 1st.txt",
+		"content of a-b.txt",
+		"content of a_b.txt",
+		"content of img1/claustral.jpg",
+		"a-b.txt a_b.txt",
+	}
+	if res := strings.Split(strings.TrimSpace(string(out)), "\n"); strings.Join(res, "|") != strings.Join(expected, "|") {
+		t.Fatalf("unexpected output of generated code:\n%s", out)
+	}
+
+	// package placed out of output file directory can not be embedded
+	DstFile = filepath.Join(dir, "sub", "wpkkeys.go")
+	if err = generate(); err == nil || !strings.Contains(err.Error(), "is not placed at output file directory") {
+		t.Fatalf("package out of output directory is embedded, error: %v", err)
+	}
+}
+
+// The End.
@@ -1,38 +1,90 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"flag"
-	"io"
-	"io/fs"
 	"log"
-	"mime"
-	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
 
 	"github.com/schwarzlichtbezirk/wpk"
+	lw "github.com/schwarzlichtbezirk/wpk/luawpk"
 )
 
 // command line settings
 var (
 	srcpath string
 	SrcList []string
+	arcpath string
+	ArcList []string
+	ArcDup  string
 	DstFile string
 	PutMIME bool
 	PutLink bool
 	ShowLog bool
 	Split   bool
+	Workers int
+	IgnName string
+	Include string
+	Exclude string
+	MaxSize int64
+	AutoFID bool
+	Follow  bool
+	HardLnk bool
+	Xattrs  bool
+	DryRun  bool
+	Secret  string
+	Hashes  = map[wpk.TID]*bool{}
+	Update  bool
+	HashCmp bool
+	Ratio   float64
 )
 
+// HashFlags is the list of flags to put hashes of files content,
+// the same as hashes of Lua builder.
+var HashFlags = []struct {
+	Name string
+	TID  wpk.TID
+}{
+	{"crc32", wpk.TIDcrc32c},
+	{"crc64", wpk.TIDcrc64iso},
+	{"md5", wpk.TIDmd5},
+	{"sha1", wpk.TIDsha1},
+	{"sha224", wpk.TIDsha224},
+	{"sha256", wpk.TIDsha256},
+	{"sha384", wpk.TIDsha384},
+	{"sha512", wpk.TIDsha512},
+}
+
 func parseargs() {
 	flag.StringVar(&srcpath, "src", "", "full path to folder with source files to be packaged, or list of folders divided by ';'")
+	flag.StringVar(&arcpath, "arc", "", "full path to zip or tar archive with source files to be packaged, or list of archives divided by ';'")
+	flag.StringVar(&ArcDup, "arcdup", "first", "what to do with archives files which names are already present at package, can be \"first\", \"last\", \"error\" and \"rename\"")
 	flag.StringVar(&DstFile, "dst", "", "full path to output package file")
-	flag.BoolVar(&PutMIME, "mime", false, "put content MIME type defined by file extension to each file tagset")
+	flag.BoolVar(&PutMIME, "mime", false, "put content MIME type defined by file extension or by content to each file tagset")
 	flag.BoolVar(&PutLink, "link", false, "put full path to the original file to each file tagset")
 	flag.BoolVar(&ShowLog, "log", true, "show process log for each extracting file")
 	flag.BoolVar(&Split, "split", false, "write package to splitted files")
+	flag.IntVar(&Workers, "workers", 0, "number of workers to read and hash files, number of CPUs by default")
+	flag.StringVar(&IgnName, "ignore", "", "name of files with gitignore-like rules at source folders, such as "+wpk.IgnoreFileName+", all files are packed if it's empty")
+	flag.StringVar(&Include, "inc", "", "glob patterns of files at source folders to pack divided by ';', all files if it's empty")
+	flag.StringVar(&Exclude, "exc", "", "glob patterns of files at source folders to skip divided by ';'")
+	flag.Int64Var(&MaxSize, "maxsize", 0, "skip files at source folders greater than given size in bytes, no limit if it's zero")
+	flag.BoolVar(&AutoFID, "fid", false, "put unique file ID to each file tagset")
+	flag.BoolVar(&Follow, "follow", false, "follow symbolic links to directories at source folders, they are skipped otherwise, links to files are always packed with content of their targets")
+	flag.BoolVar(&HardLnk, "hardlink", false, "put hard links to the same file at source folders as aliases to the first one without data copy")
+	flag.BoolVar(&Xattrs, "xattr", false, "put extended attributes of files at source folders to each file tagset, on Linux only")
+	flag.BoolVar(&DryRun, "dry", false, "only list files of source folders to pack, do not write package")
+	for _, h := range HashFlags {
+		Hashes[h.TID] = flag.Bool(h.Name, false, "put "+wpk.TidName[h.TID]+" hash of content of source folders files to each file tagset")
+	}
+	flag.StringVar(&Secret, "secret", "", "private key to sign MD5 and SHA hashes")
+	flag.BoolVar(&Update, "update", false, "update existing package, pack only new and changed files, and delete files that are absent at source folders")
+	flag.BoolVar(&HashCmp, "hashcmp", false, "on update compare files content by stored hashes if they are present")
+	flag.Float64Var(&Ratio, "ratio", 0, "on update compact package if ratio of unused space to data size exceeds given value, never if it's zero")
 	flag.Parse()
 }
 
@@ -52,13 +104,52 @@ func checkargs() (ec int) { // returns error counter
 		}
 		SrcList = append(SrcList, fpath)
 	}
-	if len(SrcList) == 0 {
+	for i, fpath := range strings.Split(arcpath, ";") {
+		if fpath == "" {
+			continue
+		}
+		fpath = wpk.ToSlash(wpk.Envfmt(fpath, nil))
+		if !wpk.IsArchive(fpath) {
+			log.Printf("source archive #%d '%s' has unsupported format", i+1, fpath)
+			ec++
+			continue
+		}
+		if ok, _ := wpk.FileExists(fpath); !ok {
+			log.Printf("source archive #%d '%s' does not exist", i+1, fpath)
+			ec++
+			continue
+		}
+		ArcList = append(ArcList, fpath)
+	}
+	if _, ok := wpk.MergePolicies[ArcDup]; !ok {
+		log.Println("given policy for duplicate files of archives does not supported")
+		ec++
+	}
+	if len(SrcList) == 0 && len(ArcList) == 0 {
 		log.Println("source path does not specified")
 		ec++
 	}
 
+	for _, pattern := range append(splitlist(Include), splitlist(Exclude)...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Printf("glob pattern '%s' is malformed", pattern)
+			ec++
+		}
+	}
+
+	if Update && len(ArcList) > 0 {
+		log.Println("update can be used only with source folders")
+		ec++
+	}
+	if Ratio < 0 || Ratio >= 1 {
+		log.Println("ratio of unused space should be in range [0, 1)")
+		ec++
+	}
+
 	DstFile = wpk.ToSlash(wpk.Envfmt(DstFile, nil))
-	if DstFile == "" {
+	if DryRun {
+		// destination file is not used on dry run
+	} else if DstFile == "" {
 		log.Println("destination file does not specified")
 		ec++
 	} else if ok, _ := wpk.DirExists(path.Dir(DstFile)); !ok {
@@ -69,7 +160,73 @@ func checkargs() (ec int) { // returns error counter
 	return
 }
 
-func writepackage() (err error) {
+// splitlist returns not empty elements of list divided by ';'.
+func splitlist(list string) (res []string) {
+	for _, s := range strings.Split(list, ";") {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return
+}
+
+// listfolder returns files of source folder selected by command line settings.
+func listfolder(ctx context.Context, srcpath string) ([]wpk.PackSource, error) {
+	return wpk.ListDir(ctx, srcpath, "", wpk.DirOpts{
+		IgnoreFile:  IgnName,
+		Include:     splitlist(Include),
+		Exclude:     splitlist(Exclude),
+		MaxSize:     MaxSize,
+		FollowLinks: Follow,
+		HardLinks:   HardLnk,
+	})
+}
+
+// listpackage prints files of source folders that would be packed.
+func listpackage(ctx context.Context) (err error) {
+	var num, sum int64
+	for i, srcpath := range SrcList {
+		log.Printf("source folder #%d: %s", i+1, srcpath)
+		var list []wpk.PackSource
+		if list, err = listfolder(ctx, srcpath); err != nil {
+			return
+		}
+		for _, src := range list {
+			num++
+			if src.HardLink != "" {
+				log.Printf("#%-4d hard link     %s -> %s", num, src.FKey, src.HardLink)
+				continue
+			}
+			sum += src.Size
+			log.Printf("#%-4d %7d bytes   %s", num, src.Size, src.FKey)
+		}
+	}
+	for i, arcpath := range ArcList {
+		log.Printf("source archive #%d: %s", i+1, arcpath)
+	}
+	log.Printf("to pack: %d files on %d bytes", num, sum)
+	return
+}
+
+// packopts returns options of packing pipeline by command line settings.
+func packopts() wpk.PackOpts {
+	var opts = wpk.PackOpts{
+		Workers: Workers,
+		Secret:  []byte(Secret),
+		Xattrs:  Xattrs,
+	}
+	for _, h := range HashFlags {
+		if *Hashes[h.TID] {
+			opts.Hashes = append(opts.Hashes, h.TID)
+		}
+	}
+	if PutMIME {
+		opts.Mime = lw.DetectMime
+	}
+	return opts
+}
+
+func writepackage(ctx context.Context) (err error) {
 	var fwpk, fwpf wpk.WriteSeekCloser
 	var pkgfile, datfile = DstFile, DstFile
 	var pkg = wpk.NewPackage()
@@ -107,66 +264,78 @@ func writepackage() (err error) {
 	}
 
 	// write all source folders
+	var opts = packopts()
+	var fid uint
 	for i, srcpath := range SrcList {
 		log.Printf("source folder #%d: %s", i+1, srcpath)
-		var num, sum int64
-		fs.WalkDir(os.DirFS(srcpath), ".", func(fkey string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if d.IsDir() {
-				return nil // file is directory
-			}
-
-			var fpath = wpk.JoinPath(srcpath, fkey)
-			var file wpk.RFile
-			var ts wpk.TagsetRaw
-			if file, err = os.Open(fpath); err != nil {
-				return err
-			}
-			defer file.Close()
-
-			if ts, err = pkg.PackFile(w, file, fkey); err != nil {
-				return err
-			}
+		var list []wpk.PackSource
+		if list, err = listfolder(ctx, srcpath); err != nil {
+			return
+		}
 
+		var num, sum int64
+		opts.Hook = func(fkey string, ts wpk.TagsetRaw) (wpk.TagsetRaw, error) {
 			var size = ts.Size()
 			num++
 			sum += size
 			if ShowLog {
 				log.Printf("#%-4d %7d bytes   %s", num, size, fkey)
 			}
-
-			// adjust tags
-			if PutMIME {
-				const sniffLen = 512
-				var ctype = mime.TypeByExtension(path.Ext(fkey))
-				if ctype == "" {
-					// rewind to file start
-					if _, err = file.Seek(0, io.SeekStart); err != nil {
-						return err
-					}
-					// read a chunk to decide between utf-8 text and binary
-					var buf [sniffLen]byte
-					var n int64
-					if n, err = io.CopyN(bytes.NewBuffer(buf[:]), file, sniffLen); err != nil && err != io.EOF {
-						return err
-					}
-					ctype = http.DetectContentType(buf[:n])
-				}
-				if ctype != "" {
-					ts = ts.Put(wpk.TIDmime, wpk.StrTag(ctype))
-				}
-			}
 			if PutLink {
-				ts = ts.Put(wpk.TIDlink, wpk.StrTag(fpath))
+				ts = ts.Set(wpk.TIDlink, wpk.StrTag(wpk.JoinPath(srcpath, fkey)))
 			}
-			pkg.SetTagset(fkey, ts)
-			return nil
-		})
+			if AutoFID {
+				fid++
+				ts = ts.Set(wpk.TIDfid, wpk.UintTag(fid))
+			}
+			return ts, nil
+		}
+		if _, err = pkg.PackPipelineCtx(ctx, w, list, opts); err != nil {
+			return
+		}
 		log.Printf("packed: %d files on %d bytes", num, sum)
 	}
 
+	// write all source archives
+	for i, arcpath := range ArcList {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		log.Printf("source archive #%d: %s", i+1, arcpath)
+		var num, cnt, skip int
+		var sum int64
+		var arcopts = wpk.ArcOpts{
+			Policy: wpk.MergePolicies[ArcDup],
+			Hook: func(fkey string, ts wpk.TagsetRaw) wpk.TagsetRaw {
+				var size = ts.Size()
+				cnt++
+				sum += size
+				if ShowLog {
+					log.Printf("#%-4d %7d bytes   %s", cnt, size, fkey)
+				}
+				if PutLink {
+					ts = ts.Put(wpk.TIDlink, wpk.StrTag(wpk.JoinPath(arcpath, fkey)))
+				}
+				if AutoFID {
+					fid++
+					ts = ts.Put(wpk.TIDfid, wpk.UintTag(fid))
+				}
+				return ts
+			},
+			Skip: func(name string, err error) {
+				skip++
+				log.Printf("skipped: %s: %s", name, err.Error())
+			},
+		}
+		if PutMIME {
+			arcopts.Mime = lw.DetectMime
+		}
+		if num, err = pkg.PackArchive(w, arcpath, arcopts); err != nil {
+			return
+		}
+		log.Printf("packed: %d files and %d aliases on %d bytes, skipped %d entries", cnt, num-cnt, sum, skip)
+	}
+
 	// finalize
 	log.Printf("write tags table")
 	if err = pkg.Sync(fwpk, fwpf); err != nil {
@@ -176,15 +345,154 @@ func writepackage() (err error) {
 	return
 }
 
+// pkgexists checks up that destination package is present to update.
+func pkgexists() bool {
+	var pkgfile = DstFile
+	if Split {
+		pkgfile = wpk.MakeTagsPath(pkgfile)
+	}
+	var ok, _ = wpk.FileExists(pkgfile)
+	return ok
+}
+
+func updatepackage(ctx context.Context) (err error) {
+	var pkgfile, datfile = DstFile, ""
+	if Split {
+		pkgfile, datfile = wpk.MakeTagsPath(pkgfile), wpk.MakeDataPath(pkgfile)
+	}
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgfile); err != nil {
+		return
+	}
+	if pkg.IsSplitted() != Split {
+		return errors.New("layout of existing package does not match to split setting")
+	}
+	log.Printf("update package: %s\n", pkgfile)
+
+	// get the list of all sources
+	var list []wpk.PackSource
+	for i, srcpath := range SrcList {
+		log.Printf("source folder #%d: %s", i+1, srcpath)
+		var sub []wpk.PackSource
+		if sub, err = listfolder(ctx, srcpath); err != nil {
+			return
+		}
+		list = append(list, sub...)
+	}
+
+	var links = make(map[string]string, len(list))
+	for _, src := range list {
+		links[src.FKey] = src.FPath
+	}
+
+	// file IDs continue the last one
+	var fid uint
+	pkg.Enum(func(fkey string, ts wpk.TagsetRaw) bool {
+		if id, ok := ts.TagUint(wpk.TIDfid); ok && id > fid {
+			fid = id
+		}
+		return true
+	})
+
+	var rep *wpk.UpdateReport
+	if err = func() (err error) {
+		var fwpk, fwpf *os.File
+		if fwpk, err = os.OpenFile(pkgfile, os.O_RDWR, 0644); err != nil {
+			return
+		}
+		defer fwpk.Close()
+		var w = fwpk
+		if Split {
+			if fwpf, err = os.OpenFile(datfile, os.O_RDWR, 0644); err != nil {
+				return
+			}
+			defer fwpf.Close()
+			w = fwpf
+			err = pkg.Append(fwpk, fwpf)
+		} else {
+			err = pkg.Append(fwpk, nil)
+		}
+		if err != nil {
+			return
+		}
+
+		var opts = wpk.UpdateOpts{
+			PackOpts:  packopts(),
+			CheckHash: HashCmp,
+			Delete:    true,
+		}
+		var num int
+		opts.Hook = func(fkey string, ts wpk.TagsetRaw) (wpk.TagsetRaw, error) {
+			num++
+			if ShowLog {
+				log.Printf("#%-4d %7d bytes   %s", num, ts.Size(), fkey)
+			}
+			if PutLink {
+				ts = ts.Set(wpk.TIDlink, wpk.StrTag(links[fkey]))
+			}
+			if AutoFID {
+				fid++
+				ts = ts.Set(wpk.TIDfid, wpk.UintTag(fid))
+			}
+			return ts, nil
+		}
+		if rep, err = pkg.UpdateCtx(ctx, w, list, opts); err != nil {
+			return
+		}
+		if ShowLog {
+			for _, fkey := range rep.Deleted {
+				log.Printf("deleted: %s", fkey)
+			}
+		}
+
+		log.Printf("write tags table")
+		if Split {
+			return pkg.Sync(fwpk, fwpf)
+		}
+		return pkg.Sync(fwpk, nil)
+	}(); err != nil {
+		return
+	}
+	log.Printf("updated: %d added, %d updated, %d deleted, %d unchanged files",
+		len(rep.Added), len(rep.Updated), len(rep.Deleted), rep.Unchanged)
+
+	// compact the package if it has too much unused space
+	var waste, total = pkg.Waste()
+	if total > 0 {
+		log.Printf("unused space: %d bytes of %d (%.1f%%)", waste, total, float64(waste)*100/float64(total))
+	}
+	if Ratio > 0 && total > 0 && float64(waste)/float64(total) > Ratio {
+		log.Printf("compact package")
+		if err = wpk.CompactFile(ctx, pkgfile, datfile, nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func main() {
 	parseargs()
 	if checkargs() > 0 {
 		return
 	}
 
+	var ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
 	log.Println("starts")
-	if err := writepackage(); err != nil {
+	var err error
+	if DryRun {
+		err = listpackage(ctx)
+	} else if Update && pkgexists() {
+		err = updatepackage(ctx)
+	} else {
+		err = writepackage(ctx)
+	}
+	if err != nil {
 		log.Println(err.Error())
+		if errors.Is(err, context.Canceled) {
+			log.Println("packing was interrupted, written files can be restored by recovery")
+		}
 		return
 	}
 	log.Println("done.")
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	lw "github.com/schwarzlichtbezirk/wpk/luawpk"
+)
+
+// EditResult is the list of files affected by package modification.
+type EditResult struct {
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+	Bytes int64    `json:"bytes"` // size of files data
+}
+
+// hook is packing hook that puts names of packed files
+// and size of their data to the result.
+func (res *EditResult) hook(fkey string, ts wpk.TagsetRaw) (wpk.TagsetRaw, error) {
+	res.Files = append(res.Files, fkey)
+	res.Bytes += ts.Size()
+	return ts, nil
+}
+
+var cmdadd = &Command{
+	Name:  "add",
+	Args:  "package path...",
+	Usage: "append files or directories to package",
+}
+
+func init() {
+	cmdadd.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var prefix string
+		var workers int
+		var putmime bool
+		var fs = cmdadd.flagset(&com)
+		fs.StringVar(&prefix, "prefix", "", "directory in package to put files into")
+		fs.IntVar(&workers, "workers", 0, "number of workers to read files and compute hashes, number of CPUs if it's zero")
+		fs.BoolVar(&putmime, "mime", false, "put MIME type of files")
+		if err = parse(fs, args, 2, -1); err != nil {
+			return
+		}
+
+		var res = EditResult{Path: fs.Arg(0), Files: []string{}}
+		var opts = wpk.PackOpts{
+			Workers: workers,
+			Hook:    res.hook,
+		}
+		if putmime {
+			opts.Mime = lw.DetectMime
+		}
+		if err = modify(fs.Arg(0), func(pkg *wpk.Package, w io.WriteSeeker) (err error) {
+			var list []wpk.PackSource
+			for _, fpath := range fs.Args()[1:] {
+				fpath = wpk.ToSlash(wpk.Envfmt(fpath, nil))
+				var fi os.FileInfo
+				if fi, err = os.Stat(fpath); err != nil {
+					return
+				}
+				var fkey = wpk.JoinPath(prefix, path.Base(fpath))
+				if fi.IsDir() {
+					if _, err = pkg.PackDirCtx(ctx, w, fpath, fkey, opts); err != nil {
+						return
+					}
+					continue
+				}
+				list = append(list, wpk.PackSource{
+					FKey:  fkey,
+					FPath: fpath,
+					Size:  fi.Size(),
+				})
+			}
+			if len(list) > 0 {
+				if _, err = pkg.PackPipelineCtx(ctx, w, list, opts); err != nil {
+					return
+				}
+			}
+			return
+		}); err != nil {
+			return
+		}
+		return com.print(res, func(w io.Writer) {
+			fmt.Fprintf(w, "added %d files on %d bytes\n", len(res.Files), res.Bytes)
+		})
+	}
+}
+
+var cmdrm = &Command{
+	Name:  "rm",
+	Args:  "package file...",
+	Usage: "delete files from package, data remains until compaction",
+}
+
+func init() {
+	cmdrm.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var fs = cmdrm.flagset(&com)
+		if err = parse(fs, args, 2, -1); err != nil {
+			return
+		}
+		var res = EditResult{Path: fs.Arg(0), Files: []string{}}
+		if err = modify(fs.Arg(0), func(pkg *wpk.Package, w io.WriteSeeker) error {
+			for _, fkey := range fs.Args()[1:] {
+				var ts, ok = pkg.DelTagset(fkey)
+				if !ok {
+					return fmt.Errorf("%w: %s", ErrNoFile, fkey)
+				}
+				res.Files = append(res.Files, fkey)
+				res.Bytes += ts.Size()
+			}
+			return nil
+		}); err != nil {
+			return
+		}
+		return com.print(res, func(w io.Writer) {
+			fmt.Fprintf(w, "deleted %d files on %d bytes\n", len(res.Files), res.Bytes)
+		})
+	}
+}
+
+var cmdmv = &Command{
+	Name:  "mv",
+	Args:  "package oldname newname",
+	Usage: "rename file or directory at package",
+}
+
+func init() {
+	cmdmv.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var fs = cmdmv.flagset(&com)
+		if err = parse(fs, args, 3, 3); err != nil {
+			return
+		}
+		var oldname, newname = fs.Arg(1), fs.Arg(2)
+		var res = EditResult{Path: fs.Arg(0), Files: []string{}}
+		if err = modify(fs.Arg(0), func(pkg *wpk.Package, w io.WriteSeeker) (err error) {
+			if ts, ok := pkg.GetTagset(oldname); ok {
+				if err = pkg.Rename(oldname, newname); err != nil {
+					return
+				}
+				res.Files = append(res.Files, newname)
+				res.Bytes += ts.Size()
+				return
+			}
+			// rename all files of directory
+			var prefix = strings.TrimSuffix(oldname, "/") + "/"
+			var moved []string
+			pkg.Enum(func(fkey string, ts wpk.TagsetRaw) bool {
+				if strings.HasPrefix(fkey, prefix) {
+					moved = append(moved, wpk.JoinPath(newname, fkey[len(prefix):]))
+					res.Bytes += ts.Size()
+				}
+				return true
+			})
+			var n int
+			if n, err = pkg.RenameDir(oldname, newname, false); err != nil {
+				return
+			}
+			if n == 0 {
+				return fmt.Errorf("%w: %s", ErrNoFile, oldname)
+			}
+			sort.Strings(moved)
+			res.Files = moved
+			return
+		}); err != nil {
+			return
+		}
+		return com.print(res, func(w io.Writer) {
+			fmt.Fprintf(w, "renamed %d files on %d bytes\n", len(res.Files), res.Bytes)
+		})
+	}
+}
+
+// The End.
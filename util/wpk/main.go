@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/auto"
+)
+
+var (
+	ErrArgs      = errors.New("wrong number of arguments")
+	ErrNoFile    = errors.New("file is not found in package")
+	ErrProtected = errors.New("tag can not be changed directly")
+	ErrTagName   = errors.New("unknown tag name")
+)
+
+// stdout is the output of commands results.
+var stdout io.Writer = os.Stdout
+
+// exitcode is error with program exit code.
+type exitcode int
+
+func (e exitcode) Error() string {
+	return "exit code " + strconv.Itoa(int(e))
+}
+
+// Command is the subcommand of program.
+type Command struct {
+	Name  string
+	Args  string // arguments synopsis
+	Usage string
+	Run   func(ctx context.Context, args []string) error
+}
+
+// Commands is the list of all subcommands in order of usage printing.
+var Commands = []*Command{
+	cmdls, cmdcat, cmdinfo, cmdtags,
+	cmdadd, cmdrm, cmdmv,
+	cmdextract, cmdsync, cmdverify, cmdbuild, cmdpack,
+}
+
+// Common settings shared by subcommands.
+type Common struct {
+	JSON    bool
+	PkgMode string
+}
+
+// flagset creates set of flags for subcommand with common flags.
+func (cmd *Command) flagset(com *Common) *flag.FlagSet {
+	var fs = flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wpk %s [flags] %s\n%s\n", cmd.Name, cmd.Args, cmd.Usage)
+		fs.PrintDefaults()
+	}
+	if com != nil {
+		fs.BoolVar(&com.JSON, "json", false, "print output in JSON format")
+		fs.StringVar(&com.PkgMode, "pm", "auto", "package opening mode, can be \"auto\", \"bulk\", \"mmap\", \"share\" and \"fsys\"")
+	}
+	return fs
+}
+
+// parse parses arguments of subcommand. Parsing error
+// is already printed, so it's returned as exit code.
+func parse(fs *flag.FlagSet, args []string, nmin, nmax int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return exitcode(2)
+	}
+	if fs.NArg() < nmin || (nmax >= 0 && fs.NArg() > nmax) {
+		fs.Usage()
+		return exitcode(2)
+	}
+	return nil
+}
+
+// openpkg opens package for reading with given settings.
+func (com *Common) openpkg(pkgpath string) (*wpk.Package, error) {
+	var mode, err = auto.ParseMode(com.PkgMode)
+	if err != nil {
+		return nil, err
+	}
+	return auto.OpenPackage(wpk.Envfmt(pkgpath, nil), auto.Options{Mode: mode})
+}
+
+// print writes value as indented JSON if it's requested,
+// or calls given function to print it in human readable form.
+func (com *Common) print(v any, human func(w io.Writer)) error {
+	if com.JSON {
+		var enc = json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	human(stdout)
+	return nil
+}
+
+// modify opens package to change it, calls given function with data writer,
+// and writes updated tags table. Files data is appended to the end of data.
+// Package keeps its previous state if the function fails.
+func modify(pkgpath string, f func(pkg *wpk.Package, w io.WriteSeeker) error) (err error) {
+	pkgpath = auto.PkgPath(wpk.Envfmt(pkgpath, nil))
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		return
+	}
+	pkg.SafeAppend = true
+
+	var wpt, wpf *os.File
+	if wpt, err = os.OpenFile(pkgpath, os.O_RDWR, 0644); err != nil {
+		return
+	}
+	defer wpt.Close()
+	if pkg.IsSplitted() {
+		if wpf, err = os.OpenFile(wpk.MakeDataPath(pkgpath), os.O_RDWR, 0644); err != nil {
+			return
+		}
+		defer wpf.Close()
+	}
+
+	var w io.WriteSeeker = wpt
+	if wpf != nil {
+		err = pkg.Append(wpt, wpf)
+		w = wpf
+	} else {
+		err = pkg.Append(wpt, nil)
+	}
+	if err != nil {
+		return
+	}
+	if err = f(pkg, w); err != nil {
+		return
+	}
+	if wpf != nil {
+		return pkg.Sync(wpt, wpf)
+	}
+	return pkg.Sync(wpt, nil)
+}
+
+// TagsMap returns map of tags names to string presentation of their values.
+// Tags without names have names with decimal identifiers.
+func TagsMap(ts wpk.TagsetRaw) map[string]string {
+	var m = map[string]string{}
+	var tsi = ts.Iterator()
+	for tsi.Next() {
+		m[tagname(tsi.TID())] = formattag(tsi.TID(), tsi.Tag())
+	}
+	return m
+}
+
+// tagname returns name of tag, or its number for unnamed tags.
+func tagname(tid wpk.TID) string {
+	if name, ok := wpk.TidName[tid]; ok {
+		return name
+	}
+	return strconv.Itoa(int(tid))
+}
+
+// formattag returns string presentation of tag value,
+// or hex dump if the value does not match the tag type.
+func formattag(tid wpk.TID, tag wpk.TagRaw) string {
+	if s, err := wpk.FormatTag(tid, tag); err == nil {
+		return s
+	}
+	return fmt.Sprintf("%x", []byte(tag))
+}
+
+// printtags writes tags in "name: value" form sorted by tags identifiers.
+func printtags(w io.Writer, indent string, ts wpk.TagsetRaw) {
+	var tids []int
+	var tsi = ts.Iterator()
+	for tsi.Next() {
+		tids = append(tids, int(tsi.TID()))
+	}
+	sort.Ints(tids)
+	for _, tid := range tids {
+		var tag, _ = ts.Get(wpk.TID(tid))
+		fmt.Fprintf(w, "%s%s: %s\n", indent, tagname(wpk.TID(tid)), formattag(wpk.TID(tid), tag))
+	}
+}
+
+func usage() {
+	var w = flag.CommandLine.Output()
+	fmt.Fprintln(w, "usage: wpk <command> [flags] [arguments]")
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range Commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.Name, cmd.Usage)
+	}
+	fmt.Fprintln(w, "run \"wpk <command> -h\" for command flags")
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var name = flag.Arg(0)
+	for _, cmd := range Commands {
+		if cmd.Name != name {
+			continue
+		}
+		var err = cmd.Run(ctx, flag.Args()[1:])
+		var code exitcode
+		switch {
+		case err == nil:
+			return
+		case errors.Is(err, flag.ErrHelp):
+			return
+		case errors.As(err, &code):
+			cancel()
+			os.Exit(int(code))
+		case errors.Is(err, ErrArgs):
+			fmt.Fprintf(os.Stderr, "wpk %s: %s\n", name, err.Error())
+			cancel()
+			os.Exit(2)
+		default:
+			fmt.Fprintf(os.Stderr, "wpk %s: %s\n", name, err.Error())
+			cancel()
+			os.Exit(1)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "wpk: unknown command '%s'\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// The End.
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// srcfiles is the content of directory to pack.
+var srcfiles = map[string]string{
+	"a.txt":      "content of a",
+	"docs/b.txt": "content of b",
+	"docs/c.txt": "content of c",
+}
+
+// cmdcase is the call of command with expected result.
+type cmdcase struct {
+	name  string
+	cmd   *Command
+	args  []string // "$PKG" and "$DIR" are replaced by package path and temporary directory
+	err   error    // expected error, nil if command should succeed
+	check func(t *testing.T, out []byte)
+}
+
+// jsonkeys returns sorted keys of JSON object.
+func jsonkeys(t *testing.T, out []byte) []string {
+	t.Helper()
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatalf("output is not JSON object: %v\n%s", err, out)
+	}
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// editres checks up the shape of EditResult output and returns it.
+func editres(t *testing.T, out []byte) EditResult {
+	t.Helper()
+	if keys := jsonkeys(t, out); !reflect.DeepEqual(keys, []string{"bytes", "files", "path"}) {
+		t.Fatalf("unexpected fields of result: %v", keys)
+	}
+	var res EditResult
+	if err := json.Unmarshal(out, &res); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(res.Files)
+	return res
+}
+
+// expectfiles returns checker of EditResult with given files.
+func expectfiles(files ...string) func(t *testing.T, out []byte) {
+	return func(t *testing.T, out []byte) {
+		t.Helper()
+		var res = editres(t, out)
+		if !reflect.DeepEqual(res.Files, files) {
+			t.Fatalf("expected files %v, got %v", files, res.Files)
+		}
+		var bytes = int64(len(files) * len("content of a")) // all files have the same size
+		if res.Bytes != bytes {
+			t.Fatalf("expected %d bytes of files, got %d", bytes, res.Bytes)
+		}
+	}
+}
+
+// Test commands on package in sequence.
+func TestCommands(t *testing.T) {
+	var dir = t.TempDir()
+	var srcdir = filepath.Join(dir, "src")
+	var pkgpath = filepath.Join(dir, "test.wpk")
+	for fkey, data := range srcfiles {
+		var fpath = filepath.Join(srcdir, fkey)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("content of n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "build.lua"), []byte("local n = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []cmdcase{
+		{"pack", cmdpack, []string{"-json", "$PKG", "$DIR/src"}, nil,
+			expectfiles("a.txt", "docs/b.txt", "docs/c.txt")},
+
+		// tags that defines file placement are protected
+		{"set offset", cmdtags, []string{"set", "$PKG", "a.txt", "offset=0"}, ErrProtected, nil},
+		{"set size", cmdtags, []string{"set", "$PKG", "a.txt", "size=1"}, ErrProtected, nil},
+		{"set path", cmdtags, []string{"set", "$PKG", "a.txt", "path=b.txt"}, ErrProtected, nil},
+		{"del offset", cmdtags, []string{"del", "$PKG", "a.txt", "offset"}, ErrProtected, nil},
+		{"del size", cmdtags, []string{"del", "$PKG", "a.txt", "size"}, ErrProtected, nil},
+		{"del path", cmdtags, []string{"del", "$PKG", "a.txt", "path"}, ErrProtected, nil},
+		{"set unknown", cmdtags, []string{"set", "$PKG", "a.txt", "nosuchtag=1"}, ErrTagName, nil},
+		{"set comment", cmdtags, []string{"set", "$PKG", "a.txt", "comment=hello"}, nil, nil},
+		{"get comment", cmdtags, []string{"get", "-json", "$PKG", "a.txt", "comment", "size"}, nil,
+			func(t *testing.T, out []byte) {
+				var m map[string]string
+				if err := json.Unmarshal(out, &m); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(m, map[string]string{"comment": "hello", "size": "12"}) {
+					t.Fatalf("unexpected tags %v", m)
+				}
+			}},
+		{"del comment", cmdtags, []string{"del", "$PKG", "a.txt", "comment"}, nil, nil},
+		{"get deleted", cmdtags, []string{"get", "-json", "$PKG", "a.txt", "comment"}, nil,
+			func(t *testing.T, out []byte) {
+				if keys := jsonkeys(t, out); len(keys) != 0 {
+					t.Fatalf("deleted tag is present: %v", keys)
+				}
+			}},
+
+		// file and directory renaming
+		{"mv file", cmdmv, []string{"-json", "$PKG", "a.txt", "top.txt"}, nil,
+			expectfiles("top.txt")},
+		{"mv dir", cmdmv, []string{"-json", "$PKG", "docs", "texts"}, nil,
+			expectfiles("texts/b.txt", "texts/c.txt")},
+		{"mv absent", cmdmv, []string{"$PKG", "docs", "more"}, ErrNoFile, nil},
+
+		{"rm", cmdrm, []string{"-json", "$PKG", "texts/c.txt"}, nil,
+			expectfiles("texts/c.txt")},
+		{"rm absent", cmdrm, []string{"$PKG", "texts/c.txt"}, ErrNoFile, nil},
+		{"add", cmdadd, []string{"-json", "-prefix", "more", "$PKG", "$DIR/new.txt"}, nil,
+			expectfiles("more/new.txt")},
+
+		{"ls", cmdls, []string{"-json", "$PKG"}, nil,
+			func(t *testing.T, out []byte) {
+				var list []FileItem
+				if err := json.Unmarshal(out, &list); err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, item := range list {
+					names = append(names, item.Name)
+				}
+				sort.Strings(names)
+				if expect := []string{"more/new.txt", "texts/b.txt", "top.txt"}; !reflect.DeepEqual(names, expect) {
+					t.Fatalf("expected files %v, got %v", expect, names)
+				}
+			}},
+
+		// exit code is 1 if errors are found
+		{"verify", cmdverify, []string{"-json", "-hash", "$PKG"}, nil,
+			func(t *testing.T, out []byte) {
+				var list []VerifyResult
+				if err := json.Unmarshal(out, &list); err != nil {
+					t.Fatal(err)
+				}
+				if len(list) != 1 || list[0].Files != 3 || list[0].Findings == nil {
+					t.Fatalf("unexpected verification result %s", out)
+				}
+			}},
+		{"set hash", cmdtags, []string{"set", "$PKG", "top.txt", "crc32c=00000000"}, nil, nil},
+		{"verify broken", cmdverify, []string{"-hash", "$PKG"}, exitcode(1), nil},
+
+		{"build", cmdbuild, []string{"-json", "$DIR/build.lua"}, nil,
+			func(t *testing.T, out []byte) {
+				var list []BuildItem
+				if err := json.Unmarshal(out, &list); err != nil {
+					t.Fatal(err)
+				}
+				if len(list) != 1 || !strings.HasSuffix(list[0].Script, "build.lua") {
+					t.Fatalf("unexpected build result %s", out)
+				}
+			}},
+	}
+
+	defer func() { stdout = os.Stdout }()
+	for _, c := range tests {
+		var args = make([]string, len(c.args))
+		for i, arg := range c.args {
+			arg = strings.ReplaceAll(arg, "$PKG", pkgpath)
+			args[i] = strings.ReplaceAll(arg, "$DIR", dir)
+		}
+		var ok = t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			stdout = &buf
+			var err = c.cmd.Run(context.Background(), args)
+			if c.err == nil && err != nil {
+				t.Fatal(err)
+			}
+			if c.err != nil && !errors.Is(err, c.err) {
+				t.Fatalf("expected error '%v', got '%v'", c.err, err)
+			}
+			if c.check != nil {
+				c.check(t, buf.Bytes())
+			}
+		})
+		if !ok {
+			break // next commands depend on results of previous
+		}
+	}
+}
+
+// Test that repeated tags changes do not grow the package file.
+func TestModifySize(t *testing.T) {
+	var dir = t.TempDir()
+	var srcdir = filepath.Join(dir, "src")
+	var pkgpath = filepath.Join(dir, "test.wpk")
+	if err := os.Mkdir(srcdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcdir, "a.txt"), []byte("content of a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout = &bytes.Buffer{}
+	defer func() { stdout = os.Stdout }()
+
+	if err := cmdpack.Run(context.Background(), []string{"-q", pkgpath, srcdir}); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int64
+	for i := 0; i < 8; i++ {
+		var arg = "comment=edit #" + strings.Repeat("x", i%2+1)
+		if err := cmdtags.Run(context.Background(), []string{"set", pkgpath, "a.txt", arg}); err != nil {
+			t.Fatal(err)
+		}
+		var fi, err = os.Stat(pkgpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, fi.Size())
+	}
+	for i := 4; i < len(sizes); i++ {
+		if sizes[i] != sizes[i-2] {
+			t.Fatalf("package grows on tags changes, sizes: %v", sizes)
+		}
+	}
+}
+
+// The End.
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	lw "github.com/schwarzlichtbezirk/wpk/luawpk"
+)
+
+var cmdextract = &Command{
+	Name:  "extract",
+	Args:  "package destination",
+	Usage: "extract files of package to destination directory",
+}
+
+// patterns is the flag value with list of glob patterns.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ";")
+}
+
+func (p *patterns) Set(s string) error {
+	if _, err := path.Match(s, ""); err != nil {
+		return err
+	}
+	*p = append(*p, s)
+	return nil
+}
+
+func init() {
+	cmdextract.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var orgtime, quiet, hardlink, xattrs bool
+		var include, exclude patterns
+		var safe, overwrite string
+		var workers int
+		var fs = cmdextract.flagset(&com)
+		fs.BoolVar(&orgtime, "ft", false, "set original access and modification times of extracted files")
+		fs.BoolVar(&quiet, "q", false, "do not print extracted files")
+		fs.Var(&include, "inc", "glob pattern of files to extract, can be repeated, all files if it's absent")
+		fs.Var(&exclude, "exc", "glob pattern of files to skip, can be repeated")
+		fs.StringVar(&safe, "safe", "reject", "what to do with file names that refers outside of destination, can be \"reject\", \"skip\" and \"rewrite\"")
+		fs.StringVar(&overwrite, "ow", "always", "what to do with existing files, can be \"always\", \"skip\" and \"newer\"")
+		fs.IntVar(&workers, "workers", 0, "number of parallel workers to write files, number of CPUs if it's zero")
+		fs.BoolVar(&hardlink, "hardlink", false, "restore hard links between files that were packed as hard links")
+		fs.BoolVar(&xattrs, "xattr", false, "restore extended attributes of files, on Linux only")
+		if err = parse(fs, args, 2, 2); err != nil {
+			return
+		}
+		var pp, pok = wpk.PathPolicies[safe]
+		if !pok {
+			return fmt.Errorf("%w: unknown policy for unsafe file names '%s'", ErrArgs, safe)
+		}
+		var op, ook = wpk.OverwritePolicies[overwrite]
+		if !ook {
+			return fmt.Errorf("%w: unknown policy for existing files '%s'", ErrArgs, overwrite)
+		}
+
+		var pkg *wpk.Package
+		if pkg, err = com.openpkg(fs.Arg(0)); err != nil {
+			return
+		}
+		defer pkg.Close()
+
+		var prg wpk.Progress
+		var opts = wpk.ExtractOpts{
+			OrgTime:   orgtime,
+			Include:   include,
+			Exclude:   exclude,
+			Path:      pp,
+			Overwrite: op,
+			Workers:   workers,
+			HardLinks: hardlink,
+			Xattrs:    xattrs,
+			XattrSkip: func(fkey string, names []string) {
+				fmt.Fprintf(os.Stderr, "extended attributes of '%s' are not restored: %s\n", fkey, strings.Join(names, ", "))
+			},
+			Progress: func(p wpk.Progress) {
+				if !quiet && !com.JSON && p.Files > prg.Files {
+					fmt.Fprintf(stdout, "%d/%d %s\n", p.Files, p.FilesTotal, p.FKey)
+				}
+				prg = p
+			},
+		}
+		if err = pkg.ExtractCtx(ctx, wpk.Envfmt(fs.Arg(1), nil), opts); err != nil {
+			return
+		}
+		return com.print(prg, func(w io.Writer) {
+			fmt.Fprintf(w, "extracted %d files on %d bytes\n", prg.Files, prg.Bytes)
+		})
+	}
+}
+
+// SyncResult is the list of changes made by synchronization.
+type SyncResult struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	Bytes     int64    `json:"bytes"`
+}
+
+var cmdsync = &Command{
+	Name:  "sync",
+	Args:  "package destination",
+	Usage: "write new and changed files of package to destination directory",
+}
+
+func init() {
+	cmdsync.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var del, dry bool
+		var include, exclude patterns
+		var safe, secret string
+		var workers int
+		var fs = cmdsync.flagset(&com)
+		fs.BoolVar(&del, "del", false, "delete files of destination that are not present in package")
+		fs.BoolVar(&dry, "dry", false, "only print changes, do not write anything")
+		fs.Var(&include, "inc", "glob pattern of files to synchronize, can be repeated, all files if it's absent")
+		fs.Var(&exclude, "exc", "glob pattern of files to skip, can be repeated, such files are never deleted")
+		fs.StringVar(&safe, "safe", "reject", "what to do with file names that refers outside of destination, can be \"reject\", \"skip\" and \"rewrite\"")
+		fs.StringVar(&secret, "secret", "", "private key to compare MD5 and SHA hashes")
+		fs.IntVar(&workers, "workers", 0, "number of parallel workers to compare and write files, number of CPUs if it's zero")
+		if err = parse(fs, args, 2, 2); err != nil {
+			return
+		}
+		var pp, pok = wpk.PathPolicies[safe]
+		if !pok {
+			return fmt.Errorf("%w: unknown policy for unsafe file names '%s'", ErrArgs, safe)
+		}
+
+		var pkg *wpk.Package
+		if pkg, err = com.openpkg(fs.Arg(0)); err != nil {
+			return
+		}
+		defer pkg.Close()
+
+		var rep *wpk.SyncReport
+		if rep, err = pkg.SyncDirCtx(ctx, wpk.Envfmt(fs.Arg(1), nil), wpk.SyncOpts{
+			Include: include,
+			Exclude: exclude,
+			Path:    pp,
+			Delete:  del,
+			DryRun:  dry,
+			Secret:  []byte(secret),
+			Workers: workers,
+		}); err != nil {
+			return
+		}
+		var res = SyncResult{
+			Added:     append([]string{}, rep.Added...),
+			Updated:   append([]string{}, rep.Updated...),
+			Deleted:   append([]string{}, rep.Deleted...),
+			Unchanged: rep.Unchanged,
+			Bytes:     rep.Bytes,
+		}
+		return com.print(res, func(w io.Writer) {
+			for _, fkey := range res.Added {
+				fmt.Fprintf(w, "+ %s\n", fkey)
+			}
+			for _, fkey := range res.Updated {
+				fmt.Fprintf(w, "* %s\n", fkey)
+			}
+			for _, fkey := range res.Deleted {
+				fmt.Fprintf(w, "- %s\n", fkey)
+			}
+			fmt.Fprintf(w, "%d added, %d updated, %d deleted, %d unchanged files, %d bytes written\n",
+				len(res.Added), len(res.Updated), len(res.Deleted), res.Unchanged, res.Bytes)
+		})
+	}
+}
+
+// FindingItem is the finding of package verification.
+type FindingItem struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	What     string `json:"what"`
+}
+
+// VerifyResult is the result of package verification.
+type VerifyResult struct {
+	Path     string        `json:"path"`
+	Worst    string        `json:"worst"`
+	Files    int           `json:"files"`
+	Blocks   int           `json:"blocks"`
+	Hashed   int           `json:"hashed"`
+	Findings []FindingItem `json:"findings"`
+}
+
+var cmdverify = &Command{
+	Name:  "verify",
+	Args:  "package...",
+	Usage: "check up integrity of packages, exit code is 1 if errors are found",
+}
+
+func init() {
+	cmdverify.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var hashes, all bool
+		var secret string
+		var fs = cmdverify.flagset(&com)
+		fs.BoolVar(&hashes, "hash", false, "verify hashes of files content if they are present")
+		fs.StringVar(&secret, "secret", "", "private key to verify MD5 and SHA hashes")
+		fs.BoolVar(&all, "all", false, "show findings with info severity")
+		if err = parse(fs, args, 1, -1); err != nil {
+			return
+		}
+
+		var opts = wpk.FsckOpts{
+			Hashes: hashes,
+			Secret: []byte(secret),
+		}
+		var worst wpk.Severity
+		var list []VerifyResult
+		for _, pkgpath := range fs.Args() {
+			var rep *wpk.FsckReport
+			if rep, err = wpk.FsckFile(wpk.Envfmt(pkgpath, nil), opts); err != nil {
+				return
+			}
+			var res = VerifyResult{
+				Path:     pkgpath,
+				Worst:    rep.Worst().String(),
+				Files:    rep.Files,
+				Blocks:   rep.Blocks,
+				Hashed:   rep.Hashed,
+				Findings: []FindingItem{},
+			}
+			for _, f := range rep.Findings {
+				if f.Sev == wpk.SevInfo && !all {
+					continue
+				}
+				var item = FindingItem{
+					Severity: f.Sev.String(),
+					File:     f.Key,
+					What:     f.What.Error(),
+				}
+				if f.TID != wpk.TIDnone {
+					item.Tag = tagname(f.TID)
+				}
+				res.Findings = append(res.Findings, item)
+			}
+			if rep.Worst() > worst {
+				worst = rep.Worst()
+			}
+			list = append(list, res)
+		}
+
+		if err = com.print(list, func(w io.Writer) {
+			for _, res := range list {
+				fmt.Fprintf(w, "%s: %s, %d files, %d data blocks, %d hashes\n",
+					res.Path, res.Worst, res.Files, res.Blocks, res.Hashed)
+				for _, item := range res.Findings {
+					fmt.Fprintf(w, "  %s", item.Severity)
+					if item.File != "" {
+						fmt.Fprintf(w, " '%s'", item.File)
+					}
+					if item.Tag != "" {
+						fmt.Fprintf(w, " [%s]", item.Tag)
+					}
+					fmt.Fprintf(w, ": %s\n", item.What)
+				}
+			}
+		}); err != nil {
+			return
+		}
+		if worst >= wpk.SevError {
+			return exitcode(1)
+		}
+		return
+	}
+}
+
+var cmdbuild = &Command{
+	Name:  "build",
+	Args:  "script.lua...",
+	Usage: "run Lua scripts to build packages",
+}
+
+// BuildItem is the Lua script run by build.
+type BuildItem struct {
+	Script  string  `json:"script"`
+	Elapsed float64 `json:"elapsed"` // seconds
+}
+
+func init() {
+	cmdbuild.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var fs = cmdbuild.flagset(&com)
+		if err = parse(fs, args, 1, -1); err != nil {
+			return
+		}
+		var list = []BuildItem{}
+		for _, fpath := range fs.Args() {
+			var t0 = time.Now()
+			if err = lw.RunLuaVM(wpk.Envfmt(fpath, nil)); err != nil {
+				return
+			}
+			list = append(list, BuildItem{
+				Script:  fpath,
+				Elapsed: time.Since(t0).Seconds(),
+			})
+		}
+		return com.print(list, func(w io.Writer) {
+			for _, item := range list {
+				fmt.Fprintf(w, "%s: done in %.3f seconds\n", item.Script, item.Elapsed)
+			}
+		})
+	}
+}
+
+var cmdpack = &Command{
+	Name:  "pack",
+	Args:  "package directory...",
+	Usage: "create new package with content of given directories",
+}
+
+func init() {
+	cmdpack.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var split, putmime, quiet bool
+		var workers int
+		var fs = cmdpack.flagset(&com)
+		fs.BoolVar(&split, "split", false, "write package splitted on tags and data files")
+		fs.BoolVar(&putmime, "mime", false, "put MIME type of files")
+		fs.BoolVar(&quiet, "q", false, "do not print packed files")
+		fs.IntVar(&workers, "workers", 0, "number of workers to read files and compute hashes, number of CPUs if it's zero")
+		if err = parse(fs, args, 2, -1); err != nil {
+			return
+		}
+
+		var pkgpath, datpath = wpk.Envfmt(fs.Arg(0), nil), ""
+		if split {
+			pkgpath, datpath = wpk.MakeTagsPath(pkgpath), wpk.MakeDataPath(pkgpath)
+		}
+		for _, dir := range fs.Args()[1:] {
+			if ok, _ := wpk.DirExists(wpk.Envfmt(dir, nil)); !ok {
+				return fmt.Errorf("%w: directory '%s' does not exist", ErrArgs, dir)
+			}
+		}
+
+		var wpt, wpf *os.File
+		if wpt, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			return
+		}
+		defer wpt.Close()
+		var w io.WriteSeeker = wpt
+		if split {
+			if wpf, err = os.OpenFile(datpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+				return
+			}
+			defer wpf.Close()
+			w = wpf
+		}
+
+		var pkg = wpk.NewPackage()
+		if split {
+			err = pkg.Begin(wpt, wpf)
+		} else {
+			err = pkg.Begin(wpt, nil)
+		}
+		if err != nil {
+			return
+		}
+
+		var res = EditResult{Path: fs.Arg(0), Files: []string{}}
+		var opts = wpk.PackOpts{
+			Workers: workers,
+			Hook:    res.hook,
+		}
+		if putmime {
+			opts.Mime = lw.DetectMime
+		}
+		if !quiet && !com.JSON {
+			opts.Progress = func(p wpk.Progress) {
+				fmt.Fprintf(stdout, "%d/%d %s\n", p.Files, p.FilesTotal, p.FKey)
+			}
+		}
+		for _, dir := range fs.Args()[1:] {
+			if _, err = pkg.PackDirCtx(ctx, w, wpk.Envfmt(dir, nil), "", opts); err != nil {
+				if errors.Is(err, context.Canceled) {
+					fmt.Fprintln(os.Stderr, "packing was interrupted, written files can be restored by recovery")
+				}
+				return
+			}
+		}
+		if split {
+			err = pkg.Sync(wpt, wpf)
+		} else {
+			err = pkg.Sync(wpt, nil)
+		}
+		if err != nil {
+			return
+		}
+		return com.print(res, func(w io.Writer) {
+			fmt.Fprintf(w, "packed %d files on %d bytes\n", len(res.Files), res.Bytes)
+		})
+	}
+}
+
+// The End.
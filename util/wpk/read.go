@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/auto"
+)
+
+// FileItem is the file description at list.
+type FileItem struct {
+	Name  string            `json:"name"`
+	Size  int64             `json:"size"`
+	MTime *time.Time        `json:"mtime,omitempty"`
+	Mime  string            `json:"mime,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+var cmdls = &Command{
+	Name:  "ls",
+	Args:  "package [pattern...]",
+	Usage: "list files of package, optionally filtered by glob patterns",
+}
+
+func init() {
+	cmdls.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var long bool
+		var fs = cmdls.flagset(&com)
+		fs.BoolVar(&long, "l", false, "long format with size, modification time, MIME type and all tags")
+		if err = parse(fs, args, 1, -1); err != nil {
+			return
+		}
+
+		var pkg *wpk.Package
+		if pkg, err = com.openpkg(fs.Arg(0)); err != nil {
+			return
+		}
+		defer pkg.Close()
+
+		var patterns = fs.Args()[1:]
+		var list []FileItem
+		for _, fkey := range pkg.Keys() {
+			if len(patterns) > 0 {
+				var matched bool
+				for _, pattern := range patterns {
+					if matched, err = path.Match(pattern, fkey); err != nil {
+						return
+					}
+					if matched {
+						break
+					}
+				}
+				if !matched {
+					continue
+				}
+			}
+			var ts, _ = pkg.GetTagset(fkey)
+			var item = FileItem{
+				Name: fkey,
+				Size: ts.Size(),
+			}
+			if mtime, ok := ts.TagTime(wpk.TIDmtime); ok {
+				item.MTime = &mtime
+			}
+			item.Mime, _ = ts.TagStr(wpk.TIDmime)
+			if long {
+				item.Tags = TagsMap(ts)
+			}
+			list = append(list, item)
+		}
+
+		return com.print(list, func(w io.Writer) {
+			if !long {
+				for _, item := range list {
+					fmt.Fprintln(w, item.Name)
+				}
+				return
+			}
+			var tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+			for _, item := range list {
+				var mtime = "-"
+				if item.MTime != nil {
+					mtime = item.MTime.Local().Format(time.DateTime)
+				}
+				var mime = item.Mime
+				if mime == "" {
+					mime = "-"
+				}
+				var extra []string
+				for name, val := range item.Tags {
+					switch name {
+					case "offset", "size", "path", "mtime", "mime":
+						continue
+					}
+					extra = append(extra, name+"="+val)
+				}
+				sort.Strings(extra)
+				fmt.Fprintf(tw, "%d\t %s\t %s\t %s\t\n", item.Size, mtime, mime, item.Name)
+				if len(extra) > 0 {
+					tw.Flush()
+					fmt.Fprintf(w, "    %s\n", strings.Join(extra, " "))
+				}
+			}
+			tw.Flush()
+		})
+	}
+}
+
+var cmdcat = &Command{
+	Name:  "cat",
+	Args:  "package file...",
+	Usage: "write content of files to standard output",
+}
+
+func init() {
+	cmdcat.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var fs = cmdcat.flagset(&com)
+		if err = parse(fs, args, 2, -1); err != nil {
+			return
+		}
+
+		var pkg *wpk.Package
+		if pkg, err = com.openpkg(fs.Arg(0)); err != nil {
+			return
+		}
+		defer pkg.Close()
+
+		for _, fkey := range fs.Args()[1:] {
+			var ts, ok = pkg.GetTagset(fkey)
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrNoFile, fkey)
+			}
+			if err = func() (err error) {
+				var f wpk.RFile
+				if f, err = pkg.OpenTagset(ts); err != nil {
+					return
+				}
+				defer f.Close()
+				_, err = io.Copy(stdout, f)
+				return
+			}(); err != nil {
+				return
+			}
+		}
+		return
+	}
+}
+
+// PkgInfo is the package header and info.
+type PkgInfo struct {
+	Path       string            `json:"path"`
+	Splitted   bool              `json:"splitted"`
+	Count      int               `json:"count"`
+	FttOffset  uint              `json:"fttoffset"`
+	FttSize    uint              `json:"fttsize"`
+	DataOffset uint              `json:"datoffset"`
+	DataSize   uint              `json:"datsize"`
+	Info       map[string]string `json:"info,omitempty"`
+
+	info wpk.TagsetRaw
+}
+
+var cmdinfo = &Command{
+	Name:  "info",
+	Args:  "package...",
+	Usage: "print header of package and tags of package info",
+}
+
+func init() {
+	cmdinfo.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var fs = cmdinfo.flagset(&com)
+		if err = parse(fs, args, 1, -1); err != nil {
+			return
+		}
+
+		var list []PkgInfo
+		for _, pkgpath := range fs.Args() {
+			var pi PkgInfo
+			if pi, err = pkginfo(pkgpath); err != nil {
+				return
+			}
+			list = append(list, pi)
+		}
+
+		var v any = list
+		if len(list) == 1 {
+			v = list[0]
+		}
+		return com.print(v, func(w io.Writer) {
+			for i, pi := range list {
+				if i > 0 {
+					fmt.Fprintln(w)
+				}
+				fmt.Fprintf(w, "package: %s\n", pi.Path)
+				fmt.Fprintf(w, "splitted: %t\n", pi.Splitted)
+				fmt.Fprintf(w, "files: %d\n", pi.Count)
+				fmt.Fprintf(w, "tags table: %d bytes at %d\n", pi.FttSize, pi.FttOffset)
+				fmt.Fprintf(w, "data: %d bytes at %d\n", pi.DataSize, pi.DataOffset)
+				if len(pi.Info) > 0 {
+					fmt.Fprintln(w, "info:")
+					printtags(w, "  ", pi.info)
+				}
+			}
+		})
+	}
+}
+
+// pkginfo reads header and info of package.
+func pkginfo(pkgpath string) (pi PkgInfo, err error) {
+	pi.Path = pkgpath
+	var f *os.File
+	if f, err = os.Open(auto.PkgPath(wpk.Envfmt(pkgpath, nil))); err != nil {
+		return
+	}
+	defer f.Close()
+
+	var hdr wpk.Header
+	var ts wpk.TagsetRaw
+	if hdr, ts, err = wpk.GetPackageInfo(f); err != nil {
+		return
+	}
+	pi.Splitted = hdr.DataOffset() == 0
+	pi.Count = hdr.Count()
+	pi.FttOffset, pi.FttSize = hdr.FttOffset(), hdr.FttSize()
+	pi.DataOffset, pi.DataSize = hdr.DataOffset(), hdr.DataSize()
+	if ts.Num() > 0 {
+		pi.Info, pi.info = TagsMap(ts), ts
+	}
+	return
+}
+
+// The End.
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/schwarzlichtbezirk/wpk"
+)
+
+var cmdtags = &Command{
+	Name:  "tags",
+	Args:  "package file [tag[=value]...]",
+	Usage: "get, set or delete tags of file, file \".\" refers to package info",
+}
+
+// parsetid returns tag identifier by name, and checks up that
+// the tag is not one of tags that defines file placement.
+func parsetid(name string, write bool) (wpk.TID, error) {
+	var tid, ok = wpk.ParseTid(name)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrTagName, name)
+	}
+	if write && (tid == wpk.TIDoffset || tid == wpk.TIDsize || tid == wpk.TIDpath) {
+		return 0, fmt.Errorf("%w: %s", ErrProtected, name)
+	}
+	return tid, nil
+}
+
+// gettagset returns tagset of file, or package info for "." name.
+func gettagset(pkg *wpk.Package, fkey string) (wpk.TagsetRaw, error) {
+	if fkey == "." {
+		return pkg.GetInfo(), nil
+	}
+	if ts, ok := pkg.GetTagset(fkey); ok {
+		return ts, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoFile, fkey)
+}
+
+// settagset puts tagset of file, or package info for "." name.
+func settagset(pkg *wpk.Package, fkey string, ts wpk.TagsetRaw) {
+	if fkey == "." {
+		pkg.SetInfo(ts)
+	} else {
+		pkg.SetTagset(fkey, ts)
+	}
+}
+
+func init() {
+	cmdtags.Run = func(ctx context.Context, args []string) (err error) {
+		var com Common
+		var fs = cmdtags.flagset(&com)
+		var op string
+		if len(args) > 0 {
+			op, args = args[0], args[1:]
+		}
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: wpk tags get|set|del [flags] %s\n%s\n", cmdtags.Args, cmdtags.Usage)
+			fs.PrintDefaults()
+		}
+		if err = parse(fs, args, 2, -1); err != nil {
+			return
+		}
+		var pkgpath, fkey = fs.Arg(0), fs.Arg(1)
+		var targs = fs.Args()[2:]
+
+		switch op {
+		case "get":
+			var pkg *wpk.Package
+			if pkg, err = com.openpkg(pkgpath); err != nil {
+				return
+			}
+			defer pkg.Close()
+
+			var ts wpk.TagsetRaw
+			if ts, err = gettagset(pkg, fkey); err != nil {
+				return
+			}
+			if len(targs) > 0 {
+				var sel wpk.TagsetRaw
+				for _, name := range targs {
+					var tid wpk.TID
+					if tid, err = parsetid(name, false); err != nil {
+						return
+					}
+					if tag, ok := ts.Get(tid); ok {
+						sel = sel.Put(tid, tag)
+					}
+				}
+				ts = sel
+			}
+			return com.print(TagsMap(ts), func(w io.Writer) {
+				printtags(w, "", ts)
+			})
+
+		case "set":
+			if len(targs) == 0 {
+				fs.Usage()
+				return exitcode(2)
+			}
+			var tags wpk.TagsetRaw
+			for _, arg := range targs {
+				var name, val, ok = strings.Cut(arg, "=")
+				if !ok {
+					return fmt.Errorf("%w: tag '%s' has no value", ErrArgs, arg)
+				}
+				var tid wpk.TID
+				if tid, err = parsetid(name, true); err != nil {
+					return
+				}
+				var tag wpk.TagRaw
+				if tag, err = wpk.ParseTag(tid, val); err != nil {
+					return
+				}
+				tags = tags.Put(tid, tag)
+			}
+			return modify(pkgpath, func(pkg *wpk.Package, w io.WriteSeeker) (err error) {
+				var ts wpk.TagsetRaw
+				if ts, err = gettagset(pkg, fkey); err != nil {
+					return
+				}
+				ts = wpk.CopyTagset(ts)
+				var tsi = tags.Iterator()
+				for tsi.Next() {
+					ts = ts.Set(tsi.TID(), tsi.Tag())
+				}
+				settagset(pkg, fkey, ts)
+				return
+			})
+
+		case "del":
+			if len(targs) == 0 {
+				fs.Usage()
+				return exitcode(2)
+			}
+			var tids []wpk.TID
+			for _, name := range targs {
+				var tid wpk.TID
+				if tid, err = parsetid(name, true); err != nil {
+					return
+				}
+				tids = append(tids, tid)
+			}
+			return modify(pkgpath, func(pkg *wpk.Package, w io.WriteSeeker) (err error) {
+				var ts wpk.TagsetRaw
+				if ts, err = gettagset(pkg, fkey); err != nil {
+					return
+				}
+				ts = wpk.CopyTagset(ts)
+				for _, tid := range tids {
+					ts = ts.Del(tid)
+				}
+				settagset(pkg, fkey, ts)
+				return
+			})
+
+		default:
+			return fmt.Errorf("%w: unknown operation '%s', expected get, set or del", ErrArgs, op)
+		}
+	}
+}
+
+// The End.
@@ -1,12 +1,15 @@
 package wpk
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -57,6 +60,8 @@ const (
 	TIDversion  TID = 114 // string
 	TIDauthor   TID = 115 // string
 	TIDcomment  TID = 116 // string
+	TIDhardlink TID = 117 // string, name of file which data is shared as hard link
+	TIDxattr    TID = 118 // binary, extended attributes of file, see XattrTag
 )
 
 // ErrTag is error on some field of tags set.
@@ -88,6 +93,7 @@ var (
 	ErrNoSize   = errors.New("file size is absent")
 	ErrOutOff   = errors.New("file offset is out of bounds")
 	ErrOutSize  = errors.New("file size is out of bounds")
+	ErrFidDup   = errors.New("file ID is already used by other file")
 
 	ErrOtherSubdir = errors.New("directory refers to other workspace")
 )
@@ -144,11 +150,21 @@ func (hdr *Header) Count() int {
 	return int(hdr.fttcount)
 }
 
+// FttOffset returns package files tagset table offset from header.
+func (hdr *Header) FttOffset() uint {
+	return uint(hdr.fttoffset)
+}
+
 // FttSize returns package files tagset table size from header.
 func (hdr *Header) FttSize() uint {
 	return uint(hdr.fttsize)
 }
 
+// DataOffset returns package data offset from header.
+func (hdr *Header) DataOffset() uint {
+	return uint(hdr.datoffset)
+}
+
 // DataSize returns package data size from header.
 func (hdr *Header) DataSize() uint {
 	return uint(hdr.datsize)
@@ -258,17 +274,33 @@ type FTT struct {
 	info TagsetRaw                 // special tagset with package tags
 	tsm  SeqMap[string, TagsetRaw] // keys - package filenames (case sensitive), values - tagset slices.
 
+	fttoffset uint64 // offset of the last written file tags table
+	fttsize   uint64 // size of the last written file tags table
 	datoffset uint64 // files data offset
 	datsize   uint64 // files data total size
 
-	mux sync.Mutex // writer mutex
+	Indexed    bool       // build secondary indexes on open
+	SafeAppend bool       // keep the last tags table of single package file on append
+	idx        *Index     // secondary indexes, nil if they are not built
+	idxmux     sync.Mutex // indexes synchronization mutex
+
+	mux      sync.Mutex // writer mutex
+	creating bool       // file created by Create call is not closed yet
 }
 
 // Init performs initialization for given Package structure.
 func (ftt *FTT) Init(hdr *Header) {
 	ftt.info = nil
 	ftt.tsm.Init(int(hdr.fttcount))
+	ftt.idxmux.Lock()
+	if ftt.Indexed {
+		ftt.idx = NewIndex()
+	} else {
+		ftt.idx = nil
+	}
+	ftt.idxmux.Unlock()
 	// update data offset/pos
+	ftt.fttoffset, ftt.fttsize = hdr.fttoffset, hdr.fttsize
 	ftt.datoffset, ftt.datsize = hdr.datoffset, hdr.datsize
 }
 
@@ -302,6 +334,7 @@ func (ftt *FTT) SetInfo(ts TagsetRaw) {
 
 // CheckTagset tests path & offset & size tags existence
 // and checks that size & offset is are in the bounds.
+// If secondary indexes are built, checks up also file ID uniqueness.
 func (ftt *FTT) CheckTagset(ts TagsetRaw) (fkey string, err error) {
 	var offset, size uint
 	var ispath, isoffset, issize bool
@@ -341,6 +374,15 @@ func (ftt *FTT) CheckTagset(ts TagsetRaw) (fkey string, err error) {
 		return
 	}
 
+	// check up file ID uniqueness
+	ftt.idxmux.Lock()
+	var idx = ftt.idx
+	ftt.idxmux.Unlock()
+	if idx != nil && !idx.CheckFID(ts) {
+		err = &ErrTag{ErrFidDup, fkey, TIDfid}
+		return
+	}
+
 	// check up offset and size
 	if uint64(offset) < ftt.datoffset || uint64(offset) > ftt.datoffset+ftt.datsize {
 		err = &ErrTag{ErrOutOff, fkey, TIDoffset}
@@ -358,9 +400,17 @@ func (ftt *FTT) CheckTagset(ts TagsetRaw) (fkey string, err error) {
 // It's high performance method without extra allocations calls.
 func (ftt *FTT) Parse(buf []byte) (n int64, err error) {
 	{
+		if n+PTStssize > int64(len(buf)) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
 		var tsl = GetU16(buf[n : n+PTStssize])
 		n += PTStssize
 
+		if n+int64(tsl) > int64(len(buf)) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
 		var ts = TagsetRaw(buf[n : n+int64(tsl)])
 		n += int64(tsl)
 
@@ -376,6 +426,10 @@ func (ftt *FTT) Parse(buf []byte) (n int64, err error) {
 	}
 
 	for {
+		if n+PTStssize > int64(len(buf)) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
 		var tsl = GetU16(buf[n : n+PTStssize])
 		n += PTStssize
 
@@ -383,6 +437,10 @@ func (ftt *FTT) Parse(buf []byte) (n int64, err error) {
 			break // end marker was reached
 		}
 
+		if n+int64(tsl) > int64(len(buf)) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
 		var ts = TagsetRaw(buf[n : n+int64(tsl)])
 		n += int64(tsl)
 
@@ -391,7 +449,7 @@ func (ftt *FTT) Parse(buf []byte) (n int64, err error) {
 			return
 		}
 
-		ftt.tsm.Poke(ToSlash(fkey), ts)
+		ftt.poke(ToSlash(fkey), ts)
 	}
 	return
 }
@@ -445,7 +503,7 @@ func (ftt *FTT) ReadFrom(r io.Reader) (n int64, err error) {
 			return
 		}
 
-		ftt.tsm.Poke(ToSlash(fkey), ts)
+		ftt.poke(ToSlash(fkey), ts)
 	}
 	return
 }
@@ -625,17 +683,17 @@ func (pkg *Package) GetTagset(fkey string) (TagsetRaw, bool) {
 
 // SetTagset puts tagset with given filename key.
 func (pkg *Package) SetTagset(fkey string, ts TagsetRaw) {
-	pkg.tsm.Poke(pkg.FullPath(ToSlash(fkey)), ts)
+	pkg.poke(pkg.FullPath(ToSlash(fkey)), ts)
 }
 
 // SetupTagset puts tagset with filename key stored at tagset.
 func (pkg *Package) SetupTagset(ts TagsetRaw) {
-	pkg.tsm.Poke(ts.Path(), ts)
+	pkg.poke(ts.Path(), ts)
 }
 
 // GetDelTagset deletes the tagset for a key, returning the previous tagset if any.
 func (pkg *Package) DelTagset(fkey string) (TagsetRaw, bool) {
-	return pkg.tsm.Delete(pkg.FullPath(ToSlash(fkey)))
+	return pkg.delete(pkg.FullPath(ToSlash(fkey)))
 }
 
 // Enum calls given closure for each tagset in package. Skips package info.
@@ -649,6 +707,29 @@ func (pkg *Package) Enum(f func(string, TagsetRaw) bool) {
 	})
 }
 
+// Keys returns sorted list of all file names in package.
+func (pkg *Package) Keys() []string {
+	var list = make([]string, 0, pkg.TagsetNum())
+	pkg.Enum(func(fkey string, ts TagsetRaw) bool {
+		list = append(list, fkey)
+		return true
+	})
+	sort.Strings(list)
+	return list
+}
+
+// KeysSum returns SHA-256 checksum of sorted list of file names
+// in package as hex string. It helps to check up that package
+// contains exactly expected set of files.
+func (pkg *Package) KeysSum() string {
+	var h = sha256.New()
+	for _, fkey := range pkg.Keys() {
+		h.Write(S2B(fkey))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // Sub clones object and gives access to pointed subdirectory.
 // fs.SubFS implementation.
 func (pkg *Package) Sub(dir string) (sub fs.FS, err error) {
@@ -1,6 +1,7 @@
 package wpk
 
 import (
+	"bytes"
 	"io"
 	"io/fs"
 	"os"
@@ -16,6 +17,24 @@ type WriteSeekCloser interface {
 	io.Closer
 }
 
+// syncer is implemented by files that can commit written content to stable storage.
+type syncer interface {
+	Sync() error
+}
+
+// truncater is implemented by files that can change its size.
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// flush commits written content of given writer to stable storage if it's supported.
+func flush(w io.Writer) error {
+	if f, ok := w.(syncer); ok {
+		return f.Sync()
+	}
+	return nil
+}
+
 // Begin writes prebuild header for new empty package.
 func (ftt *FTT) Begin(wpt, wpf io.WriteSeeker) (err error) {
 	ftt.mux.Lock()
@@ -41,29 +60,45 @@ func (ftt *FTT) Begin(wpt, wpf io.WriteSeeker) (err error) {
 		return
 	}
 	// update data offset/pos
+	ftt.fttoffset, ftt.fttsize = hdr.fttoffset, hdr.fttsize
 	ftt.datoffset, ftt.datsize = hdr.datoffset, hdr.datsize
 	return
 }
 
-// Append writes prebuild header for previously opened package to append new files.
+// Append prepares previously opened package to append new files.
+// Splitted package keeps the last consistent state until Sync.
+// For single package file new data replaces file tags table, and
+// header gets prebuild signature until Sync, so package can not be
+// read if appending was interrupted. With SafeAppend header and file
+// tags table stay untouched until Sync, and new data is placed after
+// the last tags table, so this table remains as unused space at data
+// section if any data is written.
 func (ftt *FTT) Append(wpt, wpf io.WriteSeeker) (err error) {
 	ftt.mux.Lock()
 	defer ftt.mux.Unlock()
 
-	// go to file start
-	if _, err = wpt.Seek(0, io.SeekStart); err != nil {
-		return
-	}
-	// rewrite prebuild signature
-	if _, err = wpt.Write(S2B(SignBuild)); err != nil {
-		return
-	}
-	// go to tags table start to replace it by new data
+	// go to data end to put new data
 	if wpf != nil && wpf != wpt { // splitted package files
 		if _, err = wpf.Seek(int64(ftt.datoffset+ftt.datsize), io.SeekStart); err != nil {
 			return
 		}
+	} else if ftt.SafeAppend { // single package file, keep the last tags table
+		var end = ftt.datoffset + ftt.datsize
+		if fttend := ftt.fttoffset + ftt.fttsize; fttend > end {
+			end = fttend
+		}
+		if _, err = wpt.Seek(int64(end), io.SeekStart); err != nil {
+			return
+		}
 	} else { // single package file
+		// rewrite prebuild signature
+		if _, err = wpt.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		if _, err = wpt.Write(S2B(SignBuild)); err != nil {
+			return
+		}
+		// go to tags table start to replace it by new data
 		if _, err = wpt.Seek(int64(ftt.datoffset+ftt.datsize), io.SeekStart); err != nil {
 			return
 		}
@@ -72,10 +107,20 @@ func (ftt *FTT) Append(wpt, wpf io.WriteSeeker) (err error) {
 }
 
 // Sync writes actual file tags table and true signature with settings.
+// For splitted package, and for single package file with SafeAppend,
+// new tags table is written without overwriting the previous one, and
+// committed to stable storage before the header rewrite, so any crash
+// leaves the package in the last consistent state. If no data was written
+// after the last tags table, new table is placed before it when there is
+// enough space, so repeated synchronizations do not grow the package.
 func (ftt *FTT) Sync(wpt, wpf io.WriteSeeker) (err error) {
 	ftt.mux.Lock()
 	defer ftt.mux.Unlock()
 
+	if ftt.creating {
+		return ErrFileOpen
+	}
+
 	var fftpos, fftend, datpos, datend int64
 
 	if wpf != nil && wpf != wpt { // splitted package files
@@ -84,19 +129,31 @@ func (ftt *FTT) Sync(wpt, wpf io.WriteSeeker) (err error) {
 		if datend, err = wpf.Seek(0, io.SeekCurrent); err != nil {
 			return
 		}
-		fftpos = HeaderSize
+		if err = flush(wpf); err != nil {
+			return
+		}
 
-		// write file tags table
-		if _, err = wpt.Seek(fftpos, io.SeekStart); err != nil {
+		// prepare file tags table to get its size
+		var buf bytes.Buffer
+		if _, err = ftt.WriteTo(&buf); err != nil {
 			return
 		}
-		if _, err = ftt.WriteTo(wpt); err != nil {
+		// place table before the last one if there is enough space,
+		// or after it otherwise
+		if HeaderSize+uint64(buf.Len()) <= ftt.fttoffset || ftt.fttoffset+ftt.fttsize < HeaderSize {
+			fftpos = HeaderSize
+		} else {
+			fftpos = int64(ftt.fttoffset + ftt.fttsize)
+		}
+
+		// write file tags table
+		if _, err = wpt.Seek(fftpos, io.SeekStart); err != nil {
 			return
 		}
-		// get writer end marker and setup the file tags table size
-		if fftend, err = wpt.Seek(0, io.SeekCurrent); err != nil {
+		if _, err = wpt.Write(buf.Bytes()); err != nil {
 			return
 		}
+		fftend = fftpos + int64(buf.Len())
 	} else { // single package file
 		// get tags table offset as actual end of file
 		datpos = HeaderSize
@@ -105,14 +162,32 @@ func (ftt *FTT) Sync(wpt, wpf io.WriteSeeker) (err error) {
 		}
 		fftpos = datend
 
+		// prepare file tags table to get its size
+		var buf bytes.Buffer
+		if _, err = ftt.WriteTo(&buf); err != nil {
+			return
+		}
+		var olddat, oldftt = ftt.datoffset + ftt.datsize, ftt.fttoffset + ftt.fttsize
+		if ftt.SafeAppend && ftt.fttsize > 0 && oldftt >= olddat && uint64(datend) == oldftt {
+			// no data was written after the last table, so place
+			// the new one before it if there is enough space
+			datend = int64(olddat)
+			if olddat+uint64(buf.Len()) <= ftt.fttoffset {
+				fftpos = datend
+			}
+		}
+
 		// write file tags table
-		if _, err = ftt.WriteTo(wpt); err != nil {
+		if _, err = wpt.Seek(fftpos, io.SeekStart); err != nil {
 			return
 		}
-		// get writer end marker and setup the file tags table size
-		if fftend, err = wpt.Seek(0, io.SeekCurrent); err != nil {
+		if _, err = wpt.Write(buf.Bytes()); err != nil {
 			return
 		}
+		fftend = fftpos + int64(buf.Len())
+	}
+	if err = flush(wpt); err != nil {
+		return
 	}
 
 	// rewrite true header
@@ -130,7 +205,19 @@ func (ftt *FTT) Sync(wpt, wpf io.WriteSeeker) (err error) {
 	if _, err = hdr.WriteTo(wpt); err != nil {
 		return
 	}
+	if err = flush(wpt); err != nil {
+		return
+	}
+	// cut off previous table placed after the new one
+	if fftend < int64(ftt.fttoffset+ftt.fttsize) {
+		if f, ok := wpt.(truncater); ok {
+			if err = f.Truncate(fftend); err != nil {
+				return
+			}
+		}
+	}
 	// update data offset/pos
+	ftt.fttoffset, ftt.fttsize = hdr.fttoffset, hdr.fttsize
 	ftt.datoffset, ftt.datsize = hdr.datoffset, hdr.datsize
 	return
 }
@@ -148,6 +235,10 @@ func (pkg *Package) PackData(w io.WriteSeeker, r io.Reader, fkey string) (ts Tag
 		pkg.mux.Lock()
 		defer pkg.mux.Unlock()
 
+		if pkg.creating {
+			err = &fs.PathError{Op: "packdata", Path: fkey, Err: ErrFileOpen}
+			return
+		}
 		// get offset and put provided data
 		if offset, err = w.Seek(0, io.SeekCurrent); err != nil {
 			return
@@ -0,0 +1,86 @@
+package wpk
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrXattrSize = errors.New("extended attributes do not fit into tag")
+)
+
+// Xattr is extended attribute of file, such as security label or user metadata.
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// XattrTag is extended attributes tag constructor. Attributes are sorted
+// by names, each one is stored as 2-bytes length of name, name, 2-bytes
+// length of value and value. Returns nil for empty list.
+func XattrTag(list []Xattr) TagRaw {
+	if len(list) == 0 {
+		return nil
+	}
+	var sorted = append([]Xattr{}, list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	var size int
+	for _, xa := range sorted {
+		size += 4 + len(xa.Name) + len(xa.Value)
+	}
+	var buf = make([]byte, 0, size)
+	var l [2]byte
+	for _, xa := range sorted {
+		SetU16(l[:], uint16(len(xa.Name)))
+		buf = append(append(buf, l[:]...), xa.Name...)
+		SetU16(l[:], uint16(len(xa.Value)))
+		buf = append(append(buf, l[:]...), xa.Value...)
+	}
+	return buf
+}
+
+// TagXattr is extended attributes tag converter.
+func (t TagRaw) TagXattr() (list []Xattr, ok bool) {
+	for len(t) > 0 {
+		if len(t) < 2 {
+			return nil, false
+		}
+		var nl = int(GetU16(t))
+		if len(t) < 4+nl {
+			return nil, false
+		}
+		var vl = int(GetU16(t[2+nl:]))
+		if len(t) < 4+nl+vl {
+			return nil, false
+		}
+		list = append(list, Xattr{
+			Name:  string(t[2 : 2+nl]),
+			Value: append([]byte{}, t[4+nl:4+nl+vl]...),
+		})
+		t = t[4+nl+vl:]
+	}
+	return list, true
+}
+
+// Xattrs returns list of extended attributes stored at TIDxattr tag.
+func (ts TagsetRaw) Xattrs() ([]Xattr, bool) {
+	if data, ok := ts.Get(TIDxattr); ok {
+		return data.TagXattr()
+	}
+	return nil, false
+}
+
+// Xattr returns value of stored extended attribute with given name.
+func (ts TagsetRaw) Xattr(name string) ([]byte, bool) {
+	var list, _ = ts.Xattrs()
+	for _, xa := range list {
+		if xa.Name == name {
+			return xa.Value, true
+		}
+	}
+	return nil, false
+}
+
+// The End.
@@ -0,0 +1,82 @@
+package wpk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReadXattrs returns extended attributes of file with given path
+// sorted by names. Returns empty list if file system does not support them.
+func ReadXattrs(fpath string) (list []Xattr, err error) {
+	var names []byte
+	if names, err = xattrcall(func(buf []byte) (int, error) {
+		return unix.Listxattr(fpath, buf)
+	}); err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			err = nil
+		}
+		return
+	}
+	var keys = strings.Split(string(names), "\x00")
+	sort.Strings(keys)
+	for _, name := range keys {
+		if name == "" {
+			continue
+		}
+		var val []byte
+		if val, err = xattrcall(func(buf []byte) (int, error) {
+			return unix.Getxattr(fpath, name, buf)
+		}); err != nil {
+			if errors.Is(err, unix.ENODATA) {
+				err = nil
+				continue // attribute was removed after listing
+			}
+			return
+		}
+		list = append(list, Xattr{Name: name, Value: val})
+	}
+	return
+}
+
+// WriteXattrs sets given extended attributes to file with given path.
+// Attributes that can not be set because of permissions, such as "trusted."
+// namespace for unprivileged user, or that are not supported by file system,
+// are skipped, and their names are returned.
+func WriteXattrs(fpath string, list []Xattr) (skipped []string, err error) {
+	for _, xa := range list {
+		if err = unix.Setxattr(fpath, xa.Name, xa.Value, 0); err != nil {
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOTSUP) {
+				skipped = append(skipped, xa.Name)
+				err = nil
+				continue
+			}
+			return skipped, fmt.Errorf("set extended attribute %s: %w", xa.Name, err)
+		}
+	}
+	return
+}
+
+// xattrcall gets the size of result by call with empty buffer,
+// and repeats the call while buffer is too small.
+func xattrcall(f func([]byte) (int, error)) ([]byte, error) {
+	for {
+		var n, err = f(nil)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		var buf = make([]byte, n)
+		if n, err = f(buf); errors.Is(err, unix.ERANGE) {
+			continue // value was enlarged between calls
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// The End.
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package wpk
+
+// ReadXattrs returns extended attributes of file with given path.
+// Extended attributes are not supported on this system, so the list
+// is always empty.
+func ReadXattrs(fpath string) ([]Xattr, error) {
+	return nil, nil
+}
+
+// WriteXattrs sets given extended attributes to file with given path.
+// Extended attributes are not supported on this system, so all of them
+// are skipped, and their names are returned.
+func WriteXattrs(fpath string, list []Xattr) (skipped []string, err error) {
+	for _, xa := range list {
+		skipped = append(skipped, xa.Name)
+	}
+	return
+}
+
+// The End.
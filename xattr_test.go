@@ -0,0 +1,147 @@
+package wpk_test
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/schwarzlichtbezirk/wpk"
+	"github.com/schwarzlichtbezirk/wpk/bulk"
+)
+
+func TestXattrTag(t *testing.T) {
+	var list = []wpk.Xattr{
+		{Name: "user.mime_type", Value: []byte("text/plain")},
+		{Name: "security.selinux", Value: []byte("system_u:object_r:httpd_sys_content_t:s0\x00")},
+		{Name: "user.empty", Value: []byte{}},
+	}
+	var tag = wpk.XattrTag(list)
+	var res, ok = tag.TagXattr()
+	if !ok {
+		t.Fatal("can not decode extended attributes tag")
+	}
+	if !reflect.DeepEqual(res, []wpk.Xattr{list[1], list[2], list[0]}) {
+		t.Fatalf("unexpected decoded attributes %v", res)
+	}
+	if _, ok = tag[:len(tag)-1].TagXattr(); ok {
+		t.Fatal("truncated tag is decoded")
+	}
+	if wpk.XattrTag(nil) != nil {
+		t.Fatal("tag for empty list is not empty")
+	}
+
+	var ts = wpk.TagsetRaw{}.Put(wpk.TIDxattr, tag)
+	if val, ok := ts.Xattr("user.mime_type"); !ok || string(val) != "text/plain" {
+		t.Fatalf("unexpected attribute value '%s'", val)
+	}
+	if _, ok := ts.Xattr("user.absent"); ok {
+		t.Fatal("absent attribute is found")
+	}
+	if _, ok := (wpk.TagsetRaw{}).Xattrs(); ok {
+		t.Fatal("attributes are found at tagset without tag")
+	}
+}
+
+// Test extended attributes packing and restoring.
+func TestXattrs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("extended attributes are supported on Linux only")
+	}
+	var err error
+	var dir = t.TempDir()
+	var fpath = path.Join(dir, "sample.txt")
+	var pkgpath = path.Join(dir, "xattr.wpk")
+	if err = os.WriteFile(fpath, memdata["sample.txt"], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var attrs = []wpk.Xattr{
+		{Name: "user.author", Value: []byte("wpk")},
+		{Name: "user.checksum", Value: []byte{0, 1, 2, 255}},
+	}
+	if skipped, err := wpk.WriteXattrs(fpath, attrs); err != nil || len(skipped) > 0 {
+		t.Skip("extended attributes are not supported by file system:", skipped, err)
+	}
+
+	func() {
+		var fwpk *os.File
+		if fwpk, err = os.OpenFile(pkgpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer fwpk.Close()
+		var pkg = wpk.NewPackage()
+		if err = pkg.Begin(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pkg.PackPipeline(fwpk, []wpk.PackSource{
+			{FKey: "sample.txt", FPath: fpath},
+		}, wpk.PackOpts{Xattrs: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err = pkg.Sync(fwpk, nil); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var pkg = wpk.NewPackage()
+	if err = pkg.OpenFile(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Tagger, err = bulk.MakeTagger(pkgpath); err != nil {
+		t.Fatal(err)
+	}
+	defer pkg.Close()
+
+	var ts, _ = pkg.GetTagset("sample.txt")
+	if val, ok := ts.Xattr("user.checksum"); !ok || !bytes.Equal(val, attrs[1].Value) {
+		t.Fatalf("unexpected stored attribute value %v", val)
+	}
+
+	var extract = func(xattrs bool) []wpk.Xattr {
+		t.Helper()
+		var dstdir = t.TempDir()
+		if err = pkg.Extract(dstdir, wpk.ExtractOpts{Xattrs: xattrs}); err != nil {
+			t.Fatal(err)
+		}
+		var list []wpk.Xattr
+		if list, err = wpk.ReadXattrs(path.Join(dstdir, "sample.txt")); err != nil {
+			t.Fatal(err)
+		}
+		var user []wpk.Xattr
+		for _, xa := range list {
+			if len(xa.Name) > 5 && xa.Name[:5] == "user." {
+				user = append(user, xa)
+			}
+		}
+		return user
+	}
+	if list := extract(false); len(list) != 0 {
+		t.Fatalf("attributes are restored without option: %v", list)
+	}
+	if list := extract(true); !reflect.DeepEqual(list, attrs) {
+		t.Fatalf("unexpected restored attributes %v", list)
+	}
+
+	// attributes that can not be set are skipped
+	ts = ts.Set(wpk.TIDxattr, wpk.XattrTag(append([]wpk.Xattr{
+		{Name: "bogus.attr", Value: []byte("none")},
+	}, attrs...)))
+	pkg.SetTagset("sample.txt", ts)
+	var skipped []string
+	if err = pkg.Extract(t.TempDir(), wpk.ExtractOpts{
+		Xattrs: true,
+		XattrSkip: func(fkey string, names []string) {
+			skipped = append(skipped, fkey+":"+strings.Join(names, ","))
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skipped, []string{"sample.txt:bogus.attr"}) {
+		t.Fatalf("unexpected skipped attributes %v", skipped)
+	}
+}
+
+// The End.